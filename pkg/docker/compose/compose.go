@@ -0,0 +1,151 @@
+// Package compose compares the deployed containers of a node with the containers the plugin would create.
+package compose
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.blockdaemon.com/bpm/sdk/pkg/docker"
+)
+
+// ContainerConfigDiff describes a single configuration value that differs between a deployed and a desired container
+type ContainerConfigDiff struct {
+	Container string
+	Field     string
+	Deployed  string
+	Desired   string
+}
+
+// ComposeDiff is the difference between the deployed and the desired containers of a node
+//
+// All container names are full docker names, including the node prefix.
+type ComposeDiff struct {
+	// Desired containers that don't exist
+	Missing []string
+	// Containers that belong to the node but are not desired
+	ExtraContainers []string
+	// Configuration values of existing containers that differ from the desired configuration
+	MismatchedConfig []ContainerConfigDiff
+}
+
+// Empty returns true if the deployed containers match the desired containers
+func (d ComposeDiff) Empty() bool {
+	return len(d.Missing) == 0 && len(d.ExtraContainers) == 0 && len(d.MismatchedConfig) == 0
+}
+
+func (d ComposeDiff) String() string {
+	output := ""
+
+	for _, name := range d.Missing {
+		output += fmt.Sprintf("Missing container: %s\n", name)
+	}
+
+	for _, name := range d.ExtraContainers {
+		output += fmt.Sprintf("Extra container: %s\n", name)
+	}
+
+	for _, mismatch := range d.MismatchedConfig {
+		output += fmt.Sprintf("Container %s differs in %s:\n  deployed: %s\n  desired:  %s\n", mismatch.Container, mismatch.Field, mismatch.Deployed, mismatch.Desired)
+	}
+
+	return output
+}
+
+// Diff compares the deployed containers of a node with the desired containers
+//
+// Image, command, user, mounts and environment variables are compared. Because the deployed environment also
+// contains variables defined in the image, only desired variables that are missing or have a different value
+// are reported. An empty desired command means the image default is used and is not compared.
+func Diff(ctx context.Context, mgr *docker.BasicManager, desired []docker.Container) (*ComposeDiff, error) {
+	diff := &ComposeDiff{
+		Missing:          []string{},
+		ExtraContainers:  []string{},
+		MismatchedConfig: []ContainerConfigDiff{},
+	}
+
+	desiredNames := map[string]bool{}
+
+	for _, container := range desired {
+		desiredConfig, err := mgr.DesiredContainerConfig(container)
+		if err != nil {
+			return nil, err
+		}
+		desiredNames[desiredConfig.Name] = true
+
+		deployedConfig, exists, err := mgr.DeployedContainerConfig(ctx, container.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		if !exists {
+			diff.Missing = append(diff.Missing, desiredConfig.Name)
+			continue
+		}
+
+		diff.MismatchedConfig = append(diff.MismatchedConfig, configDiffs(deployedConfig, desiredConfig)...)
+	}
+
+	deployedNames, err := mgr.ListNodeContainerNames(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(deployedNames)
+
+	for _, name := range deployedNames {
+		if !desiredNames[name] {
+			diff.ExtraContainers = append(diff.ExtraContainers, name)
+		}
+	}
+
+	return diff, nil
+}
+
+func configDiffs(deployed, desired docker.ContainerConfig) []ContainerConfigDiff {
+	diffs := []ContainerConfigDiff{}
+
+	add := func(field, deployedValue, desiredValue string) {
+		diffs = append(diffs, ContainerConfigDiff{
+			Container: desired.Name,
+			Field:     field,
+			Deployed:  deployedValue,
+			Desired:   desiredValue,
+		})
+	}
+
+	if deployed.Image != desired.Image {
+		add("image", deployed.Image, desired.Image)
+	}
+
+	if len(desired.Cmd) > 0 && strings.Join(deployed.Cmd, " ") != strings.Join(desired.Cmd, " ") {
+		add("cmd", strings.Join(deployed.Cmd, " "), strings.Join(desired.Cmd, " "))
+	}
+
+	if deployed.User != desired.User {
+		add("user", deployed.User, desired.User)
+	}
+
+	if strings.Join(deployed.Mounts, ",") != strings.Join(desired.Mounts, ",") {
+		add("mounts", strings.Join(deployed.Mounts, ","), strings.Join(desired.Mounts, ","))
+	}
+
+	deployedEnv := map[string]string{}
+	for _, entry := range deployed.Env {
+		deployedEnv[strings.SplitN(entry, "=", 2)[0]] = entry
+	}
+
+	for _, entry := range desired.Env {
+		// Variables without a value are passed through from the docker daemon environment, we can't compare them
+		if !strings.Contains(entry, "=") {
+			continue
+		}
+
+		name := strings.SplitN(entry, "=", 2)[0]
+		if deployedEnv[name] != entry {
+			add("env "+name, deployedEnv[name], entry)
+		}
+	}
+
+	return diffs
+}
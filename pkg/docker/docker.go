@@ -19,42 +19,262 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"html/template"
+	"io"
 	"io/ioutil"
+	"net/http"
+	"net/url"
 	"os"
 	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/docker/docker/api/types"
 	dockercontainer "github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/versions"
+	volumetypes "github.com/docker/docker/api/types/volume"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/docker/go-connections/nat"
+	"github.com/docker/go-connections/tlsconfig"
+	units "github.com/docker/go-units"
+	"github.com/thoas/go-funk"
+	"go.blockdaemon.com/bpm/sdk/pkg/docker/image"
+	"go.blockdaemon.com/bpm/sdk/pkg/fileutil"
 	"go.blockdaemon.com/bpm/sdk/pkg/node"
+	"go.blockdaemon.com/bpm/sdk/pkg/progress"
+	"go.blockdaemon.com/bpm/sdk/pkg/secrets"
 	sdktemplate "go.blockdaemon.com/bpm/sdk/pkg/template"
 )
 
+const (
+	// NodeIDLabel is the label used to mark docker resources with the ID of the node that created them
+	NodeIDLabel = "com.blockdaemon.bpm.node-id"
+	// ConfigHashLabel is the label used to record the hash of the configuration files a container was created with
+	ConfigHashLabel = "com.blockdaemon.bpm.config-hash"
+
+	// PullPolicyAlways pulls the image every time a container gets created
+	PullPolicyAlways = "always"
+	// PullPolicyIfNotPresent only pulls the image if it doesn't exist locally
+	PullPolicyIfNotPresent = "if-not-present"
+
+	// MountTypeEncryptedSecret mounts a file encrypted with the secrets package. It is decrypted into memory backed
+	// storage before the container starts and mounted read only. From is the path of the encrypted file.
+	MountTypeEncryptedSecret = "encrypted-secret"
+
+	// Image used for helper containers, e.g. to back up and restore volumes
+	helperImage = "alpine:3.10"
+)
+
 type BasicManager struct {
 	cli         *client.Client
 	currentNode node.Node
+	// The docker daemon runs on a different host, i.e. it cannot access local paths
+	remote bool
+	// nil to use the emitter of the context
+	emitter progress.Emitter
+}
+
+// ClientOptions configures how BasicManager connects to the docker daemon and reports progress
+type ClientOptions struct {
+	// URL of the docker daemon, e.g. "tcp://10.0.0.1:2376"
+	Host string
+	// Paths to the TLS certificates used to connect to the docker daemon
+	TLSCAPath   string
+	TLSCertPath string
+	TLSKeyPath  string
+	// Verify the certificate of the docker daemon
+	TLSVerify bool
+	// Docker API version. If empty, the version is negotiated with the docker daemon
+	APIVersion string
+	// Receives progress events. If nil, the emitter attached to the context of each call is used
+	ProgressEmitter progress.Emitter
+}
+
+// BasicManagerOption is a functional option to configure how BasicManager connects to the docker daemon
+type BasicManagerOption func(*ClientOptions)
+
+// WithHost connects to the docker daemon at host (e.g. "tcp://10.0.0.1:2376")
+func WithHost(host string) BasicManagerOption {
+	return func(o *ClientOptions) {
+		o.Host = host
+	}
+}
+
+// WithTLS connects to the docker daemon using TLS with the supplied certificates
+func WithTLS(caPath, certPath, keyPath string) BasicManagerOption {
+	return func(o *ClientOptions) {
+		o.TLSCAPath = caPath
+		o.TLSCertPath = certPath
+		o.TLSKeyPath = keyPath
+		o.TLSVerify = true
+	}
+}
+
+// WithProgressEmitter sends progress events (e.g. of image pulls) to e, see package progress
+func WithProgressEmitter(e progress.Emitter) BasicManagerOption {
+	return func(o *ClientOptions) {
+		o.ProgressEmitter = e
+	}
+}
+
+// WithAPIVersion uses a fixed docker API version instead of negotiating it
+func WithAPIVersion(version string) BasicManagerOption {
+	return func(o *ClientOptions) {
+		o.APIVersion = version
+	}
+}
+
+// NewBasicManager creates a BasicManager, giving the docker daemon 30 seconds to respond
+//
+// See NewBasicManagerWithContext, which should be used if a context is available.
+func NewBasicManager(currentNode node.Node, options ...BasicManagerOption) (*BasicManager, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return NewBasicManagerWithContext(ctx, currentNode, options...)
 }
 
-// NewBasicManager creates a BasicManager
-func NewBasicManager(currentNode node.Node) (*BasicManager, error) {
-	cli, err := client.NewEnvClient()
+// NewBasicManagerWithContext creates a BasicManager and checks that the docker daemon is reachable
+//
+// The connection settings are taken from the standard docker environment variables (DOCKER_HOST, DOCKER_CERT_PATH,
+// DOCKER_TLS_VERIFY, DOCKER_API_VERSION). They can be overridden using the node parameters `docker-host` and
+// `docker-cert-path` and finally by the options. ctx is only used to connect to the daemon.
+func NewBasicManagerWithContext(ctx context.Context, currentNode node.Node, options ...BasicManagerOption) (*BasicManager, error) {
+	clientOptions := clientOptionsFromEnv()
+
+	if host := currentNode.StrParameters["docker-host"]; host != "" {
+		clientOptions.Host = host
+	}
+	if certPath := currentNode.StrParameters["docker-cert-path"]; certPath != "" {
+		WithTLS(filepath.Join(certPath, "ca.pem"), filepath.Join(certPath, "cert.pem"), filepath.Join(certPath, "key.pem"))(&clientOptions)
+	}
+
+	for _, option := range options {
+		option(&clientOptions)
+	}
+
+	cli, err := newClient(clientOptions)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("cannot create docker client for '%s': %s", clientOptions.Host, err)
+	}
+
+	// Fail early with a helpful error if the daemon is not reachable and negotiate the API version while we're at it
+	ping, err := cli.Ping(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to the docker daemon at '%s': %s", clientOptions.Host, err)
+	}
+
+	if clientOptions.APIVersion == "" && ping.APIVersion != "" && versions.LessThan(ping.APIVersion, cli.ClientVersion()) {
+		cli.UpdateClientVersion(ping.APIVersion)
 	}
 
 	return &BasicManager{
 		cli:         cli,
 		currentNode: currentNode,
+		remote:      isRemoteHost(clientOptions.Host),
+		emitter:     clientOptions.ProgressEmitter,
 	}, nil
 }
 
+// IsRemote returns true if the docker daemon runs on a different host
+//
+// A remote daemon cannot bind mount files from this host, e.g. the node directory or decrypted secrets.
+func (bm *BasicManager) IsRemote() bool {
+	return bm.remote
+}
+
+// isRemoteHost returns true if the docker daemon at host doesn't run on this host
+func isRemoteHost(host string) bool {
+	hostURL, err := url.Parse(host)
+	if err != nil {
+		return true
+	}
+
+	switch hostURL.Scheme {
+	case "unix", "npipe":
+		return false
+	}
+
+	switch hostURL.Hostname() {
+	case "localhost", "127.0.0.1", "::1":
+		return false
+	}
+
+	return true
+}
+
+// ServerAPIVersion returns the API version of the docker daemon
+func (bm *BasicManager) ServerAPIVersion(ctx context.Context) (string, error) {
+	version, err := bm.cli.ServerVersion(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return version.APIVersion, nil
+}
+
+func clientOptionsFromEnv() ClientOptions {
+	clientOptions := ClientOptions{
+		Host:       os.Getenv("DOCKER_HOST"),
+		APIVersion: os.Getenv("DOCKER_API_VERSION"),
+	}
+
+	if certPath := os.Getenv("DOCKER_CERT_PATH"); certPath != "" {
+		clientOptions.TLSCAPath = filepath.Join(certPath, "ca.pem")
+		clientOptions.TLSCertPath = filepath.Join(certPath, "cert.pem")
+		clientOptions.TLSKeyPath = filepath.Join(certPath, "key.pem")
+		clientOptions.TLSVerify = os.Getenv("DOCKER_TLS_VERIFY") != ""
+	}
+
+	if clientOptions.Host == "" {
+		clientOptions.Host = client.DefaultDockerHost
+	}
+
+	return clientOptions
+}
+
+func newClient(clientOptions ClientOptions) (*client.Client, error) {
+	var httpClient *http.Client
+
+	if clientOptions.TLSCAPath != "" || clientOptions.TLSCertPath != "" || clientOptions.TLSKeyPath != "" {
+		tlsc, err := tlsconfig.Client(tlsconfig.Options{
+			CAFile:             clientOptions.TLSCAPath,
+			CertFile:           clientOptions.TLSCertPath,
+			KeyFile:            clientOptions.TLSKeyPath,
+			InsecureSkipVerify: !clientOptions.TLSVerify,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		httpClient = &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: tlsc,
+			},
+		}
+	}
+
+	version := clientOptions.APIVersion
+	if version == "" {
+		version = client.DefaultVersion
+	}
+
+	return client.NewClient(clientOptions.Host, version, httpClient, nil)
+}
+
 func (bm *BasicManager) prefixedName(name string) string {
 	// make sure we don't accidentally double-prefix it
 	if strings.HasPrefix(name, bm.currentNode.NamePrefix()) {
@@ -65,18 +285,53 @@ func (bm *BasicManager) prefixedName(name string) string {
 }
 
 // AddBasePath adds the base path if the supplied path is relative
+//
+// `~` and environment variables are expanded as well, see node.Node.ExpandPath.
 func (bm *BasicManager) AddBasePath(myPath string) string {
-	if strings.HasPrefix(myPath, "/") {
-		// absolute path, just return as is
-		return myPath
+	if myPath == "" {
+		return bm.currentNode.NodeDirectory()
 	}
 
-	return path.Join(bm.currentNode.NodeDirectory(), myPath)
+	return bm.currentNode.ExpandPath(myPath)
+}
+
+// ListOptions filters the containers returned by ListContainerNamesWithOptions
+type ListOptions struct {
+	// Only return containers whose name starts with this prefix
+	NamePrefix string
+	// Only return containers that have all these labels
+	Labels map[string]string
+	// Only return running containers
+	OnlyRunning bool
+}
+
+// VolumeListOptions filters the volumes returned by ListVolumeIDsWithOptions
+type VolumeListOptions struct {
+	// Only return volumes whose name starts with this prefix
+	NamePrefix string
+	// Only return volumes that have all these labels
+	Labels map[string]string
 }
 
 // ListContainerNames lists all containers by name
 func (bm *BasicManager) ListContainerNames(ctx context.Context) ([]string, error) {
-	containers, err := bm.cli.ContainerList(ctx, types.ContainerListOptions{All: true})
+	return bm.ListContainerNamesWithOptions(ctx, ListOptions{})
+}
+
+// ListContainerNamesWithOptions lists containers by name
+//
+// The filtering happens in the docker daemon so only matching containers get returned.
+func (bm *BasicManager) ListContainerNamesWithOptions(ctx context.Context, options ListOptions) ([]string, error) {
+	filter := labelFilter(options.Labels)
+	if options.NamePrefix != "" {
+		// Docker names have a "/" in front of them
+		filter.Add("name", "^/"+regexp.QuoteMeta(options.NamePrefix))
+	}
+	if options.OnlyRunning {
+		filter.Add("status", "running")
+	}
+
+	containers, err := bm.cli.ContainerList(ctx, types.ContainerListOptions{All: !options.OnlyRunning, Filters: filter})
 	if err != nil {
 		return nil, err
 	}
@@ -96,9 +351,54 @@ func (bm *BasicManager) ListContainerNames(ctx context.Context) ([]string, error
 	return cleanNames, nil
 }
 
+// ListNodeContainerNames lists all containers created for the current node by name
+//
+// Containers are identified by NodeIDLabel, this includes containers that are not part of the plugin anymore.
+func (bm *BasicManager) ListNodeContainerNames(ctx context.Context) ([]string, error) {
+	return bm.ListContainerNamesWithOptions(ctx, ListOptions{
+		Labels: map[string]string{NodeIDLabel: bm.currentNode.ID},
+	})
+}
+
+// ListNodeImages returns the images (as referenced when the container was created, e.g. by tag) of all containers
+// created for the current node
+//
+// The map keys are the container names without the node prefix, i.e. Container.Name.
+func (bm *BasicManager) ListNodeImages(ctx context.Context) (map[string]string, error) {
+	containers, err := bm.cli.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
+		Filters: labelFilter(map[string]string{NodeIDLabel: bm.currentNode.ID}),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	images := map[string]string{}
+	for _, container := range containers {
+		for _, name := range container.Names {
+			// Docker names have a "/" in front of them
+			images[strings.TrimPrefix(name[1:], bm.currentNode.NamePrefix())] = container.Image
+		}
+	}
+
+	return images, nil
+}
+
 // ListVolumeIDs lists all volumes by name (which is also a unique id)
 func (bm *BasicManager) ListVolumeIDs(ctx context.Context) ([]string, error) {
-	volumesListOKBody, err := bm.cli.VolumeList(ctx, filters.Args{})
+	return bm.ListVolumeIDsWithOptions(ctx, VolumeListOptions{})
+}
+
+// ListVolumeIDsWithOptions lists volumes by name (which is also a unique id)
+//
+// The filtering happens in the docker daemon so only matching volumes get returned.
+func (bm *BasicManager) ListVolumeIDsWithOptions(ctx context.Context, options VolumeListOptions) ([]string, error) {
+	filter := labelFilter(options.Labels)
+	if options.NamePrefix != "" {
+		filter.Add("name", "^"+regexp.QuoteMeta(options.NamePrefix))
+	}
+
+	volumesListOKBody, err := bm.cli.VolumeList(ctx, filter)
 	if err != nil {
 		return nil, err
 	}
@@ -112,6 +412,35 @@ func (bm *BasicManager) ListVolumeIDs(ctx context.Context) ([]string, error) {
 	return names, nil
 }
 
+func labelFilter(labels map[string]string) filters.Args {
+	filter := filters.NewArgs()
+	for key, value := range labels {
+		filter.Add("label", key+"="+value)
+	}
+
+	return filter
+}
+
+func (bm *BasicManager) listRunningContainersUsingVolume(ctx context.Context, volumeName string) ([]string, error) {
+	filter := filters.NewArgs()
+	filter.Add("volume", volumeName)
+	filter.Add("status", "running")
+
+	containers, err := bm.cli.ContainerList(ctx, types.ContainerListOptions{Filters: filter})
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, container := range containers {
+		for _, name := range container.Names {
+			names = append(names, name[1:])
+		}
+	}
+
+	return names, nil
+}
+
 // ContainerStopped stops a container if it is running
 func (bm *BasicManager) ContainerStopped(ctx context.Context, container Container) error {
 	prefixedName := bm.prefixedName(container.Name)
@@ -176,7 +505,9 @@ func (bm *BasicManager) NetworkAbsent(ctx context.Context, networkID string) err
 	return bm.cli.NetworkRemove(ctx, networkID)
 }
 
-// VolumeAbsent removes a network if it exists
+// VolumeAbsent removes a volume if it exists
+//
+// A volume that is still used by a running container will not be removed.
 func (bm *BasicManager) VolumeAbsent(ctx context.Context, volumeID string) error {
 	exists, err := bm.doesVolumeExist(ctx, volumeID)
 	if err != nil {
@@ -190,6 +521,14 @@ func (bm *BasicManager) VolumeAbsent(ctx context.Context, volumeID string) error
 		return nil
 	}
 
+	runningContainers, err := bm.listRunningContainersUsingVolume(ctx, prefixedName)
+	if err != nil {
+		return err
+	}
+	if len(runningContainers) > 0 {
+		return fmt.Errorf("cannot remove volume '%s' because it is used by running containers: %s", prefixedName, strings.Join(runningContainers, ", "))
+	}
+
 	fmt.Printf("Removing volume '%s'\n", prefixedName)
 	return bm.cli.VolumeRemove(ctx, prefixedName, false)
 }
@@ -207,16 +546,41 @@ func (bm *BasicManager) NetworkExists(ctx context.Context, networkID string) err
 	}
 
 	fmt.Printf("Creating network '%s'\n", networkID)
-	_, err = bm.cli.NetworkCreate(ctx, networkID, types.NetworkCreate{CheckDuplicate: true})
+	_, err = bm.cli.NetworkCreate(ctx, networkID, types.NetworkCreate{
+		CheckDuplicate: true,
+		Labels:         map[string]string{NodeIDLabel: bm.currentNode.ID},
+	})
 
 	return err
 }
 
+// CanRemoveNetwork returns true if the network exists, was created by the current node and no containers are connected to it
+func (bm *BasicManager) CanRemoveNetwork(ctx context.Context, networkID string) (bool, error) {
+	networkResource, err := bm.cli.NetworkInspect(ctx, networkID)
+	if err != nil {
+		if client.IsErrNetworkNotFound(err) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	if networkResource.Labels[NodeIDLabel] != bm.currentNode.ID {
+		return false, nil
+	}
+
+	return len(networkResource.Containers) == 0, nil
+}
+
 // Mount defines a docker volume mount
 type Mount struct {
-	Type string
-	From string
-	To   string
+	// "bind", "volume" or MountTypeEncryptedSecret
+	Type     string
+	From     string
+	To       string
+	ReadOnly bool
+	// Propagation is only used for bind mounts, e.g. "rprivate" (default), "rshared" or "rslave"
+	Propagation string
 }
 
 // Port defines a forwarded docker port
@@ -227,22 +591,209 @@ type Port struct {
 	Protocol      string
 }
 
+// MetricsEndpoint defines where a container exposes prometheus metrics
+type MetricsEndpoint struct {
+	Port   string
+	Path   string // Defaults to "/metrics"
+	Scheme string // Defaults to "http"
+}
+
+// ContainerUlimit is a resource limit (see `ulimit`) of a container, e.g. "nofile" for the number of open files
+type ContainerUlimit struct {
+	Name string
+	Soft int64
+	Hard int64
+}
+
+// UnlimitedFileDescriptors returns a ulimit that allows a practically unlimited number of open files, which nodes
+// with many peers or heavy I/O need
+func UnlimitedFileDescriptors() ContainerUlimit {
+	return ContainerUlimit{Name: "nofile", Soft: 1048576, Hard: 1048576}
+}
+
+// LogRotation defines how docker rotates the logs of a container
+type LogRotation struct {
+	// Maximum size of a log file before it gets rotated, e.g. "10m". Defaults to the node parameter
+	// LogMaxSizeParameter or "10m"
+	MaxSize string
+	// Maximum number of log files kept. Defaults to the node parameter LogMaxFilesParameter or 3
+	MaxFileCount int
+	// Signal (e.g. "SIGHUP") sent to the container when logs are rotated manually. This is useful for clients
+	// that write their own log files and re-open them on a signal. If empty, the container is not signaled.
+	Signal string
+	// Directory (relative to the node directory or absolute) with log files written by the client. Files in it that
+	// were not modified for TruncateOlderThan get truncated when logs are rotated manually. If empty, nothing is truncated.
+	TruncateDir       string
+	TruncateOlderThan time.Duration
+}
+
+// Docker log drivers
+const (
+	LogDriverJSONFile = "json-file"
+	LogDriverLocal    = "local"
+	LogDriverJournald = "journald"
+)
+
+// Node parameters that set the default log rotation of all containers
+const (
+	LogMaxSizeParameter  = "log-max-size"
+	LogMaxFilesParameter = "log-max-files"
+)
+
+// LogConfig defines which docker log driver a container uses
+type LogConfig struct {
+	// Log driver, e.g. LogDriverJournald. Defaults to LogDriverJSONFile. Filebeat can only collect logs written
+	// by json-file, containers using another driver cannot set CollectLogs.
+	Driver string
+	// Driver specific options, e.g. "tag" for journald. For json-file and local, "max-size" and "max-file"
+	// default to the values from LogRotation.
+	Options map[string]string
+}
+
+// EnvTemplateSuffix marks env files (Container.EnvFilename) that are rendered as templates, e.g. "node.env.tpl"
+const EnvTemplateSuffix = ".tpl"
+
 // Container defines all parameters used to create a container
+//
+// Environment variables can come from an env file (EnvFilename) and from Env. The env file provides the base,
+// Env overrides variables with the same name. Within the env file later lines win. The env file supports the usual
+// .env syntax (comments, quotes, `export`, `${NAME}` references to variables defined earlier in the file). If its
+// name ends with EnvTemplateSuffix, it is rendered as a template with the node data first.
 type Container struct {
 	Name        string
 	Image       string
 	EnvFilename string
+	Env         map[string]string
 	Mounts      []Mount
 	Ports       []Port
 	Cmd         []string
 	CmdFile     string
 	User        string
 	CollectLogs bool
+	Metrics     *MetricsEndpoint
+	LogRotation LogRotation
+	LogConfig   LogConfig
+	// PullPolicy defines when the image gets pulled (PullPolicyAlways or PullPolicyIfNotPresent). Defaults to PullPolicyAlways
+	PullPolicy string
+	// Additional docker labels. NodeIDLabel is always set
+	Labels map[string]string
+	// Docker networks the container is attached to. Defaults to the node network (see node.Node.DockerNetwork)
+	Networks []string
+	// Linux capabilities added to or dropped from the docker defaults, e.g. "NET_RAW" or "ALL". Only add what the
+	// client really needs, capabilities like SYS_ADMIN or BPF give the container a lot of control over the host.
+	CapAdd  []string
+	CapDrop []string
+	// Privileged gives the container all capabilities and access to all host devices. This effectively gives it root
+	// access to the host, prefer adding single capabilities with CapAdd.
+	Privileged bool
+	// DNS servers and search domains used instead of the docker defaults, e.g. for private resolvers
+	DNS       []string
+	DNSSearch []string
+	// Additional /etc/hosts entries in the form "hostname:IP"
+	ExtraHosts []string
+	// Resource limits replacing the docker defaults, e.g. UnlimitedFileDescriptors()
+	Ulimits []ContainerUlimit
+	// Namespaced kernel parameters, e.g. "net.core.somaxconn". "kernel.*" parameters require Privileged.
+	Sysctls map[string]string
+}
+
+// ContainerConfig is the part of a container configuration that is compared to detect configuration drift
+type ContainerConfig struct {
+	// Full docker name, including the node prefix
+	Name  string
+	Image string
+	Env   []string
+	Cmd   []string
+	User  string
+	// Mounts in the form "<source>:<target>", read only mounts have ":ro" appended
+	Mounts []string
+}
+
+// DesiredContainerConfig returns the configuration a container would be created with
+func (bm *BasicManager) DesiredContainerConfig(container Container) (ContainerConfig, error) {
+	envs, err := bm.containerEnv(container)
+	if err != nil {
+		return ContainerConfig{}, err
+	}
+
+	cmd, err := bm.containerCmd(container)
+	if err != nil {
+		return ContainerConfig{}, err
+	}
+
+	mounts := []string{}
+	for _, mountParam := range container.Mounts {
+		from, err := bm.MountSource(mountParam)
+		if err != nil {
+			return ContainerConfig{}, err
+		}
+
+		readOnly := mountParam.ReadOnly || mountParam.Type == MountTypeEncryptedSecret
+		mounts = append(mounts, mountString(from, mountParam.To, readOnly))
+	}
+	sort.Strings(mounts)
+
+	return ContainerConfig{
+		Name:   bm.prefixedName(container.Name),
+		Image:  container.Image,
+		Env:    envs,
+		Cmd:    cmd,
+		User:   container.User,
+		Mounts: mounts,
+	}, nil
+}
+
+// DeployedContainerConfig returns the configuration of an existing container
+//
+// The second return value is false if the container doesn't exist. Please note that Env also contains the
+// environment variables defined in the image.
+func (bm *BasicManager) DeployedContainerConfig(ctx context.Context, containerName string) (ContainerConfig, bool, error) {
+	inspect, err := bm.cli.ContainerInspect(ctx, bm.prefixedName(containerName))
+	if err != nil {
+		if client.IsErrContainerNotFound(err) {
+			return ContainerConfig{}, false, nil
+		}
+
+		return ContainerConfig{}, false, err
+	}
+
+	mounts := []string{}
+	for _, mountPoint := range inspect.Mounts {
+		source := mountPoint.Source
+		if mountPoint.Type == mount.TypeVolume {
+			source = mountPoint.Name
+		}
+
+		mounts = append(mounts, mountString(source, mountPoint.Destination, !mountPoint.RW))
+	}
+	sort.Strings(mounts)
+
+	return ContainerConfig{
+		Name:   bm.prefixedName(containerName),
+		Image:  inspect.Config.Image,
+		Env:    inspect.Config.Env,
+		Cmd:    inspect.Config.Cmd,
+		User:   inspect.Config.User,
+		Mounts: mounts,
+	}, true, nil
+}
+
+func mountString(source, target string, readOnly bool) string {
+	if readOnly {
+		return source + ":" + target + ":ro"
+	}
+
+	return source + ":" + target
 }
 
 // ContainerRuns creates and starts a container if it doesn't exist/run yet
 func (bm *BasicManager) ContainerRuns(ctx context.Context, container Container) error {
-	if err := bm.pullImage(ctx, container.Image); err != nil {
+	if err := bm.ImagePulled(ctx, container); err != nil {
+		return err
+	}
+
+	// Decrypted secrets get removed when the node stops, they are needed for starting existing containers as well
+	if err := bm.encryptedSecretsDecrypted(container); err != nil {
 		return err
 	}
 
@@ -256,9 +807,17 @@ func (bm *BasicManager) ContainerRuns(ctx context.Context, container Container)
 	if !exists {
 		fmt.Printf("Creating container '%s'\n", prefixedName)
 
-		if err := bm.createContainer(ctx, container); err != nil {
+		if err := bm.createContainer(ctx, container, nil); err != nil {
 			return err
 		}
+		progress.Emit(ctx, bm.emitter, progress.StageCreate, prefixedName, "created container", 100)
+
+		// Don't leave a created but never started container behind if we get interrupted
+		defer func() {
+			if ctx.Err() != nil {
+				bm.removeInterruptedContainer(container)
+			}
+		}()
 	} else {
 		fmt.Printf("Container '%s' already exists, skipping creation\n", prefixedName)
 	}
@@ -273,6 +832,7 @@ func (bm *BasicManager) ContainerRuns(ctx context.Context, container Container)
 		if err := bm.cli.ContainerStart(ctx, prefixedName, types.ContainerStartOptions{}); err != nil {
 			return err
 		}
+		progress.Emit(ctx, bm.emitter, progress.StageStart, prefixedName, "started container", 100)
 	} else {
 		fmt.Printf("Container '%s' already runs, skipping start\n", prefixedName)
 	}
@@ -280,60 +840,385 @@ func (bm *BasicManager) ContainerRuns(ctx context.Context, container Container)
 	return nil
 }
 
-// RunTransientContainer runs a container once and removes it after it is finished.
-func (bm *BasicManager) RunTransientContainer(ctx context.Context, container Container) (string, error) {
-	// See: https://docs.docker.com/develop/sdk/examples/
+// encryptedSecretsDecrypted decrypts all MountTypeEncryptedSecret mounts of a container
+//
+// The secrets are decrypted on this host, a remote docker daemon cannot mount them.
+func (bm *BasicManager) encryptedSecretsDecrypted(container Container) error {
+	for _, mountParam := range container.Mounts {
+		if mountParam.Type != MountTypeEncryptedSecret {
+			continue
+		}
 
-	if err := bm.pullImage(ctx, container.Image); err != nil {
-		return "", err
+		if bm.remote {
+			return fmt.Errorf("cannot mount encrypted secret %q in container '%s', encrypted secrets are not supported with a remote docker daemon", mountParam.From, bm.prefixedName(container.Name))
+		}
+
+		secretPath, err := bm.encryptedSecretPath(mountParam)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Decrypting secret %q for container '%s'\n", secretPath, bm.prefixedName(container.Name))
+		if _, err := secrets.PlaintextWritten(bm.currentNode, secretPath); err != nil {
+			return err
+		}
 	}
 
-	exists, err := bm.doesContainerExist(ctx, container.Name)
+	return nil
+}
+
+// removeInterruptedContainer removes a container whose start got interrupted
+//
+// The original context is already cancelled at this point so a new one is used.
+func (bm *BasicManager) removeInterruptedContainer(container Container) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+
+	running, err := bm.IsContainerRunning(ctx, container.Name)
+	if err != nil || running {
+		return
+	}
+
+	fmt.Printf("Start of container '%s' got interrupted, removing it\n", bm.prefixedName(container.Name))
+	if err := bm.ContainerAbsent(ctx, container); err != nil {
+		fmt.Printf("Cannot remove container '%s': %s\n", bm.prefixedName(container.Name), err)
+	}
+}
+
+// ContainerRunsWithConfigHash works like ContainerRuns but also recreates the container if the configuration files changed
+//
+// The hash of the configuration files (paths relative to the node directory or absolute) is stored in ConfigHashLabel.
+// If an existing container has a different hash it gets removed and created again so it picks up the new configuration.
+func (bm *BasicManager) ContainerRunsWithConfigHash(ctx context.Context, container Container, configFiles ...string) error {
+	hash, err := bm.configHash(configFiles)
 	if err != nil {
-		return "", err
+		return err
 	}
 
 	prefixedName := bm.prefixedName(container.Name)
 
-	if !exists {
-		fmt.Printf("Creating container '%s'\n", prefixedName)
+	inspect, err := bm.cli.ContainerInspect(ctx, prefixedName)
+	if err != nil && !client.IsErrContainerNotFound(err) {
+		return err
+	}
+	if err == nil && inspect.Config != nil && inspect.Config.Labels[ConfigHashLabel] != hash {
+		fmt.Printf("Configuration of container '%s' changed, recreating it\n", prefixedName)
 
-		if err := bm.createContainer(ctx, container); err != nil {
-			return "", err
+		if err := bm.ContainerAbsent(ctx, container); err != nil {
+			return err
 		}
-	} else {
-		fmt.Printf("Container '%s' already exists, skipping creation\n", prefixedName)
 	}
 
-	running, err := bm.IsContainerRunning(ctx, container.Name)
-	if err != nil {
-		return "", err
+	labels := map[string]string{ConfigHashLabel: hash}
+	for key, value := range container.Labels {
+		labels[key] = value
 	}
-	if !running {
-		fmt.Printf("Starting container '%s'\n", prefixedName)
+	container.Labels = labels
 
-		if err := bm.cli.ContainerStart(ctx, prefixedName, types.ContainerStartOptions{}); err != nil {
+	return bm.ContainerRuns(ctx, container)
+}
+
+// configHash returns a hash over the content of all files
+func (bm *BasicManager) configHash(files []string) (string, error) {
+	hash := sha256.New()
+
+	for _, file := range files {
+		content, err := ioutil.ReadFile(bm.AddBasePath(file))
+		if err != nil {
 			return "", err
 		}
-	} else {
-		fmt.Printf("Container '%s' already runs, skipping start\n", prefixedName)
+
+		// Include the file name so moving content between files changes the hash
+		fmt.Fprintf(hash, "%s\x00%d\x00", file, len(content))
+		hash.Write(content)
 	}
 
-	defer func() {
-		// Removing the container after it's done
-		if err := bm.ContainerAbsent(ctx, container); err != nil {
-			panic(err)
-		}
-	}()
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
 
-	status, err := bm.cli.ContainerWait(ctx, prefixedName)
+// VolumeBackup archives the content of a volume into dstFile (a *.tar.gz file)
+func (bm *BasicManager) VolumeBackup(ctx context.Context, volumeID string, dstFile string) error {
+	dstFile, err := filepath.Abs(dstFile)
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	// Get stdout and stderr
-	outReader, err := bm.cli.ContainerLogs(ctx, prefixedName, types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true})
-	if err != nil {
+	fmt.Printf("Backing up volume '%s' to %q\n", bm.prefixedName(volumeID), dstFile)
+
+	_, err = bm.RunTransientContainer(ctx, Container{
+		Name:  "backup-" + volumeID,
+		Image: helperImage,
+		Cmd:   []string{"tar", "czf", "/backup/" + filepath.Base(dstFile), "-C", "/volume", "."},
+		Mounts: []Mount{
+			{Type: "volume", From: volumeID, To: "/volume", ReadOnly: true},
+			{Type: "bind", From: filepath.Dir(dstFile), To: "/backup"},
+		},
+		PullPolicy: PullPolicyIfNotPresent,
+	})
+
+	return err
+}
+
+// RootOwnedDirectoryAbsent removes a directory that may contain files owned by root, e.g. written by a container
+// running as root
+//
+// The files are removed from within a helper container because the current user may not be allowed to remove them.
+func (bm *BasicManager) RootOwnedDirectoryAbsent(ctx context.Context, dir string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	exists, err := fileutil.FileExists(dir)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		fmt.Printf("Cannot find directory %q, skipping removal\n", dir)
+		return nil
+	}
+
+	fmt.Printf("Removing directory %q\n", dir)
+
+	_, err = bm.RunTransientContainer(ctx, Container{
+		Name:  "remove-" + filepath.Base(dir),
+		Image: helperImage,
+		Cmd:   []string{"rm", "-rf", "/parent/" + filepath.Base(dir)},
+		Mounts: []Mount{
+			{Type: "bind", From: filepath.Dir(dir), To: "/parent"},
+		},
+		PullPolicy: PullPolicyIfNotPresent,
+	})
+
+	return err
+}
+
+// VolumeInitMountPoint is where the volume is mounted in the helper container passed to the initFn of
+// EnsureVolumePresentWithInit
+const VolumeInitMountPoint = "/volume"
+
+// EnsureVolumePresentWithInit creates a volume if it doesn't exist yet and populates it using initFn
+//
+// initFn is only called when the volume gets created. It gets the name of a created but not started helper container
+// that mounts the volume at VolumeInitMountPoint and should fill it with the initial content (e.g. a genesis snapshot),
+// usually using CopyToContainer. This works with remote docker daemons as well. The helper container is removed
+// afterwards. If initFn fails, the volume is removed again so the next call starts over.
+func (bm *BasicManager) EnsureVolumePresentWithInit(ctx context.Context, volumeID string, initFn func(ctx context.Context, containerName string) error) error {
+	prefixedName := bm.prefixedName(volumeID)
+
+	exists, err := bm.doesVolumeExist(ctx, volumeID)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		fmt.Printf("Volume '%s' already exists, skipping creation\n", prefixedName)
+		return nil
+	}
+
+	fmt.Printf("Creating volume '%s'\n", prefixedName)
+
+	_, err = bm.cli.VolumeCreate(ctx, volumetypes.VolumesCreateBody{
+		Name:   prefixedName,
+		Labels: map[string]string{NodeIDLabel: bm.currentNode.ID},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := bm.initializeVolume(ctx, volumeID, initFn); err != nil {
+		fmt.Printf("Initializing volume '%s' failed, removing it\n", prefixedName)
+		if removeErr := bm.cli.VolumeRemove(ctx, prefixedName, false); removeErr != nil {
+			return fmt.Errorf("%s (removing the volume failed as well: %s)", err, removeErr)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// initializeVolume creates a helper container that mounts the volume and calls initFn with it
+func (bm *BasicManager) initializeVolume(ctx context.Context, volumeID string, initFn func(ctx context.Context, containerName string) error) error {
+	helper := Container{
+		Name:  "init-" + volumeID,
+		Image: helperImage,
+		Cmd:   []string{"true"},
+		Mounts: []Mount{
+			{Type: "volume", From: volumeID, To: VolumeInitMountPoint},
+		},
+		PullPolicy: PullPolicyIfNotPresent,
+	}
+
+	if err := bm.ImagePulled(ctx, helper); err != nil {
+		return err
+	}
+
+	// A helper container may be left over from an interrupted run
+	if err := bm.ContainerAbsent(ctx, helper); err != nil {
+		return err
+	}
+
+	if err := bm.createContainer(ctx, helper, &TransientOptions{}); err != nil {
+		return err
+	}
+
+	initErr := initFn(ctx, helper.Name)
+
+	if err := bm.ContainerAbsent(ctx, helper); err != nil && initErr == nil {
+		return err
+	}
+
+	if initErr != nil {
+		return fmt.Errorf("cannot initialize volume '%s': %s", bm.prefixedName(volumeID), initErr)
+	}
+
+	return nil
+}
+
+// volumeRestoreStaging is the directory inside a volume that VolumeRestore extracts the backup into first
+const volumeRestoreStaging = ".bpm-restore"
+
+// VolumeRestore replaces the content of a volume with the content of srcFile (a *.tar.gz file created by VolumeBackup)
+//
+// The volume is created if it doesn't exist yet. The backup is extracted into a staging directory in the volume first,
+// the existing content is only replaced once the extraction succeeded. This needs enough space for both.
+func (bm *BasicManager) VolumeRestore(ctx context.Context, volumeID string, srcFile string) error {
+	srcFile, err := filepath.Abs(srcFile)
+	if err != nil {
+		return err
+	}
+
+	// Checked here as well because a missing file is only noticed by the helper container otherwise
+	if _, err := os.Stat(srcFile); err != nil {
+		return fmt.Errorf("cannot restore volume '%s': %s", bm.prefixedName(volumeID), err)
+	}
+
+	fmt.Printf("Restoring volume '%s' from %q\n", bm.prefixedName(volumeID), srcFile)
+
+	script := `set -e
+staging=/volume/` + volumeRestoreStaging + `
+rm -rf "$staging"
+mkdir "$staging"
+if ! tar xzf /backup/"$0" -C "$staging"; then
+  rm -rf "$staging"
+  exit 1
+fi
+find /volume -mindepth 1 -maxdepth 1 ! -name ` + volumeRestoreStaging + ` -exec rm -rf {} +
+find "$staging" -mindepth 1 -maxdepth 1 -exec mv {} /volume/ \;
+rmdir "$staging"`
+
+	_, err = bm.RunTransientContainer(ctx, Container{
+		Name:  "restore-" + volumeID,
+		Image: helperImage,
+		Cmd:   []string{"sh", "-c", script, filepath.Base(srcFile)},
+		Mounts: []Mount{
+			{Type: "volume", From: volumeID, To: "/volume"},
+			{Type: "bind", From: filepath.Dir(srcFile), To: "/backup", ReadOnly: true},
+		},
+		PullPolicy: PullPolicyIfNotPresent,
+	})
+
+	return err
+}
+
+// TransientOptions limits the runtime and resources of a container run by RunTransientContainerWithOptions
+type TransientOptions struct {
+	// MaxRuntime after which the container gets stopped. 0 means no limit
+	MaxRuntime time.Duration
+	// KillOnTimeout kills the container right away when MaxRuntime is exceeded instead of stopping it gracefully
+	KillOnTimeout bool
+	// Memory limit in bytes. 0 means no limit
+	Memory int64
+	// CPU limit in number of CPUs, e.g. 0.5. 0 means no limit
+	CPUs float64
+}
+
+// TransientTimeoutError is returned by RunTransientContainerWithOptions if a container exceeded its MaxRuntime
+type TransientTimeoutError struct {
+	// Full docker name, including the node prefix
+	Container  string
+	MaxRuntime time.Duration
+}
+
+func (e TransientTimeoutError) Error() string {
+	return fmt.Sprintf("container '%s' did not finish within %s", e.Container, e.MaxRuntime)
+}
+
+// RunTransientContainer runs a container once and removes it after it is finished.
+func (bm *BasicManager) RunTransientContainer(ctx context.Context, container Container) (string, error) {
+	return bm.RunTransientContainerWithOptions(ctx, container, TransientOptions{})
+}
+
+// RunTransientContainerWithOptions runs a container once with limits and removes it after it is finished
+//
+// An existing container with the same name is removed first. Docker never restarts the container. If it runs longer
+// than MaxRuntime it gets stopped (or killed with KillOnTimeout) and the output so far is returned together with a
+// TransientTimeoutError.
+func (bm *BasicManager) RunTransientContainerWithOptions(ctx context.Context, container Container, options TransientOptions) (string, error) {
+	// See: https://docs.docker.com/develop/sdk/examples/
+
+	if err := bm.ImagePulled(ctx, container); err != nil {
+		return "", err
+	}
+
+	// A container with the same name is left over from an interrupted run, it may have been created with different
+	// settings and its output would be mixed into ours
+	if err := bm.ContainerAbsent(ctx, container); err != nil {
+		return "", err
+	}
+
+	prefixedName := bm.prefixedName(container.Name)
+
+	fmt.Printf("Creating container '%s'\n", prefixedName)
+
+	if err := bm.createContainer(ctx, container, &options); err != nil {
+		return "", err
+	}
+	progress.Emit(ctx, bm.emitter, progress.StageCreate, prefixedName, "created container", 100)
+
+	fmt.Printf("Starting container '%s'\n", prefixedName)
+
+	if err := bm.cli.ContainerStart(ctx, prefixedName, types.ContainerStartOptions{}); err != nil {
+		return "", err
+	}
+	progress.Emit(ctx, bm.emitter, progress.StageStart, prefixedName, "started container", 100)
+
+	defer bm.removeTransientContainer(container)
+
+	waitCtx := ctx
+	if options.MaxRuntime > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, options.MaxRuntime)
+		defer cancel()
+	}
+
+	var timeoutErr error
+	status, err := bm.cli.ContainerWait(waitCtx, prefixedName)
+	if err != nil {
+		// Only the MaxRuntime timeout is handled here, the caller's context being done is returned as is
+		if ctx.Err() != nil || waitCtx.Err() != context.DeadlineExceeded {
+			return "", err
+		}
+
+		timeoutErr = TransientTimeoutError{Container: prefixedName, MaxRuntime: options.MaxRuntime}
+
+		if options.KillOnTimeout {
+			fmt.Printf("Container '%s' exceeded its maximum runtime of %s, killing it\n", prefixedName, options.MaxRuntime)
+			err = bm.cli.ContainerKill(ctx, prefixedName, "SIGKILL")
+		} else {
+			fmt.Printf("Container '%s' exceeded its maximum runtime of %s, stopping it\n", prefixedName, options.MaxRuntime)
+			err = bm.cli.ContainerStop(ctx, prefixedName, nil)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// Get stdout and stderr
+	outReader, err := bm.cli.ContainerLogs(ctx, prefixedName, types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true})
+	if err != nil {
 		return "", err
 	}
 	defer outReader.Close()
@@ -343,89 +1228,780 @@ func (bm *BasicManager) RunTransientContainer(ctx context.Context, container Con
 		return outputStr, err
 	}
 
-	if status != 0 {
-		return outputStr, fmt.Errorf("Container '%s' failed with status code: %d", prefixedName, status)
+	if timeoutErr != nil {
+		return outputStr, timeoutErr
+	}
+
+	if status != 0 {
+		return outputStr, fmt.Errorf("Container '%s' failed with status code: %d", prefixedName, status)
+	}
+
+	return outputStr, nil
+}
+
+// removeTransientContainer removes a transient container after it finished
+//
+// The original context may be cancelled already (e.g. by a signal or --timeout) so a new one is used, otherwise the
+// container would be left behind.
+func (bm *BasicManager) removeTransientContainer(container Container) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+
+	if err := bm.ContainerAbsent(ctx, container); err != nil {
+		fmt.Printf("Cannot remove container '%s': %s\n", bm.prefixedName(container.Name), err)
+	}
+}
+
+func (bm *BasicManager) doesContainerExist(ctx context.Context, containerName string) (bool, error) {
+	_, err := bm.cli.ContainerInspect(ctx, bm.prefixedName(containerName))
+	if err != nil {
+		if client.IsErrContainerNotFound(err) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return true, nil
+}
+
+// DoesNetworkExist returns true if the docker network exists
+func (bm *BasicManager) DoesNetworkExist(ctx context.Context, networkID string) (bool, error) {
+	_, err := bm.cli.NetworkInspect(ctx, networkID)
+	if err != nil {
+		if client.IsErrNetworkNotFound(err) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (bm *BasicManager) doesVolumeExist(ctx context.Context, volumeID string) (bool, error) {
+	_, err := bm.cli.VolumeInspect(ctx, bm.prefixedName(volumeID))
+	if err != nil {
+		if client.IsErrVolumeNotFound(err) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (bm *BasicManager) IsContainerRunning(ctx context.Context, containerName string) (bool, error) {
+	inspect, err := bm.cli.ContainerInspect(ctx, bm.prefixedName(containerName))
+	if err != nil {
+		if client.IsErrContainerNotFound(err) {
+			return false, nil // a non existing container is not running!
+		}
+
+		return false, err
+	}
+
+	return inspect.State.Running, nil
+}
+
+// IsContainerPaused returns true if the container exists and is paused
+func (bm *BasicManager) IsContainerPaused(ctx context.Context, containerName string) (bool, error) {
+	inspect, err := bm.cli.ContainerInspect(ctx, bm.prefixedName(containerName))
+	if err != nil {
+		if client.IsErrContainerNotFound(err) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return inspect.State.Paused, nil
+}
+
+// Stats is a snapshot of the resource usage of a container
+type Stats struct {
+	// The container name without the node prefix
+	Name string
+	// CPU usage in percent of a single core, can be above 100 on hosts with multiple cores
+	CPUPercent float64
+	// Memory usage (excluding the page cache) and limit in bytes
+	MemoryUsage uint64
+	MemoryLimit uint64
+	// Bytes received and transmitted over all networks
+	NetworkRx uint64
+	NetworkTx uint64
+}
+
+// ContainerStats returns a single snapshot of the resource usage of a running container
+//
+// The CPU usage is calculated the same way `docker stats` does, as the difference to the previous sample the docker
+// daemon collected.
+func (bm *BasicManager) ContainerStats(ctx context.Context, containerName string) (Stats, error) {
+	response, err := bm.cli.ContainerStats(ctx, bm.prefixedName(containerName), false)
+	if err != nil {
+		return Stats{}, err
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	var statsJSON types.StatsJSON
+	if err := json.Unmarshal(body, &statsJSON); err != nil {
+		return Stats{}, fmt.Errorf("cannot parse stats of container %q: %s", containerName, err)
+	}
+
+	var online onlineCPUStats
+	if err := json.Unmarshal(body, &online); err != nil {
+		return Stats{}, fmt.Errorf("cannot parse stats of container %q: %s", containerName, err)
+	}
+
+	stats := Stats{
+		Name:        containerName,
+		CPUPercent:  cpuPercent(statsJSON, online.CPUStats.OnlineCPUs),
+		MemoryUsage: statsJSON.MemoryStats.Usage,
+		MemoryLimit: statsJSON.MemoryStats.Limit,
+	}
+
+	// The page cache can be reclaimed by the kernel and is not counted as used memory
+	if cache := statsJSON.MemoryStats.Stats["cache"]; cache < stats.MemoryUsage {
+		stats.MemoryUsage -= cache
+	}
+
+	for _, networkStats := range statsJSON.Networks {
+		stats.NetworkRx += networkStats.RxBytes
+		stats.NetworkTx += networkStats.TxBytes
+	}
+
+	return stats, nil
+}
+
+// onlineCPUStats is the number of CPUs reported by newer docker daemons, it is missing in types.StatsJSON
+type onlineCPUStats struct {
+	CPUStats struct {
+		OnlineCPUs uint32 `json:"online_cpus"`
+	} `json:"cpu_stats"`
+}
+
+// cpuPercent calculates the CPU usage between the current and the previous sample
+//
+// The number of CPUs is taken from the per CPU usage. Daemons using cgroups v2 don't report that, onlineCPUs is used
+// instead then.
+func cpuPercent(stats types.StatsJSON, onlineCPUs uint32) float64 {
+	cpuDelta := float64(stats.CPUStats.CPUUsage.TotalUsage) - float64(stats.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(stats.CPUStats.SystemUsage) - float64(stats.PreCPUStats.SystemUsage)
+
+	if cpuDelta <= 0 || systemDelta <= 0 {
+		return 0
+	}
+
+	cpus := float64(len(stats.CPUStats.CPUUsage.PercpuUsage))
+	if cpus == 0 {
+		cpus = float64(onlineCPUs)
+	}
+
+	return cpuDelta / systemDelta * cpus * 100
+}
+
+// CopyToContainer copies a file or a directory from the host into a container
+//
+// Relative source paths are relative to the node directory. dstPath is the absolute path the file or directory gets in
+// the container, its parent directory has to exist already. The container doesn't need to be running.
+func (bm *BasicManager) CopyToContainer(ctx context.Context, containerName, srcPath, dstPath string) error {
+	if !path.IsAbs(dstPath) {
+		return fmt.Errorf("the destination path %q in container %q is not absolute", dstPath, containerName)
+	}
+
+	srcPath = bm.AddBasePath(srcPath)
+	if _, err := os.Lstat(srcPath); err != nil {
+		return err
+	}
+
+	fmt.Printf("Copying '%s' to '%s' in container '%s'\n", srcPath, dstPath, containerName)
+
+	// The docker API expects a tar stream that gets extracted into an existing directory
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(fileutil.WriteTar(writer, srcPath, path.Base(dstPath)))
+	}()
+	defer reader.Close()
+
+	return bm.cli.CopyToContainer(ctx, bm.prefixedName(containerName), path.Dir(dstPath), reader, types.CopyToContainerOptions{})
+}
+
+// CopyFromContainer copies a file or a directory from a container to the host
+//
+// srcPath is the absolute path in the container. Relative destination paths are relative to the node directory.
+// Existing files are overwritten. The container doesn't need to be running.
+func (bm *BasicManager) CopyFromContainer(ctx context.Context, containerName, srcPath, dstPath string) error {
+	dstPath = bm.AddBasePath(dstPath)
+
+	fmt.Printf("Copying '%s' in container '%s' to '%s'\n", srcPath, containerName, dstPath)
+
+	reader, _, err := bm.cli.CopyFromContainer(ctx, bm.prefixedName(containerName), srcPath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	return fileutil.ExtractTar(reader, dstPath)
+}
+
+// ContainerPaused pauses all processes of a running container
+func (bm *BasicManager) ContainerPaused(ctx context.Context, container Container) error {
+	prefixedName := bm.prefixedName(container.Name)
+
+	inspect, err := bm.cli.ContainerInspect(ctx, prefixedName)
+	if err != nil {
+		if client.IsErrContainerNotFound(err) {
+			fmt.Printf("Container '%s' doesn't exist, skipping pause\n", prefixedName)
+			return nil
+		}
+
+		return err
+	}
+
+	if inspect.State.Paused {
+		fmt.Printf("Container '%s' is already paused, skipping pause\n", prefixedName)
+		return nil
+	}
+
+	if !inspect.State.Running {
+		fmt.Printf("Container '%s' is not running, skipping pause\n", prefixedName)
+		return nil
+	}
+
+	fmt.Printf("Pausing container '%s'\n", prefixedName)
+	return bm.cli.ContainerPause(ctx, prefixedName)
+}
+
+// ContainerUnpaused resumes a paused container
+func (bm *BasicManager) ContainerUnpaused(ctx context.Context, container Container) error {
+	prefixedName := bm.prefixedName(container.Name)
+
+	paused, err := bm.IsContainerPaused(ctx, container.Name)
+	if err != nil {
+		return err
+	}
+
+	if !paused {
+		fmt.Printf("Container '%s' is not paused, skipping unpause\n", prefixedName)
+		return nil
+	}
+
+	fmt.Printf("Unpausing container '%s'\n", prefixedName)
+	return bm.cli.ContainerUnpause(ctx, prefixedName)
+}
+
+// ContainerSignal sends a signal (e.g. "SIGHUP") to a container if it is running
+func (bm *BasicManager) ContainerSignal(ctx context.Context, containerName string, signal string) error {
+	prefixedName := bm.prefixedName(containerName)
+
+	running, err := bm.IsContainerRunning(ctx, containerName)
+	if err != nil {
+		return err
+	}
+
+	if !running {
+		fmt.Printf("Container '%s' is not running, skipping sending %s\n", prefixedName, signal)
+		return nil
+	}
+
+	fmt.Printf("Sending %s to container '%s'\n", signal, prefixedName)
+	return bm.cli.ContainerKill(ctx, prefixedName, signal)
+}
+
+// GetContainerLogs returns the last lines (stdout and stderr) of a container
+//
+// If tail is 0 or negative, all lines are returned.
+func (bm *BasicManager) GetContainerLogs(ctx context.Context, containerName string, tail int) (string, error) {
+	options := types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true, Tail: "all"}
+	if tail > 0 {
+		options.Tail = strconv.Itoa(tail)
+	}
+
+	outReader, err := bm.cli.ContainerLogs(ctx, bm.prefixedName(containerName), options)
+	if err != nil {
+		return "", err
+	}
+	defer outReader.Close()
+
+	// Logs of containers without tty are multiplexed, stdcopy splits them up again
+	output := bytes.NewBufferString("")
+	if _, err := stdcopy.StdCopy(output, output, outReader); err != nil {
+		return "", err
+	}
+
+	return output.String(), nil
+}
+
+// ContainerRestartCount returns how often docker restarted a container, 0 if the container doesn't exist
+func (bm *BasicManager) ContainerRestartCount(ctx context.Context, containerName string) (int, error) {
+	inspect, err := bm.cli.ContainerInspect(ctx, bm.prefixedName(containerName))
+	if err != nil {
+		if client.IsErrContainerNotFound(err) {
+			return 0, nil
+		}
+
+		return 0, err
+	}
+
+	return inspect.RestartCount, nil
+}
+
+// ResolveImageDigest returns the ID (e.g. "sha256:...") of the image an existing container was created from
+//
+// Unlike the tag, the ID still references the same image after the tag got updated. An empty string is returned
+// if the container doesn't exist.
+func (bm *BasicManager) ResolveImageDigest(ctx context.Context, containerName string) (string, error) {
+	inspect, err := bm.cli.ContainerInspect(ctx, bm.prefixedName(containerName))
+	if err != nil {
+		if client.IsErrContainerNotFound(err) {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	return inspect.Image, nil
+}
+
+// ContainerStatus returns the docker status of a container ("created", "running", "paused", "restarting", "removing",
+// "exited" or "dead") or an empty string if it doesn't exist
+func (bm *BasicManager) ContainerStatus(ctx context.Context, containerName string) (string, error) {
+	inspect, err := bm.cli.ContainerInspect(ctx, bm.prefixedName(containerName))
+	if err != nil {
+		if client.IsErrContainerNotFound(err) {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	return inspect.State.Status, nil
+}
+
+// ContainerInfo is the state of a container as reported by docker inspect
+type ContainerInfo struct {
+	Exists bool
+	// Full container ID, it changes whenever the container gets recreated
+	ID string
+	// See ContainerStatus
+	Status     string
+	Running    bool
+	Restarting bool
+	// How often docker restarted the container because of its restart policy since it was created
+	RestartCount int
+	// When the container was started last, zero if it never started
+	StartedAt time.Time
+	// Exit code of the last run
+	ExitCode int
+}
+
+// ContainerInfo returns the state of a container, Exists is false if the container doesn't exist
+func (bm *BasicManager) ContainerInfo(ctx context.Context, containerName string) (ContainerInfo, error) {
+	inspect, err := bm.cli.ContainerInspect(ctx, bm.prefixedName(containerName))
+	if err != nil {
+		if client.IsErrContainerNotFound(err) {
+			return ContainerInfo{}, nil
+		}
+
+		return ContainerInfo{}, err
+	}
+
+	info := ContainerInfo{
+		Exists:       true,
+		ID:           inspect.ID,
+		Status:       inspect.State.Status,
+		Running:      inspect.State.Running,
+		Restarting:   inspect.State.Restarting,
+		RestartCount: inspect.RestartCount,
+		ExitCode:     inspect.State.ExitCode,
+	}
+
+	// Docker reports "0001-01-01T00:00:00Z" for containers that never started, which parses to the zero time
+	if startedAt, err := time.Parse(time.RFC3339Nano, inspect.State.StartedAt); err == nil {
+		info.StartedAt = startedAt
+	}
+
+	return info, nil
+}
+
+// HealthNone is the health status of a container whose image doesn't define a health check
+const HealthNone = "none"
+
+// ContainerHealth is the health check state of a container
+type ContainerHealth struct {
+	Exists  bool
+	Running bool
+	// One of "starting", "healthy", "unhealthy" or HealthNone
+	Status string
+	// Number of consecutive failed health checks
+	FailingStreak int
+	// Output of the last health check
+	LastOutput string
+}
+
+// ContainerHealth returns the health check state of a container
+func (bm *BasicManager) ContainerHealth(ctx context.Context, containerName string) (ContainerHealth, error) {
+	inspect, err := bm.cli.ContainerInspect(ctx, bm.prefixedName(containerName))
+	if err != nil {
+		if client.IsErrContainerNotFound(err) {
+			return ContainerHealth{Status: HealthNone}, nil
+		}
+
+		return ContainerHealth{}, err
+	}
+
+	health := ContainerHealth{
+		Exists:  true,
+		Running: inspect.State.Running,
+		Status:  HealthNone,
+	}
+
+	if inspect.State.Health != nil {
+		health.Status = inspect.State.Health.Status
+		health.FailingStreak = inspect.State.Health.FailingStreak
+
+		if results := inspect.State.Health.Log; len(results) > 0 {
+			health.LastOutput = strings.TrimSpace(results[len(results)-1].Output)
+		}
+	}
+
+	return health, nil
+}
+
+// ContainerReady waits until a container is ready
+//
+// If the image defines a health check the container is ready once it is healthy. Otherwise it is ready after running
+// for stablePeriod without restarting. An error is returned if the container stops, restarts or becomes unhealthy.
+func (bm *BasicManager) ContainerReady(ctx context.Context, containerName string, stablePeriod time.Duration) error {
+	prefixedName := bm.prefixedName(containerName)
+	fmt.Printf("Waiting for container '%s' to become ready\n", prefixedName)
+
+	restartCount := -1
+	var runningSince time.Time
+
+	for {
+		inspect, err := bm.cli.ContainerInspect(ctx, prefixedName)
+		if err != nil {
+			return err
+		}
+
+		if restartCount == -1 {
+			restartCount = inspect.RestartCount
+		}
+
+		if inspect.RestartCount != restartCount || inspect.State.Restarting {
+			return fmt.Errorf("container '%s' restarted while waiting for it to become ready", prefixedName)
+		}
+
+		if !inspect.State.Running {
+			return fmt.Errorf("container '%s' stopped with exit code %d while waiting for it to become ready", prefixedName, inspect.State.ExitCode)
+		}
+
+		if inspect.State.Health != nil {
+			switch inspect.State.Health.Status {
+			case types.Healthy:
+				fmt.Printf("Container '%s' is healthy\n", prefixedName)
+				return nil
+			case types.Unhealthy:
+				return fmt.Errorf("container '%s' is unhealthy", prefixedName)
+			}
+		} else if runningSince.IsZero() {
+			runningSince = time.Now()
+		} else if time.Since(runningSince) >= stablePeriod {
+			fmt.Printf("Container '%s' runs stable\n", prefixedName)
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("container '%s' didn't become ready in time: %s", prefixedName, ctx.Err())
+		case <-time.After(1 * time.Second):
+		}
+	}
+}
+
+// ImagesPresent returns for each image (referenced by tag or digest) whether it exists locally
+func (bm *BasicManager) ImagesPresent(ctx context.Context, images []string) (map[string]bool, error) {
+	present := make(map[string]bool, len(images))
+
+	for _, imageName := range images {
+		_, _, err := bm.cli.ImageInspectWithRaw(ctx, imageName)
+		if err != nil {
+			if client.IsErrImageNotFound(err) {
+				present[imageName] = false
+				continue
+			}
+
+			return nil, err
+		}
+
+		present[imageName] = true
 	}
 
-	return outputStr, nil
+	return present, nil
 }
 
-func (bm *BasicManager) doesContainerExist(ctx context.Context, containerName string) (bool, error) {
-	_, err := bm.cli.ContainerInspect(ctx, bm.prefixedName(containerName))
+// ImagesPulled pulls all images that don't exist locally yet in parallel
+func (bm *BasicManager) ImagesPulled(ctx context.Context, images []string) error {
+	present, err := bm.ImagesPresent(ctx, images)
 	if err != nil {
-		if client.IsErrContainerNotFound(err) {
-			return false, nil
+		return err
+	}
+
+	missing := []string{}
+	for _, imageName := range images {
+		if present[imageName] {
+			fmt.Printf("Image '%s' already exists, skipping pull\n", imageName)
+			continue
 		}
 
-		return false, err
+		if !funk.ContainsString(missing, imageName) {
+			missing = append(missing, imageName)
+		}
 	}
 
-	return true, nil
-}
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	errs := []string{}
+	pulled := 0
 
-// DoesNetworkExist returns true if the docker network exists
-func (bm *BasicManager) DoesNetworkExist(ctx context.Context, networkID string) (bool, error) {
-	_, err := bm.cli.NetworkInspect(ctx, networkID)
-	if err != nil {
-		if client.IsErrNetworkNotFound(err) {
-			return false, nil
-		}
+	for _, imageName := range missing {
+		wg.Add(1)
 
-		return false, err
-	}
+		go func(imageName string) {
+			defer wg.Done()
 
-	return true, nil
-}
+			fmt.Printf("Pulling image '%s'\n", imageName)
+			err := bm.pullImage(ctx, imageName)
 
-func (bm *BasicManager) doesVolumeExist(ctx context.Context, volumeID string) (bool, error) {
-	_, err := bm.cli.VolumeInspect(ctx, bm.prefixedName(volumeID))
-	if err != nil {
-		if client.IsErrVolumeNotFound(err) {
-			return false, nil
-		}
+			mutex.Lock()
+			defer mutex.Unlock()
 
-		return false, err
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", imageName, err))
+				return
+			}
+
+			pulled++
+			fmt.Printf("Pulled image '%s' (%d/%d)\n", imageName, pulled, len(missing))
+		}(imageName)
 	}
 
-	return true, nil
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return fmt.Errorf("cannot pull images: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
 }
 
-func (bm *BasicManager) IsContainerRunning(ctx context.Context, containerName string) (bool, error) {
-	inspect, err := bm.cli.ContainerInspect(ctx, bm.prefixedName(containerName))
-	if err != nil {
-		if client.IsErrContainerNotFound(err) {
-			return false, nil // a non existing container is not running!
+// ImagePulled pulls the image of a container according to its PullPolicy
+func (bm *BasicManager) ImagePulled(ctx context.Context, container Container) error {
+	if container.PullPolicy == PullPolicyIfNotPresent {
+		present, err := bm.ImagesPresent(ctx, []string{container.Image})
+		if err != nil {
+			return err
 		}
 
-		return false, err
+		if present[container.Image] {
+			fmt.Printf("Image '%s' already exists, skipping pull\n", container.Image)
+			return nil
+		}
 	}
 
-	return inspect.State.Running, nil
+	return bm.pullImage(ctx, container.Image)
 }
 
+// pullImage pulls an image, waiting and retrying if Docker Hub rate limits the pull (see image.RetryRateLimited)
 func (bm *BasicManager) pullImage(ctx context.Context, imageName string) error {
-	out, err := bm.cli.ImagePull(ctx, imageName, types.ImagePullOptions{})
+	progress.Emit(ctx, bm.emitter, progress.StagePull, imageName, "pulling image", 0)
+
+	err := image.RetryRateLimited(ctx, imageName, image.DefaultPullAttempts, func() error {
+		out, err := bm.cli.ImagePull(ctx, imageName, types.ImagePullOptions{})
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+
+		return emitPullProgress(ctx, bm.emitter, out, imageName)
+	})
 	if err != nil {
 		return err
 	}
-	defer out.Close()
-	if _, err := ioutil.ReadAll(out); err != nil {
-		return err
-	}
+
+	progress.Emit(ctx, bm.emitter, progress.StagePull, imageName, "pulled image", 100)
+
+	bm.warnAboutVulnerabilities(ctx, imageName)
 
 	return nil
 }
 
-func (bm *BasicManager) createContainer(ctx context.Context, container Container) error {
-	// Environment variables
-	var envs []string
-	var err error
+// pullMessage is a single message of the JSON stream returned when pulling an image
+type pullMessage struct {
+	ID             string `json:"id"`
+	Status         string `json:"status"`
+	ProgressDetail struct {
+		Current int64 `json:"current"`
+		Total   int64 `json:"total"`
+	} `json:"progressDetail"`
+	Error string `json:"error"`
+}
+
+// emitPullProgress reads the pull output until the pull is done and emits the download progress of all layers
+func emitPullProgress(ctx context.Context, emitter progress.Emitter, out io.Reader, imageName string) error {
+	current := map[string]int64{}
+	total := map[string]int64{}
+	lastPercent := 0
+
+	decoder := json.NewDecoder(out)
+	for {
+		var message pullMessage
+		if err := decoder.Decode(&message); err != nil {
+			if err == io.EOF {
+				return nil
+			}
 
-	if container.EnvFilename != "" {
-		envs, err = readLines(bm.AddBasePath(container.EnvFilename))
-		if err != nil {
 			return err
 		}
+
+		if message.Error != "" {
+			return fmt.Errorf("cannot pull image '%s': %s", imageName, message.Error)
+		}
+
+		if message.Status != "Downloading" || message.ProgressDetail.Total <= 0 {
+			continue
+		}
+
+		current[message.ID] = message.ProgressDetail.Current
+		total[message.ID] = message.ProgressDetail.Total
+
+		var sumCurrent, sumTotal int64
+		for id := range total {
+			sumCurrent += current[id]
+			sumTotal += total[id]
+		}
+
+		// Layers show up one after another so the percentage can go down, don't report a step back
+		percent := int(sumCurrent * 100 / sumTotal)
+		if percent > lastPercent && percent < 100 {
+			lastPercent = percent
+			progress.Emit(ctx, emitter, progress.StagePull, imageName, "downloading layers", percent)
+		}
+	}
+}
+
+// GenerateSBOM creates the software bill of materials of a local image
+//
+// The image is exported with `docker save` and analyzed by syft (image.SBOMGeneratorImage) in a transient container,
+// so syft doesn't need access to the docker socket. The export is stored in a temporary file on this host while
+// syft runs.
+func (bm *BasicManager) GenerateSBOM(ctx context.Context, imageRef string) (*image.SBOM, error) {
+	archive, err := ioutil.TempFile("", "bpm-sbom-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+
+	fmt.Printf("Exporting image '%s'\n", imageRef)
+
+	saved, err := bm.cli.ImageSave(ctx, []string{imageRef})
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(archive, saved)
+	saved.Close()
+	if err != nil {
+		return nil, fmt.Errorf("cannot export image '%s': %s", imageRef, err)
+	}
+
+	generator := Container{
+		Name:       "sbom",
+		Image:      image.SBOMGeneratorImage,
+		Cmd:        []string{"docker-archive:/image.tar", "--output", "cyclonedx-json", "--quiet"},
+		Networks:   []string{"bridge"},
+		PullPolicy: PullPolicyIfNotPresent,
+	}
+
+	if err := bm.ImagePulled(ctx, generator); err != nil {
+		return nil, fmt.Errorf("cannot pull the SBOM generator image '%s': %s", image.SBOMGeneratorImage, err)
+	}
+
+	// The archive has to be copied into the container before it starts, so RunTransientContainer can't be used
+	if err := bm.ContainerAbsent(ctx, generator); err != nil {
+		return nil, err
+	}
+
+	if err := bm.createContainer(ctx, generator, &TransientOptions{}); err != nil {
+		return nil, err
+	}
+	defer bm.ContainerAbsent(context.Background(), generator)
+
+	if err := bm.CopyToContainer(ctx, generator.Name, archive.Name(), "/image.tar"); err != nil {
+		return nil, err
+	}
+
+	prefixedName := bm.prefixedName(generator.Name)
+
+	if err := bm.cli.ContainerStart(ctx, prefixedName, types.ContainerStartOptions{}); err != nil {
+		return nil, err
+	}
+
+	status, err := bm.cli.ContainerWait(ctx, prefixedName)
+	if err != nil {
+		return nil, err
+	}
+
+	logs, err := bm.cli.ContainerLogs(ctx, prefixedName, types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true})
+	if err != nil {
+		return nil, err
+	}
+	defer logs.Close()
+
+	var stdout, stderr bytes.Buffer
+	if _, err := stdcopy.StdCopy(&stdout, &stderr, logs); err != nil {
+		return nil, err
+	}
+
+	if status != 0 {
+		return nil, fmt.Errorf("generating the SBOM of image '%s' failed with status code %d: %s", imageRef, status, strings.TrimSpace(stderr.String()))
+	}
+
+	return image.ParseSBOM(imageRef, stdout.Bytes())
+}
+
+// warnAboutVulnerabilities scans an image if a vulnerability scanner is configured and prints a warning if
+// critical vulnerabilities are found. Failing to scan is not an error because it shouldn't prevent a node from running.
+func (bm *BasicManager) warnAboutVulnerabilities(ctx context.Context, imageName string) {
+	scannerEndpoint := bm.currentNode.StrParameters["vulnerability-scanner"]
+	if scannerEndpoint == "" {
+		return
+	}
+
+	report, err := image.ScanVulnerabilities(ctx, imageName, scannerEndpoint)
+	if err != nil {
+		fmt.Printf("WARNING: Cannot scan image '%s' for vulnerabilities: %s\n", imageName, err)
+		return
+	}
+
+	if report.Critical > 0 {
+		fmt.Printf("WARNING: Image '%s' has %d critical vulnerabilities (%d high, %d medium, %d low)\n", imageName, report.Critical, report.High, report.Medium, report.Low)
+	}
+}
+
+// createContainer creates a container, transient is nil unless it's created by RunTransientContainerWithOptions
+func (bm *BasicManager) createContainer(ctx context.Context, container Container, transient *TransientOptions) error {
+	// Environment variables
+	envs, err := bm.containerEnv(container)
+	if err != nil {
+		return err
 	}
 
 	// Ports
@@ -451,75 +2027,110 @@ func (bm *BasicManager) createContainer(ctx context.Context, container Container
 	// Mountpoints
 	var mounts []mount.Mount
 	for _, mountParam := range container.Mounts {
-
-		// Render the from parameter as template. This allows us to parameterize where things are stored
-		// E.g.: "{{ .Node.StrParametrs.data-dir }}/my-special-data"
-		tmpl, err := template.New("").Parse(mountParam.From)
+		from, err := bm.MountSource(mountParam)
 		if err != nil {
 			return err
 		}
-		output := bytes.NewBufferString("")
-		if err := tmpl.Execute(output, sdktemplate.TemplateData{Node: bm.currentNode}); err != nil {
-			return err
+
+		// A remote daemon resolves the path on its own host, it can't be checked here
+		if (mountParam.Type == "bind" || mountParam.Type == MountTypeEncryptedSecret) && !bm.remote {
+			// Docker only says "invalid mount config" for missing paths, let's be more helpful
+			if _, err := os.Stat(from); err != nil {
+				return fmt.Errorf("cannot mount %q to %q in container '%s': %s", from, mountParam.To, bm.prefixedName(container.Name), err)
+			}
 		}
-		from := output.String()
 
-		// If it is a volume we add a prefix to be able to identify it again
-		// If it is a bind without '/' we assume it's relative to the node directory
-		if mountParam.Type == "bind" {
-			from = bm.AddBasePath(from)
-		} else { // volume
-			from = bm.prefixedName(from)
+		dockerMount := mount.Mount{
+			Type:     mount.Type(mountParam.Type),
+			Source:   from,
+			Target:   mountParam.To,
+			ReadOnly: mountParam.ReadOnly,
 		}
 
-		mounts = append(mounts, mount.Mount{
-			Type:   mount.Type(mountParam.Type),
-			Source: from,
-			Target: mountParam.To,
-		})
+		if mountParam.Type == MountTypeEncryptedSecret {
+			dockerMount.Type = mount.TypeBind
+			dockerMount.ReadOnly = true
+		}
+
+		if mountParam.Propagation != "" {
+			if mountParam.Type != "bind" {
+				return fmt.Errorf("mount propagation is only supported for bind mounts, cannot use it for %q", mountParam.To)
+			}
+
+			dockerMount.BindOptions = &mount.BindOptions{
+				Propagation: mount.Propagation(mountParam.Propagation),
+			}
+		}
+
+		mounts = append(mounts, dockerMount)
+	}
+
+	// Logging
+	logConfig, err := bm.containerLogConfig(container)
+	if err != nil {
+		return err
+	}
+
+	// Ulimits
+	ulimits := []*units.Ulimit{}
+	for _, ulimit := range container.Ulimits {
+		ulimits = append(ulimits, &units.Ulimit{Name: ulimit.Name, Soft: ulimit.Soft, Hard: ulimit.Hard})
+	}
+
+	if err := validateSysctls(container); err != nil {
+		return err
 	}
 
 	// Host config
 	hostCfg := &dockercontainer.HostConfig{
 		Mounts:       mounts,
 		PortBindings: portBindings,
+		CapAdd:       container.CapAdd,
+		CapDrop:      container.CapDrop,
+		Privileged:   container.Privileged,
+		DNS:          container.DNS,
+		DNSSearch:    container.DNSSearch,
+		ExtraHosts:   container.ExtraHosts,
+		Sysctls:      container.Sysctls,
 		RestartPolicy: dockercontainer.RestartPolicy{
 			Name: "unless-stopped",
 		},
-		LogConfig: dockercontainer.LogConfig{
-			Type: "json-file",
-			Config: map[string]string{
-				"max-size": "10m",
-				"max-file": "3",
-			},
+		LogConfig: logConfig,
+		Resources: dockercontainer.Resources{
+			Ulimits: ulimits,
 		},
 	}
 
+	// Docker must not start transient containers again after they finished or got killed because of MaxRuntime
+	if transient != nil {
+		hostCfg.RestartPolicy = dockercontainer.RestartPolicy{Name: "no"}
+		hostCfg.Memory = transient.Memory
+		hostCfg.NanoCPUs = int64(transient.CPUs * 1e9)
+	}
+
 	// Network config
+	// The docker API only supports one network when creating a container, additional networks are connected afterwards
+	networks := bm.ContainerNetworks(container)
 	endpointsConfig := make(map[string]*network.EndpointSettings)
-	endpointsConfig[bm.currentNode.StrParameters["docker-network"]] = &network.EndpointSettings{
-		NetworkID: bm.currentNode.StrParameters["docker-network"],
+	endpointsConfig[networks[0]] = &network.EndpointSettings{
+		NetworkID: networks[0],
 	}
 	networkConfig := &network.NetworkingConfig{
 		EndpointsConfig: endpointsConfig,
 	}
 
 	// Command
-	cmd := []string{}
-	if len(container.Cmd) > 0 {
-		cmd = container.Cmd
-	} else if len(container.CmdFile) > 0 {
-		cmdFileContent, err := ioutil.ReadFile(bm.AddBasePath(container.CmdFile))
-		if err != nil {
-			return err
-		}
+	cmd, err := bm.containerCmd(container)
+	if err != nil {
+		return err
+	}
 
-		for _, parameter := range strings.Split(string(cmdFileContent), "\n") {
-			if len(parameter) > 0 {
-				cmd = append(cmd, strings.TrimSpace(parameter))
-			}
-		}
+	// Labels
+	labels := map[string]string{}
+	for key, value := range container.Labels {
+		labels[key] = value
 	}
+	labels[NodeIDLabel] = bm.currentNode.ID
 
 	// Container config
 	containerCfg := &dockercontainer.Config{
@@ -528,6 +2139,7 @@ func (bm *BasicManager) createContainer(ctx context.Context, container Container
 		Cmd:          cmd,
 		User:         container.User,
 		ExposedPorts: exposedPorts,
+		Labels:       labels,
 	}
 
 	// Create a container with configs
@@ -536,9 +2148,216 @@ func (bm *BasicManager) createContainer(ctx context.Context, container Container
 		return err
 	}
 
+	for _, networkID := range networks[1:] {
+		if err := bm.cli.NetworkConnect(ctx, networkID, bm.prefixedName(container.Name), &network.EndpointSettings{NetworkID: networkID}); err != nil {
+			// Don't leave a half configured container behind, it wouldn't be recreated on the next start
+			if removeErr := bm.cli.ContainerRemove(ctx, bm.prefixedName(container.Name), types.ContainerRemoveOptions{Force: true}); removeErr != nil {
+				return fmt.Errorf("cannot connect container %q to network %q: %s; removing the container failed as well: %s", container.Name, networkID, err, removeErr)
+			}
+
+			return fmt.Errorf("cannot connect container %q to network %q: %s", container.Name, networkID, err)
+		}
+	}
+
+	return nil
+}
+
+// validateSysctls returns an error if a container sets "kernel.*" sysctls without running privileged
+func validateSysctls(container Container) error {
+	if container.Privileged {
+		return nil
+	}
+
+	for name := range container.Sysctls {
+		if strings.HasPrefix(name, "kernel.") {
+			return fmt.Errorf("container %q sets the sysctl %q, kernel sysctls require the container to run privileged", container.Name, name)
+		}
+	}
+
 	return nil
 }
 
+// containerLogConfig returns the docker log configuration of a container
+//
+// For json-file and local the log rotation defaults to LogRotation, then to the node parameters
+// LogMaxSizeParameter and LogMaxFilesParameter and finally to 10m and 3 files.
+func (bm *BasicManager) containerLogConfig(container Container) (dockercontainer.LogConfig, error) {
+	driver := container.LogConfig.Driver
+	if driver == "" {
+		driver = LogDriverJSONFile
+	}
+
+	// Filebeat reads the log files written by json-file, other drivers don't write them or use another format
+	if container.CollectLogs && driver != LogDriverJSONFile {
+		return dockercontainer.LogConfig{}, fmt.Errorf("container %q collects logs but uses the log driver %q, filebeat can only collect logs of the %q driver", container.Name, driver, LogDriverJSONFile)
+	}
+
+	options := map[string]string{}
+	for key, value := range container.LogConfig.Options {
+		options[key] = value
+	}
+
+	if driver == LogDriverJSONFile || driver == LogDriverLocal {
+		if _, ok := options["max-size"]; !ok {
+			maxSize := container.LogRotation.MaxSize
+			if maxSize == "" {
+				maxSize = bm.currentNode.StrParameters[LogMaxSizeParameter]
+			}
+			if maxSize == "" {
+				maxSize = "10m"
+			}
+			options["max-size"] = maxSize
+		}
+
+		if _, ok := options["max-file"]; !ok {
+			maxFileCount := container.LogRotation.MaxFileCount
+			if maxFileCount <= 0 {
+				if maxFiles := bm.currentNode.StrParameters[LogMaxFilesParameter]; maxFiles != "" {
+					count, err := strconv.Atoi(maxFiles)
+					if err != nil || count <= 0 {
+						return dockercontainer.LogConfig{}, fmt.Errorf("invalid value %q for %q, expected a positive number", maxFiles, LogMaxFilesParameter)
+					}
+					maxFileCount = count
+				}
+			}
+			if maxFileCount <= 0 {
+				maxFileCount = 3
+			}
+			options["max-file"] = strconv.Itoa(maxFileCount)
+		}
+	}
+
+	return dockercontainer.LogConfig{
+		Type:   driver,
+		Config: options,
+	}, nil
+}
+
+// ContainerNetworks returns the networks a container is attached to, the first one is used when creating it
+//
+// Empty and duplicate network names are left out.
+func (bm *BasicManager) ContainerNetworks(container Container) []string {
+	networks := []string{}
+	for _, networkID := range container.Networks {
+		if networkID != "" && !funk.ContainsString(networks, networkID) {
+			networks = append(networks, networkID)
+		}
+	}
+
+	if len(networks) == 0 {
+		return []string{bm.currentNode.DockerNetwork()}
+	}
+
+	return networks
+}
+
+// MountSource returns the docker source of a mount, i.e. the absolute path of a bind mount or the full volume name
+func (bm *BasicManager) MountSource(mountParam Mount) (string, error) {
+	// Render the from parameter as template. This allows us to parameterize where things are stored
+	// E.g.: "{{ .Node.StrParametrs.data-dir }}/my-special-data"
+	tmpl, err := template.New("").Parse(mountParam.From)
+	if err != nil {
+		return "", err
+	}
+	output := bytes.NewBufferString("")
+	if err := tmpl.Execute(output, sdktemplate.TemplateData{Node: bm.currentNode}); err != nil {
+		return "", err
+	}
+	from := output.String()
+
+	// If it is a volume we add a prefix to be able to identify it again
+	// If it is a bind without '/' we assume it's relative to the node directory
+	// Encrypted secrets are mounted from where they get decrypted to
+	switch mountParam.Type {
+	case "bind":
+		return bm.AddBasePath(from), nil
+	case MountTypeEncryptedSecret:
+		return secrets.PlaintextPath(bm.currentNode, bm.AddBasePath(from)), nil
+	}
+
+	return bm.prefixedName(from), nil
+}
+
+// encryptedSecretPath returns the absolute path of the encrypted file of a MountTypeEncryptedSecret mount
+func (bm *BasicManager) encryptedSecretPath(mountParam Mount) (string, error) {
+	secretMount := mountParam
+	secretMount.Type = "bind"
+
+	return bm.MountSource(secretMount)
+}
+
+// containerCmd returns the command of a container, either set directly or read from the cmd file (one parameter
+// per line)
+func (bm *BasicManager) containerCmd(container Container) ([]string, error) {
+	if len(container.Cmd) > 0 {
+		return container.Cmd, nil
+	}
+
+	if len(container.CmdFile) > 0 {
+		return readLines(bm.AddBasePath(container.CmdFile))
+	}
+
+	return []string{}, nil
+}
+
+// containerEnv combines the env file and the inline environment variables of a container
+func (bm *BasicManager) containerEnv(container Container) ([]string, error) {
+	names := []string{}
+	values := map[string]string{}
+
+	set := func(name, entry string) {
+		if _, ok := values[name]; !ok {
+			names = append(names, name)
+		}
+		values[name] = entry
+	}
+
+	if container.EnvFilename != "" {
+		envFilename := bm.AddBasePath(container.EnvFilename)
+
+		content, err := ioutil.ReadFile(envFilename)
+		if err != nil {
+			return nil, err
+		}
+
+		// Env file templates derive values from node parameters, plain env files may contain "{{" literally
+		rendered := string(content)
+		if strings.HasSuffix(envFilename, EnvTemplateSuffix) {
+			rendered, err = sdktemplate.RenderString(rendered, sdktemplate.TemplateData{Node: bm.currentNode})
+			if err != nil {
+				return nil, fmt.Errorf("cannot render env file %q: %s", envFilename, err)
+			}
+		}
+
+		entries, err := parseEnvFile(rendered, envFilename)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			set(entry.name, entry.entry)
+		}
+	}
+
+	inlineNames := make([]string, 0, len(container.Env))
+	for name := range container.Env {
+		inlineNames = append(inlineNames, name)
+	}
+	sort.Strings(inlineNames)
+
+	for _, name := range inlineNames {
+		set(name, name+"="+container.Env[name])
+	}
+
+	envs := make([]string, 0, len(names))
+	for _, name := range names {
+		envs = append(envs, values[name])
+	}
+
+	return envs, nil
+}
+
+// readLines returns the trimmed lines of a file without the lines skipped by isSkippedLine
 func readLines(path string) ([]string, error) {
 	file, err := os.Open(path)
 	if err != nil {
@@ -549,7 +2368,12 @@ func readLines(path string) ([]string, error) {
 	var lines []string
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
-		lines = append(lines, scanner.Text())
+		line := strings.TrimSpace(scanner.Text())
+		if isSkippedLine(line) {
+			continue
+		}
+
+		lines = append(lines, line)
 	}
 	return lines, scanner.Err()
 }
@@ -0,0 +1,286 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	volumetypes "github.com/docker/docker/api/types/volume"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.blockdaemon.com/bpm/sdk/pkg/node"
+)
+
+// testManager returns a BasicManager without docker client for a node in a temporary directory
+func testManager(t *testing.T) (*BasicManager, func()) {
+	dir, err := ioutil.TempDir("", "docker")
+	require.NoError(t, err)
+
+	currentNode := node.NewWithID(filepath.Join(dir, "node.json"), "bmvd5i3e2bp5bhubhmpg")
+
+	return &BasicManager{currentNode: currentNode}, func() { os.RemoveAll(dir) }
+}
+
+func writeNodeFile(t *testing.T, bm *BasicManager, name, content string) {
+	require.NoError(t, ioutil.WriteFile(bm.AddBasePath(name), []byte(content), 0644))
+}
+
+func TestContainerCmdSkipsBlankAndCommentLines(t *testing.T) {
+	testCases := map[string]string{
+		"comments":         "# the network\n--network\n  # indented comment\nmainnet",
+		"blank lines":      "\n--network\n\n   \nmainnet",
+		"trailing newline": "--network\nmainnet\n",
+		"windows newlines": "--network\r\nmainnet\r\n",
+	}
+
+	for name, content := range testCases {
+		t.Run(name, func(t *testing.T) {
+			bm, cleanup := testManager(t)
+			defer cleanup()
+
+			writeNodeFile(t, bm, "cmd.txt", content)
+
+			cmd, err := bm.containerCmd(Container{CmdFile: "cmd.txt"})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"--network", "mainnet"}, cmd)
+		})
+	}
+}
+
+func TestContainerEnvSkipsBlankAndCommentLines(t *testing.T) {
+	testCases := map[string]string{
+		"comments":         "# the network\nNETWORK=mainnet\n  # indented comment\nPORT=8545",
+		"blank lines":      "\nNETWORK=mainnet\n\n   \nPORT=8545",
+		"trailing newline": "NETWORK=mainnet\nPORT=8545\n",
+		"windows newlines": "NETWORK=mainnet\r\nPORT=8545\r\n",
+	}
+
+	for name, content := range testCases {
+		t.Run(name, func(t *testing.T) {
+			bm, cleanup := testManager(t)
+			defer cleanup()
+
+			writeNodeFile(t, bm, "node.env", content)
+
+			env, err := bm.containerEnv(Container{EnvFilename: "node.env"})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"NETWORK=mainnet", "PORT=8545"}, env)
+		})
+	}
+}
+
+// listDaemon is a fake docker daemon that lists containers and volumes, applying the filters like docker does
+type listDaemon struct {
+	server     *httptest.Server
+	containers []types.Container
+	volumes    []*types.Volume
+
+	mutex sync.Mutex
+	// Number of containers or volumes returned by the last list request
+	returned int
+}
+
+func newListDaemon(t testing.TB, containers []types.Container, volumes []*types.Volume) *listDaemon {
+	daemon := &listDaemon{containers: containers, volumes: volumes}
+
+	daemon.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filter, err := filters.FromParam(r.URL.Query().Get("filters"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		switch {
+		case r.URL.Path == "/_ping":
+			w.Write([]byte("OK"))
+		case strings.HasSuffix(r.URL.Path, "/containers/json"):
+			all := r.URL.Query().Get("all") == "1"
+
+			matching := []types.Container{}
+			for _, container := range daemon.containers {
+				if (all || container.State == "running") &&
+					filter.Match("name", container.Names[0]) &&
+					filter.MatchKVList("label", container.Labels) &&
+					filter.ExactMatch("status", container.State) {
+					matching = append(matching, container)
+				}
+			}
+
+			daemon.setReturned(len(matching))
+			json.NewEncoder(w).Encode(matching)
+		case strings.HasSuffix(r.URL.Path, "/volumes"):
+			matching := []*types.Volume{}
+			for _, volume := range daemon.volumes {
+				if filter.Match("name", volume.Name) && filter.MatchKVList("label", volume.Labels) {
+					matching = append(matching, volume)
+				}
+			}
+
+			daemon.setReturned(len(matching))
+			json.NewEncoder(w).Encode(volumetypes.VolumesListOKBody{Volumes: matching})
+		default:
+			t.Errorf("unexpected docker API request: %s %s", r.Method, r.URL.Path)
+			http.Error(w, "not implemented", http.StatusNotImplemented)
+		}
+	}))
+
+	return daemon
+}
+
+func (d *listDaemon) setReturned(returned int) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.returned = returned
+}
+
+func (d *listDaemon) lastReturned() int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return d.returned
+}
+
+func (d *listDaemon) manager(t testing.TB) *BasicManager {
+	currentNode := node.NewWithID("/tmp/node.json", "bmvd5i3e2bp5bhubhmpg")
+	currentNode.StrParameters = map[string]string{"docker-host": "tcp://" + d.server.Listener.Addr().String()}
+
+	bm, err := NewBasicManagerWithContext(context.Background(), currentNode)
+	require.NoError(t, err)
+
+	return bm
+}
+
+func testContainers() []types.Container {
+	return []types.Container{
+		{Names: []string{"/bpm-a-client"}, State: "running", Labels: map[string]string{NodeIDLabel: "a"}},
+		{Names: []string{"/bpm-a-filebeat"}, State: "exited", Labels: map[string]string{NodeIDLabel: "a"}},
+		{Names: []string{"/bpm-b-client"}, State: "running", Labels: map[string]string{NodeIDLabel: "b"}},
+		{Names: []string{"/other-bpm-a-client"}, State: "running"},
+	}
+}
+
+func TestListContainerNamesWithOptions(t *testing.T) {
+	testCases := map[string]struct {
+		options  ListOptions
+		expected []string
+	}{
+		"no filter": {
+			options:  ListOptions{},
+			expected: []string{"bpm-a-client", "bpm-a-filebeat", "bpm-b-client", "other-bpm-a-client"},
+		},
+		"name prefix": {
+			options:  ListOptions{NamePrefix: "bpm-a-"},
+			expected: []string{"bpm-a-client", "bpm-a-filebeat"},
+		},
+		"labels": {
+			options:  ListOptions{Labels: map[string]string{NodeIDLabel: "b"}},
+			expected: []string{"bpm-b-client"},
+		},
+		"only running": {
+			options:  ListOptions{NamePrefix: "bpm-a-", OnlyRunning: true},
+			expected: []string{"bpm-a-client"},
+		},
+	}
+
+	daemon := newListDaemon(t, testContainers(), nil)
+	defer daemon.server.Close()
+	bm := daemon.manager(t)
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			names, err := bm.ListContainerNamesWithOptions(context.Background(), testCase.options)
+			require.NoError(t, err)
+
+			assert.Equal(t, testCase.expected, names)
+			// The daemon filters, only the matching containers are transferred
+			assert.Equal(t, len(testCase.expected), daemon.lastReturned())
+		})
+	}
+}
+
+func TestListVolumeIDsWithOptions(t *testing.T) {
+	daemon := newListDaemon(t, nil, []*types.Volume{
+		{Name: "bpm-a-data", Labels: map[string]string{NodeIDLabel: "a"}},
+		{Name: "bpm-b-data", Labels: map[string]string{NodeIDLabel: "b"}},
+		{Name: "other-bpm-a-data"},
+	})
+	defer daemon.server.Close()
+	bm := daemon.manager(t)
+
+	ids, err := bm.ListVolumeIDsWithOptions(context.Background(), VolumeListOptions{NamePrefix: "bpm-a-"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bpm-a-data"}, ids)
+	assert.Equal(t, 1, daemon.lastReturned())
+
+	ids, err = bm.ListVolumeIDsWithOptions(context.Background(), VolumeListOptions{Labels: map[string]string{NodeIDLabel: "b"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bpm-b-data"}, ids)
+
+	ids, err = bm.ListVolumeIDs(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, ids, 3)
+}
+
+// benchmarkListContainerNames lists the containers of one node on a host running many nodes
+//
+// Besides the time it reports how many containers the daemon returns per list request. The fake daemon runs in the
+// benchmark process, so the time of the filtering in the daemon is included.
+func benchmarkListContainerNames(b *testing.B, list func(bm *BasicManager) ([]string, error)) {
+	containers := []types.Container{}
+	for i := 0; i < 500; i++ {
+		nodeID := fmt.Sprintf("node%d", i)
+		containers = append(containers, types.Container{
+			Names:  []string{"/bpm-" + nodeID + "-client"},
+			State:  "running",
+			Labels: map[string]string{NodeIDLabel: nodeID},
+		})
+	}
+
+	daemon := newListDaemon(b, containers, nil)
+	defer daemon.server.Close()
+	bm := daemon.manager(b)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := list(bm); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ReportMetric(float64(daemon.lastReturned()), "containers/op")
+}
+
+func BenchmarkListContainerNamesClientSideFilter(b *testing.B) {
+	benchmarkListContainerNames(b, func(bm *BasicManager) ([]string, error) {
+		names, err := bm.ListContainerNames(context.Background())
+		if err != nil {
+			return nil, err
+		}
+
+		filtered := []string{}
+		for _, name := range names {
+			if strings.HasPrefix(name, "bpm-node42-") {
+				filtered = append(filtered, name)
+			}
+		}
+
+		return filtered, nil
+	})
+}
+
+func BenchmarkListContainerNamesServerSideFilter(b *testing.B) {
+	benchmarkListContainerNames(b, func(bm *BasicManager) ([]string, error) {
+		return bm.ListContainerNamesWithOptions(context.Background(), ListOptions{NamePrefix: "bpm-node42-"})
+	})
+}
@@ -0,0 +1,160 @@
+package docker
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// envFileName matches valid variable names in env files
+var envFileName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+// envFileReference matches `${KEY}` references to previously defined variables
+var envFileReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_.]*)\}`)
+
+// envFileEntry is a variable defined in an env file
+type envFileEntry struct {
+	name string
+	// entry is passed to docker as is, either "NAME=value" or just "NAME" to pass the variable through from the
+	// docker daemon environment
+	entry string
+}
+
+// isSkippedLine returns true for trimmed lines of env and cmd files that are ignored: empty lines and comments
+// (lines starting with "#")
+func isSkippedLine(line string) bool {
+	return line == "" || strings.HasPrefix(line, "#")
+}
+
+// parseEnvFile parses the content of an env file
+//
+// The format follows the common .env conventions:
+//
+//	# Comments and empty lines are skipped
+//	export NAME=value       # "export" is optional, trailing comments are removed
+//	QUOTED="a=b # not a comment\n"
+//	LITERAL='no ${ESCAPES} or \n here'
+//	DERIVED=${NAME}/data    # references to previously defined variables get replaced
+//	PASSED_THROUGH          # taken from the docker daemon environment
+//
+// Errors include the line number, filename is only used in error messages.
+func parseEnvFile(content, filename string) ([]envFileEntry, error) {
+	entries := []envFileEntry{}
+	defined := map[string]string{}
+
+	for i, line := range strings.Split(content, "\n") {
+		lineNumber := i + 1
+
+		line = strings.TrimSpace(line)
+		if isSkippedLine(line) {
+			continue
+		}
+
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+
+		parts := strings.SplitN(line, "=", 2)
+		name := strings.TrimSpace(parts[0])
+
+		if !envFileName.MatchString(name) {
+			return nil, fmt.Errorf("%s line %d: invalid variable name %q, expected NAME=value", filename, lineNumber, name)
+		}
+
+		if len(parts) == 1 {
+			entries = append(entries, envFileEntry{name: name, entry: name})
+			continue
+		}
+
+		value, err := parseEnvFileValue(strings.TrimSpace(parts[1]), defined)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %s", filename, lineNumber, err)
+		}
+
+		defined[name] = value
+		entries = append(entries, envFileEntry{name: name, entry: name + "=" + value})
+	}
+
+	return entries, nil
+}
+
+// parseEnvFileValue parses a quoted or unquoted value and replaces references to previously defined variables
+func parseEnvFileValue(raw string, defined map[string]string) (string, error) {
+	interpolate := func(value string) string {
+		// Unknown references are left alone, they may be meant for the container
+		return envFileReference.ReplaceAllStringFunc(value, func(reference string) string {
+			if value, ok := defined[envFileReference.FindStringSubmatch(reference)[1]]; ok {
+				return value
+			}
+
+			return reference
+		})
+	}
+
+	if raw == "" {
+		return "", nil
+	}
+
+	switch raw[0] {
+	case '\'':
+		end := strings.Index(raw[1:], "'")
+		if end == -1 {
+			return "", fmt.Errorf("missing closing single quote")
+		}
+
+		if err := checkAfterQuote(raw[end+2:]); err != nil {
+			return "", err
+		}
+
+		return raw[1 : end+1], nil
+	case '"':
+		var value strings.Builder
+
+		for i := 1; i < len(raw); i++ {
+			switch raw[i] {
+			case '\\':
+				if i+1 == len(raw) {
+					return "", fmt.Errorf("missing closing double quote")
+				}
+
+				i++
+				switch raw[i] {
+				case 'n':
+					value.WriteByte('\n')
+				case 't':
+					value.WriteByte('\t')
+				default:
+					value.WriteByte(raw[i])
+				}
+			case '"':
+				if err := checkAfterQuote(raw[i+1:]); err != nil {
+					return "", err
+				}
+
+				return interpolate(value.String()), nil
+			default:
+				value.WriteByte(raw[i])
+			}
+		}
+
+		return "", fmt.Errorf("missing closing double quote")
+	}
+
+	// A "#" starts a comment in unquoted values only if it follows whitespace, e.g. "a#b" stays as it is
+	if index := strings.Index(raw, " #"); index != -1 {
+		raw = raw[:index]
+	}
+	if index := strings.Index(raw, "\t#"); index != -1 {
+		raw = raw[:index]
+	}
+
+	return interpolate(strings.TrimSpace(raw)), nil
+}
+
+// checkAfterQuote returns an error if anything but a comment follows a quoted value
+func checkAfterQuote(rest string) error {
+	rest = strings.TrimSpace(rest)
+	if rest != "" && !strings.HasPrefix(rest, "#") {
+		return fmt.Errorf("unexpected %q after the quoted value", rest)
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package image
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// RateLimitBackoff is the minimum time to wait after Docker Hub rejected a pull because of rate limiting
+//
+// Docker Hub counts pulls over a 6 hour window, retrying sooner than this is pointless.
+const RateLimitBackoff = 15 * time.Minute
+
+// ImagePuller is the part of the docker client used to pull images
+type ImagePuller interface {
+	ImagePull(ctx context.Context, ref string, options types.ImagePullOptions) (io.ReadCloser, error)
+}
+
+// pullMessage is a message in the progress stream of an image pull
+type pullMessage struct {
+	Error string `json:"error"`
+}
+
+// rateLimitWarning is printed to stderr as JSON so log collectors can pick it up
+type rateLimitWarning struct {
+	Level   string    `json:"level"`
+	Message string    `json:"msg"`
+	Image   string    `json:"image"`
+	Attempt int       `json:"attempt"`
+	RetryAt time.Time `json:"retry_at"`
+}
+
+// DefaultPullAttempts is the number of pulls PullWithBackoff is usually called with
+//
+// With RateLimitBackoff this waits 45 minutes in total before giving up.
+const DefaultPullAttempts = 3
+
+// PullWithBackoff pulls an image and retries if Docker Hub rate limits the pull
+//
+// See RetryRateLimited for how the retries work.
+func PullWithBackoff(ctx context.Context, puller ImagePuller, imageName string, maxAttempts int) error {
+	return RetryRateLimited(ctx, imageName, maxAttempts, func() error {
+		return pull(ctx, puller, imageName)
+	})
+}
+
+// RetryRateLimited calls pullFn and calls it again if Docker Hub rate limited the pull
+//
+// After a rate limited pull it waits RateLimitBackoff, doubling the wait after every further rate limited pull,
+// up to maxAttempts pulls in total. Other errors are returned immediately. Waiting is aborted if ctx is cancelled.
+func RetryRateLimited(ctx context.Context, imageName string, maxAttempts int, pullFn func() error) error {
+	backoff := RateLimitBackoff
+
+	for attempt := 1; ; attempt++ {
+		err := pullFn()
+		if err == nil || !isRateLimited(err) {
+			return err
+		}
+
+		if attempt >= maxAttempts {
+			return fmt.Errorf("pulling image '%s' is still rate limited after %d attempts: %s", imageName, attempt, err)
+		}
+
+		warning, _ := json.Marshal(rateLimitWarning{
+			Level:   "warning",
+			Message: "docker hub rate limit reached, retrying later",
+			Image:   imageName,
+			Attempt: attempt,
+			RetryAt: time.Now().Add(backoff),
+		})
+		fmt.Fprintln(os.Stderr, string(warning))
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("stopped waiting for the docker hub rate limit while pulling image '%s': %s", imageName, ctx.Err())
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+	}
+}
+
+func pull(ctx context.Context, puller ImagePuller, imageName string) error {
+	out, err := puller.ImagePull(ctx, imageName, types.ImagePullOptions{})
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	// Errors that happen during the pull (e.g. rate limiting of a layer download) are reported in the progress stream
+	decoder := json.NewDecoder(out)
+	for {
+		var message pullMessage
+		if err := decoder.Decode(&message); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+
+			return err
+		}
+
+		if message.Error != "" {
+			return errors.New(message.Error)
+		}
+	}
+}
+
+// rateLimitStatus is how the HTTP status of a rate limited request shows up in error messages
+var rateLimitStatus = strings.ToLower(fmt.Sprintf("%d %s", http.StatusTooManyRequests, http.StatusText(http.StatusTooManyRequests)))
+
+// isRateLimited returns true if the error is caused by Docker Hub rate limiting
+//
+// The docker client doesn't expose the status code, so this checks for the error code Docker Hub returns
+// ("toomanyrequests") or the HTTP status ("429 Too Many Requests") in the message.
+func isRateLimited(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "toomanyrequests") || strings.Contains(msg, rateLimitStatus)
+}
@@ -0,0 +1,80 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// SBOMGeneratorImage is the syft image used to generate SBOMs
+const SBOMGeneratorImage = "anchore/syft:v0.62.1"
+
+// Component is a package found in an image
+type Component struct {
+	Name    string
+	Version string
+	// License ids or expressions, comma separated if there are several
+	License string
+	// Package URL (https://github.com/package-url/purl-spec)
+	PURL string
+}
+
+// SBOM is the software bill of materials of an image
+type SBOM struct {
+	Image      string
+	Components []Component
+}
+
+// cycloneDXDocument is the part of a CycloneDX JSON document needed for the SBOM
+type cycloneDXDocument struct {
+	Components []struct {
+		Name     string `json:"name"`
+		Version  string `json:"version"`
+		PURL     string `json:"purl"`
+		Licenses []struct {
+			License struct {
+				ID   string `json:"id"`
+				Name string `json:"name"`
+			} `json:"license"`
+			Expression string `json:"expression"`
+		} `json:"licenses"`
+	} `json:"components"`
+}
+
+// ParseSBOM creates the software bill of materials of an image from the CycloneDX JSON document syft generated
+//
+// See BasicManager.GenerateSBOM, which runs syft (SBOMGeneratorImage).
+func ParseSBOM(imageRef string, cycloneDX []byte) (*SBOM, error) {
+	var document cycloneDXDocument
+	if err := json.Unmarshal(cycloneDX, &document); err != nil {
+		return nil, fmt.Errorf("cannot parse the SBOM of image '%s': %s", imageRef, err)
+	}
+
+	sbom := &SBOM{
+		Image:      imageRef,
+		Components: []Component{},
+	}
+
+	for _, component := range document.Components {
+		licenses := []string{}
+		for _, license := range component.Licenses {
+			switch {
+			case license.Expression != "":
+				licenses = append(licenses, license.Expression)
+			case license.License.ID != "":
+				licenses = append(licenses, license.License.ID)
+			case license.License.Name != "":
+				licenses = append(licenses, license.License.Name)
+			}
+		}
+
+		sbom.Components = append(sbom.Components, Component{
+			Name:    component.Name,
+			Version: component.Version,
+			License: strings.Join(licenses, ", "),
+			PURL:    component.PURL,
+		})
+	}
+
+	return sbom, nil
+}
@@ -0,0 +1,110 @@
+// Package image provides utilities to work with docker images.
+package image
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const (
+	SeverityCritical = "CRITICAL"
+	SeverityHigh     = "HIGH"
+	SeverityMedium   = "MEDIUM"
+	SeverityLow      = "LOW"
+)
+
+// VulnEntry is a single vulnerability found in an image
+type VulnEntry struct {
+	ID               string `json:"VulnerabilityID"`
+	PkgName          string `json:"PkgName"`
+	InstalledVersion string `json:"InstalledVersion"`
+	FixedVersion     string `json:"FixedVersion"`
+	Severity         string `json:"Severity"`
+	Title            string `json:"Title"`
+}
+
+// VulnReport summarizes the vulnerabilities found in an image
+type VulnReport struct {
+	Critical        int
+	High            int
+	Medium          int
+	Low             int
+	Vulnerabilities []VulnEntry
+}
+
+// scanRequest is the request body sent to the scanner
+type scanRequest struct {
+	Image string `json:"image"`
+}
+
+// scanResponse is the report returned by the scanner, it uses the same format as `trivy --format json`
+type scanResponse struct {
+	Results []struct {
+		Target          string      `json:"Target"`
+		Vulnerabilities []VulnEntry `json:"Vulnerabilities"`
+	} `json:"Results"`
+}
+
+// ScanVulnerabilities submits an image to a Trivy compatible scanner and returns the found vulnerabilities
+//
+// The image reference is sent as JSON (`{"image": "<imageRef>"}`) to `<scannerEndpoint>/scan`. The scanner
+// is expected to respond with a report in the same format as `trivy --format json`.
+func ScanVulnerabilities(ctx context.Context, imageRef string, scannerEndpoint string) (*VulnReport, error) {
+	body, err := json.Marshal(scanRequest{Image: imageRef})
+	if err != nil {
+		return nil, err
+	}
+
+	url := strings.TrimSuffix(scannerEndpoint, "/") + "/scan"
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("scanning image '%s' failed with status %d: %s", imageRef, resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	var scanResp scanResponse
+	if err := json.Unmarshal(respBody, &scanResp); err != nil {
+		return nil, fmt.Errorf("cannot parse scan report for image '%s': %s", imageRef, err)
+	}
+
+	report := &VulnReport{Vulnerabilities: []VulnEntry{}}
+	for _, result := range scanResp.Results {
+		for _, vuln := range result.Vulnerabilities {
+			switch strings.ToUpper(vuln.Severity) {
+			case SeverityCritical:
+				report.Critical++
+			case SeverityHigh:
+				report.High++
+			case SeverityMedium:
+				report.Medium++
+			case SeverityLow:
+				report.Low++
+			}
+
+			report.Vulnerabilities = append(report.Vulnerabilities, vuln)
+		}
+	}
+
+	return report, nil
+}
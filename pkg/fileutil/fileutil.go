@@ -3,8 +3,10 @@ package fileutil
 import (
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"time"
 
 	homedir "github.com/mitchellh/go-homedir"
 )
@@ -43,6 +45,45 @@ func CopyFile(src, dst string) error {
 	return out.Close()
 }
 
+// CopyDir copies the src directory recursively to dst, keeping file modes and symlinks. Existing files
+// in dst are overwritten.
+func CopyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, relPath)
+
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			if err := CopyFile(path, target); err != nil {
+				return err
+			}
+			return os.Chmod(target, info.Mode().Perm())
+		default:
+			// Sockets, devices, etc. cannot be copied
+			fmt.Printf("Skipping copying special file %q\n", path)
+			return nil
+		}
+	})
+}
+
 func MakeDirectory(baseDir string, subDirs ...string) (string, error) {
 	expandedBaseDir, err := homedir.Expand(baseDir)
 	if err != nil {
@@ -68,3 +109,93 @@ func FileExists(name string) (bool, error) {
 	}
 	return true, nil
 }
+
+// TruncateFile empties a file without removing it, processes that have the file open keep writing to it
+func TruncateFile(path string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+// TruncateOlderThan truncates all files in dir that were last modified more than maxAge ago and returns their paths
+//
+// Sub-directories and empty files are skipped.
+func TruncateOlderThan(dir string, maxAge time.Duration) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	truncated := []string{}
+	for _, entry := range entries {
+		if !entry.Mode().IsRegular() || entry.Size() == 0 || time.Since(entry.ModTime()) <= maxAge {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		if err := TruncateFile(path); err != nil {
+			return truncated, err
+		}
+
+		truncated = append(truncated, path)
+	}
+
+	return truncated, nil
+}
+
+// SyncDirectory flushes all files in a directory as well as the directory itself to disk
+//
+// Sub-directories are not synced recursively. Windows cannot sync directories, there only the files are flushed.
+func SyncDirectory(dir string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.Mode().IsRegular() {
+			continue
+		}
+
+		if err := syncFile(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	return syncDirectoryEntries(dir)
+}
+
+// FreeDiskSpace returns the number of bytes available to unprivileged users on the filesystem that contains path
+//
+// If path doesn't exist yet, the filesystem of the closest existing parent directory is used.
+func FreeDiskSpace(path string) (uint64, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		if _, err := os.Stat(path); err == nil {
+			break
+		} else if !os.IsNotExist(err) {
+			return 0, err
+		}
+
+		path = filepath.Dir(path)
+	}
+
+	return freeDiskSpace(path)
+}
+
+func syncFile(name string) error {
+	file, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return file.Sync()
+}
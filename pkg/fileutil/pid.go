@@ -0,0 +1,77 @@
+package fileutil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// CreatePidFile writes the id of the current process to path
+//
+// The file is replaced atomically so readers never see a partially written file. An error is returned if the file
+// belongs to another process that is still alive, a stale file left behind by a crashed process is replaced.
+func CreatePidFile(path string) error {
+	pid, err := ReadPidFile(path)
+	if err == nil && pid != os.Getpid() && IsPidAlive(pid) {
+		return fmt.Errorf("the process %d in pid file %q is still running", pid, path)
+	}
+
+	tmpFile, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmpFile.WriteString(strconv.Itoa(os.Getpid()) + "\n"); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+		return err
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+
+	if err := os.Chmod(tmpFile.Name(), 0644); err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), path)
+}
+
+// RemovePidFile removes a pid file, a missing file is not an error
+func RemovePidFile(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// ReadPidFile returns the process id stored in a pid file
+func ReadPidFile(path string) (int, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("the pid file %q doesn't contain a valid process id", path)
+	}
+
+	return pid, nil
+}
+
+// IsPidAlive returns true if a process with the id exists
+func IsPidAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+
+	return processExists(pid)
+}
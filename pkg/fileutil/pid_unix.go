@@ -0,0 +1,13 @@
+//go:build !windows
+// +build !windows
+
+package fileutil
+
+import "syscall"
+
+// processExists sends signal 0 which only checks whether the process exists. EPERM means it exists but belongs to
+// another user.
+func processExists(pid int) bool {
+	err := syscall.Kill(pid, syscall.Signal(0))
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package fileutil
+
+import "syscall"
+
+// syncDirectoryEntries makes sure the directory entries (e.g. newly created files) are persisted
+func syncDirectoryEntries(dir string) error {
+	fd, err := syscall.Open(dir, syscall.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	return syscall.Fsync(fd)
+}
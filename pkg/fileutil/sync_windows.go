@@ -0,0 +1,6 @@
+package fileutil
+
+// syncDirectoryEntries does nothing, Windows doesn't support flushing directories
+func syncDirectoryEntries(dir string) error {
+	return nil
+}
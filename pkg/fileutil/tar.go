@@ -0,0 +1,185 @@
+package fileutil
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// WriteTar writes a file or a directory including its content to w as an uncompressed tar stream
+//
+// The entries are named as if srcPath was called name, e.g. the directory "configs" written with the name "etc"
+// results in the entries "etc/", "etc/config.toml", etc.
+func WriteTar(w io.Writer, srcPath, name string) error {
+	tarWriter := tar.NewWriter(w)
+
+	err := filepath.Walk(srcPath, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(srcPath, filePath)
+		if err != nil {
+			return err
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(filePath); err != nil {
+				return err
+			}
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+
+		header.Name = filepath.ToSlash(filepath.Join(name, relPath))
+		if info.IsDir() {
+			header.Name += "/"
+		}
+
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		file, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		_, err = io.Copy(tarWriter, file)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return tarWriter.Close()
+}
+
+// ExtractTar extracts an uncompressed tar stream with a single top level entry (a file or a directory) to dstPath
+//
+// The top level entry is renamed to dstPath, e.g. the entries "etc/" and "etc/config.toml" extracted to "/tmp/configs"
+// result in "/tmp/configs/config.toml". Entries that would end up outside of dstPath are rejected, including symlinks
+// pointing outside of it and entries that would be written through a symlink.
+func ExtractTar(r io.Reader, dstPath string) error {
+	dstPath = filepath.Clean(dstPath)
+	tarReader := tar.NewReader(r)
+
+	for {
+		header, err := tarReader.Next()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		// Replace the top level entry with dstPath
+		target := dstPath
+		parts := strings.SplitN(filepath.Clean(filepath.FromSlash(header.Name)), string(filepath.Separator), 2)
+		if len(parts) == 2 {
+			target = filepath.Join(dstPath, parts[1])
+		}
+
+		if !isWithin(dstPath, target) {
+			return fmt.Errorf("the entry %q would be extracted outside of %q", header.Name, dstPath)
+		}
+
+		// An existing symlink is replaced by a symlink entry but never written through
+		through := target
+		if header.Typeflag == tar.TypeSymlink {
+			through = filepath.Dir(target)
+		}
+		if err := checkNoSymlinks(dstPath, through); err != nil {
+			return fmt.Errorf("the entry %q would be extracted through a symlink: %s", header.Name, err)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, header.FileInfo().Mode().Perm()); err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+
+			outFile, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, header.FileInfo().Mode().Perm())
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(outFile, tarReader); err != nil {
+				outFile.Close()
+				return err
+			}
+			if err := outFile.Close(); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if filepath.IsAbs(header.Linkname) || !isWithin(dstPath, filepath.Join(filepath.Dir(target), header.Linkname)) {
+				return fmt.Errorf("the symlink %q points outside of %q", header.Name, dstPath)
+			}
+
+			if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported type %d of %q", header.Typeflag, header.Name)
+		}
+	}
+
+	return nil
+}
+
+// isWithin returns true if path is root or inside of it, both have to be clean
+func isWithin(root, path string) bool {
+	relPath, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+
+	return relPath != ".." && !strings.HasPrefix(relPath, ".."+string(filepath.Separator))
+}
+
+// checkNoSymlinks returns an error if any existing path component below root up to and including path is a symlink
+func checkNoSymlinks(root, path string) error {
+	relPath, err := filepath.Rel(root, path)
+	if err != nil || relPath == "." {
+		return err
+	}
+
+	current := root
+	for _, part := range strings.Split(relPath, string(filepath.Separator)) {
+		current = filepath.Join(current, part)
+
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%q is a symlink", current)
+		}
+	}
+
+	return nil
+}
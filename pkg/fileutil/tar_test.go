@@ -0,0 +1,109 @@
+package fileutil
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type tarEntry struct {
+	name     string
+	typeflag byte
+	linkname string
+	content  string
+}
+
+func buildTar(t *testing.T, entries []tarEntry) *bytes.Buffer {
+	buffer := &bytes.Buffer{}
+	tarWriter := tar.NewWriter(buffer)
+
+	for _, entry := range entries {
+		header := &tar.Header{
+			Name:     entry.name,
+			Typeflag: entry.typeflag,
+			Linkname: entry.linkname,
+			Mode:     0644,
+			Size:     int64(len(entry.content)),
+		}
+		if entry.typeflag == tar.TypeDir {
+			header.Mode = 0755
+		}
+
+		require.NoError(t, tarWriter.WriteHeader(header))
+		_, err := tarWriter.Write([]byte(entry.content))
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, tarWriter.Close())
+	return buffer
+}
+
+func TestExtractTar(t *testing.T) {
+	dir, err := ioutil.TempDir("", "extract-tar")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	srcPath := filepath.Join(dir, "src")
+	require.NoError(t, os.MkdirAll(filepath.Join(srcPath, "sub"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(srcPath, "sub", "config.toml"), []byte("a = 1"), 0644))
+	require.NoError(t, os.Symlink("sub/config.toml", filepath.Join(srcPath, "link")))
+
+	buffer := &bytes.Buffer{}
+	require.NoError(t, WriteTar(buffer, srcPath, "etc"))
+
+	dstPath := filepath.Join(dir, "dst")
+	require.NoError(t, ExtractTar(buffer, dstPath))
+
+	content, err := ioutil.ReadFile(filepath.Join(dstPath, "sub", "config.toml"))
+	require.NoError(t, err)
+	assert.Equal(t, "a = 1", string(content))
+
+	link, err := os.Readlink(filepath.Join(dstPath, "link"))
+	require.NoError(t, err)
+	assert.Equal(t, "sub/config.toml", link)
+}
+
+func TestExtractTarRejectsEscapes(t *testing.T) {
+	testCases := map[string][]tarEntry{
+		"absolute symlink": {
+			{name: "etc/", typeflag: tar.TypeDir},
+			{name: "etc/link", typeflag: tar.TypeSymlink, linkname: "/etc"},
+		},
+		"relative symlink outside": {
+			{name: "etc/", typeflag: tar.TypeDir},
+			{name: "etc/link", typeflag: tar.TypeSymlink, linkname: "../.."},
+		},
+		"write through symlink": {
+			{name: "etc/", typeflag: tar.TypeDir},
+			{name: "etc/sub/", typeflag: tar.TypeDir},
+			{name: "etc/link", typeflag: tar.TypeSymlink, linkname: "sub"},
+			{name: "etc/link/file", typeflag: tar.TypeReg, content: "x"},
+		},
+		"overwrite symlink with file": {
+			{name: "etc/", typeflag: tar.TypeDir},
+			{name: "etc/file", typeflag: tar.TypeReg, content: "x"},
+			{name: "etc/link", typeflag: tar.TypeSymlink, linkname: "file"},
+			{name: "etc/link", typeflag: tar.TypeReg, content: "y"},
+		},
+	}
+
+	for name, entries := range testCases {
+		t.Run(name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "extract-tar")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			dstPath := filepath.Join(dir, "a", "dst")
+			assert.Error(t, ExtractTar(buildTar(t, entries), dstPath))
+
+			_, err = os.Stat(filepath.Join(dir, "outside"))
+			assert.True(t, os.IsNotExist(err))
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package node
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// envReference matches environment variable references in string parameters, only the `${VAR}` form is supported
+// so that values containing a plain `$` (e.g. passwords) are left alone
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// wholeEnvReference matches a value that consists of nothing but a `$VAR` reference
+var wholeEnvReference = regexp.MustCompile(`^\$([A-Za-z_][A-Za-z0-9_]*)$`)
+
+// expandedParameter remembers the original value of a string parameter that contained environment variables
+type expandedParameter struct {
+	raw      string
+	expanded string
+}
+
+// expandEnv replaces all `${VAR}` references in value with the value of the environment variable
+//
+// An error is returned if a referenced variable is not defined. Variables that are defined but empty are allowed.
+//
+// A value that is exactly `$VAR` is replaced as well, but left unchanged if the variable is not defined. This keeps
+// values that happen to start with `$` (e.g. passwords) working.
+func expandEnv(value string) (string, error) {
+	if matches := wholeEnvReference.FindStringSubmatch(value); matches != nil {
+		if envValue, ok := os.LookupEnv(matches[1]); ok {
+			return envValue, nil
+		}
+
+		return value, nil
+	}
+
+	var missing []string
+
+	expanded := envReference.ReplaceAllStringFunc(value, func(reference string) string {
+		name := envReference.FindStringSubmatch(reference)[1]
+
+		envValue, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+
+		return envValue
+	})
+
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable %q is not defined", missing[0])
+	}
+
+	return expanded, nil
+}
+
+// expandStrParameters expands environment variables in all string parameters
+//
+// The original values are kept so Save doesn't write the expanded values (e.g. secrets) back to the node file.
+func (c *Node) expandStrParameters() error {
+	for name, value := range c.StrParameters {
+		expanded, err := expandEnv(value)
+		if err != nil {
+			return fmt.Errorf("cannot expand parameter %q: %s", name, err)
+		}
+
+		if expanded == value {
+			continue
+		}
+
+		if c.expandedParameters == nil {
+			c.expandedParameters = map[string]expandedParameter{}
+		}
+
+		c.expandedParameters[name] = expandedParameter{raw: value, expanded: expanded}
+		c.StrParameters[name] = expanded
+	}
+
+	return nil
+}
+
+// rawStrParameters returns the string parameters with environment variable references restored
+//
+// Parameters that changed since they were expanded are returned as they are.
+func (c Node) rawStrParameters() map[string]string {
+	if c.StrParameters == nil {
+		return nil
+	}
+
+	raw := make(map[string]string, len(c.StrParameters))
+	for name, value := range c.StrParameters {
+		if parameter, ok := c.expandedParameters[name]; ok && parameter.expanded == value {
+			value = parameter.raw
+		}
+
+		raw[name] = value
+	}
+
+	return raw
+}
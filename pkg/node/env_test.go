@@ -0,0 +1,116 @@
+package node
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testEnvDefined   = "BPM_SDK_TEST_DEFINED"
+	testEnvUndefined = "BPM_SDK_TEST_UNDEFINED"
+)
+
+func setTestEnv(t *testing.T) func() {
+	require.NoError(t, os.Setenv(testEnvDefined, "secret"))
+	require.NoError(t, os.Unsetenv(testEnvUndefined))
+
+	return func() { os.Unsetenv(testEnvDefined) }
+}
+
+// writeTestNodeFile writes a node file with the string parameters to a temporary directory
+func writeTestNodeFile(t *testing.T, strParameters map[string]string) (string, func()) {
+	dir, err := ioutil.TempDir("", "node")
+	require.NoError(t, err)
+
+	data, err := json.Marshal(map[string]interface{}{
+		"id":             "bmvd5i3e2bp5bhubhmpg",
+		"plugin":         "test",
+		"str_parameters": strParameters,
+	})
+	require.NoError(t, err)
+
+	nodeFile := filepath.Join(dir, "node.json")
+	require.NoError(t, ioutil.WriteFile(nodeFile, data, 0644))
+
+	return nodeFile, func() { os.RemoveAll(dir) }
+}
+
+func TestExpandEnv(t *testing.T) {
+	defer setTestEnv(t)()
+
+	testCases := map[string]struct {
+		value    string
+		expected string
+	}{
+		"no reference":            {"plain", "plain"},
+		"plain dollar":            {"pa$$word", "pa$$word"},
+		"braces":                  {"${" + testEnvDefined + "}", "secret"},
+		"braces within value":     {"/data/${" + testEnvDefined + "}/chain", "/data/secret/chain"},
+		"whole value":             {"$" + testEnvDefined, "secret"},
+		"whole value undefined":   {"$" + testEnvUndefined, "$" + testEnvUndefined},
+		"dollar within value":     {"prefix-$" + testEnvDefined, "prefix-$" + testEnvDefined},
+		"dollar undefined suffix": {"$" + testEnvUndefined + "-suffix", "$" + testEnvUndefined + "-suffix"},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			expanded, err := expandEnv(testCase.value)
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expected, expanded)
+		})
+	}
+}
+
+func TestExpandEnvUndefinedBraces(t *testing.T) {
+	defer setTestEnv(t)()
+
+	_, err := expandEnv("/data/${" + testEnvUndefined + "}")
+	assert.EqualError(t, err, `environment variable "`+testEnvUndefined+`" is not defined`)
+}
+
+func TestLoadExpandsEnv(t *testing.T) {
+	defer setTestEnv(t)()
+
+	nodeFile, cleanup := writeTestNodeFile(t, map[string]string{
+		"rpc-password": "$" + testEnvDefined,
+		"api-key":      "$" + testEnvUndefined,
+		"data-dir":     "${" + testEnvDefined + "}/chain",
+	})
+	defer cleanup()
+
+	currentNode, err := Load(nodeFile)
+	require.NoError(t, err)
+
+	assert.Equal(t, "secret", currentNode.StrParameters["rpc-password"])
+	assert.Equal(t, "$"+testEnvUndefined, currentNode.StrParameters["api-key"], "an undefined $VAR is kept literally")
+	assert.Equal(t, "secret/chain", currentNode.StrParameters["data-dir"])
+
+	// Save writes the references back, not the values from the environment
+	require.NoError(t, currentNode.Save())
+
+	saved, err := ioutil.ReadFile(nodeFile)
+	require.NoError(t, err)
+	assert.NotContains(t, string(saved), "secret")
+
+	reloaded, err := Load(nodeFile)
+	require.NoError(t, err)
+	assert.Equal(t, currentNode.StrParameters, reloaded.StrParameters)
+}
+
+func TestLoadUndefinedEnv(t *testing.T) {
+	defer setTestEnv(t)()
+
+	nodeFile, cleanup := writeTestNodeFile(t, map[string]string{
+		"data-dir": "${" + testEnvUndefined + "}/chain",
+	})
+	defer cleanup()
+
+	_, err := Load(nodeFile)
+	assert.EqualError(t, err, `cannot expand parameter "data-dir": environment variable "`+testEnvUndefined+`" is not defined`)
+}
@@ -6,20 +6,45 @@
 package node
 
 import (
+	"crypto/rand"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 
+	"github.com/coreos/go-semver/semver"
 	homedir "github.com/mitchellh/go-homedir"
 	"go.blockdaemon.com/bpm/sdk/pkg/fileutil"
 )
 
+// nodeFileName is the name of the file in the node directory that contains the node data
+const nodeFileName = "node.json"
+
+const (
+	// ConfigsDirectoryName is the subdirectory under the node directory where configs are saved
+	ConfigsDirectoryName = "configs"
+	// LogsDirectoryName is the subdirectory under the node directory where logs are saved
+	LogsDirectoryName = "logs"
+	// DefaultDataDirectoryName is the subdirectory under the node directory where data is saved if `data-dir` is empty
+	DefaultDataDirectoryName = "data"
+	// DefaultDockerNetworkName is the docker network nodes are attached to if `docker-network` is empty
+	DefaultDockerNetworkName = "bpm"
+)
+
 // Node represents a blockchain node, it's configuration and related information
 type Node struct {
 	nodeFile string
 
+	// Resolved when loading the node so errors can be returned instead of panicking in NodeDirectory
+	nodeDirectory string
+
+	// Original values of string parameters that referenced environment variables
+	expandedParameters map[string]expandedParameter
+
 	// The global ID of this node
 	ID string `json:"id"`
 
@@ -46,20 +71,99 @@ func (c Node) NamePrefix() string {
 }
 
 // NodeDirectory returns the base directory under which all configuration and meta-data for this node is stored
+//
+// For nodes returned by Load the directory is resolved once while loading, which returns an error if that fails.
 func (c Node) NodeDirectory() string {
-	dir := filepath.Dir(c.nodeFile)
+	if c.nodeDirectory != "" {
+		return c.nodeDirectory
+	}
 
-	absDir, err := filepath.Abs(dir)
+	dir, err := resolveNodeDirectory(c.nodeFile)
 	if err != nil {
-		panic(err) // Should never happen
+		panic(err) // Only nodes that were not loaded get here, use Load to handle this error
 	}
 
-	expandedBaseDir, err := homedir.Expand(absDir)
+	return dir
+}
+
+// resolveNodeDirectory returns the absolute directory containing nodeFile
+func resolveNodeDirectory(nodeFile string) (string, error) {
+	dir := filepath.Dir(nodeFile)
+
+	absDir, err := filepath.Abs(dir)
 	if err != nil {
-		panic(err) // Should never happen
+		return "", err
 	}
 
-	return expandedBaseDir
+	return homedir.Expand(absDir)
+}
+
+// ConfigsDirectory returns the directory under which the configuration files of the node are stored
+func (c Node) ConfigsDirectory() string {
+	return filepath.Join(c.NodeDirectory(), ConfigsDirectoryName)
+}
+
+// LogsDirectory returns the directory under which the log files of the node are stored
+func (c Node) LogsDirectory() string {
+	return filepath.Join(c.NodeDirectory(), LogsDirectoryName)
+}
+
+// DataDirectory returns the directory under which the blockchain data of the node is stored
+//
+// It is set by the `data-dir` parameter, relative paths are relative to the node directory. If the parameter
+// is empty or missing, DefaultDataDirectoryName is used.
+func (c Node) DataDirectory() string {
+	dataDir := c.StrParameters["data-dir"]
+	if dataDir == "" {
+		dataDir = DefaultDataDirectoryName
+	}
+
+	return c.ExpandPath(dataDir)
+}
+
+// windowsAbsPath matches absolute Windows paths like `C:\data` or `\\server\share`
+var windowsAbsPath = regexp.MustCompile(`^([A-Za-z]:[\\/]|\\\\)`)
+
+// ExpandPath resolves a path from a parameter or a container definition to an absolute path
+//
+// A leading `~` is replaced with the home directory and environment variables (`$HOME` or `${HOME}`) are expanded,
+// undefined variables are left as they are. Relative paths are relative to the node directory. Absolute Windows
+// paths are returned unchanged.
+func (c Node) ExpandPath(path string) string {
+	if path == "" || windowsAbsPath.MatchString(path) {
+		return path
+	}
+
+	path = os.Expand(path, func(name string) string {
+		if value, ok := os.LookupEnv(name); ok {
+			return value
+		}
+
+		return "${" + name + "}"
+	})
+
+	if expanded, err := homedir.Expand(path); err == nil {
+		path = expanded
+	}
+
+	if filepath.IsAbs(path) {
+		return path
+	}
+
+	return filepath.Join(c.NodeDirectory(), path)
+}
+
+// DockerNetwork returns the name of the docker network the containers of the node are attached to
+//
+// It is set by the `docker-network` parameter. If the parameter is empty or missing, e.g. in node files written by
+// older versions of bpm, DefaultDockerNetworkName is used.
+func (c Node) DockerNetwork() string {
+	network := c.StrParameters["docker-network"]
+	if network == "" {
+		return DefaultDockerNetworkName
+	}
+
+	return network
 }
 
 // NodeFile returns the filepath in which the base configuration as well as meta-data from the PBG is stored
@@ -67,6 +171,64 @@ func (c Node) NodeFile() string {
 	return c.nodeFile
 }
 
+// VersionAtLeast returns true if the installed package version is greater or equal to minVersion
+func (c Node) VersionAtLeast(minVersion string) (bool, error) {
+	installedVersion, otherVersion, err := c.parseVersions(minVersion)
+	if err != nil {
+		return false, err
+	}
+
+	return !installedVersion.LessThan(*otherVersion), nil
+}
+
+// VersionLessThan returns true if the installed package version is less than maxVersion
+func (c Node) VersionLessThan(maxVersion string) (bool, error) {
+	installedVersion, otherVersion, err := c.parseVersions(maxVersion)
+	if err != nil {
+		return false, err
+	}
+
+	return installedVersion.LessThan(*otherVersion), nil
+}
+
+func (c Node) parseVersions(version string) (*semver.Version, *semver.Version, error) {
+	installedVersion, err := semver.NewVersion(c.Version)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid node version %q: %s", c.Version, err)
+	}
+
+	otherVersion, err := semver.NewVersion(version)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid version %q: %s", version, err)
+	}
+
+	return installedVersion, otherVersion, nil
+}
+
+// SetStringDefault sets a string parameter if it is missing or empty
+func (c *Node) SetStringDefault(key, value string) {
+	if c.StrParameters == nil {
+		c.StrParameters = map[string]string{}
+	}
+
+	if c.StrParameters[key] == "" {
+		c.StrParameters[key] = value
+	}
+}
+
+// SetBoolDefault sets a bool parameter if it is missing
+//
+// Unlike string parameters an existing false value is kept, it can't be told apart from an explicitly disabled option.
+func (c *Node) SetBoolDefault(key string, value bool) {
+	if c.BoolParameters == nil {
+		c.BoolParameters = map[string]bool{}
+	}
+
+	if _, ok := c.BoolParameters[key]; !ok {
+		c.BoolParameters[key] = value
+	}
+}
+
 // Save the node data
 func (c Node) Save() error {
 	// Create node directories if they don't exist yet
@@ -75,6 +237,9 @@ func (c Node) Save() error {
 		return err
 	}
 
+	// Don't write values from the environment (e.g. secrets) into the node file
+	c.StrParameters = c.rawStrParameters()
+
 	data, err := json.MarshalIndent(c, "", "  ")
 	if err != nil {
 		return err
@@ -92,17 +257,89 @@ func (c Node) Remove() error {
 	return os.RemoveAll(c.NodeDirectory())
 }
 
+// uuidPattern matches UUIDs in the canonical textual form, e.g. the IDs created by GenerateID
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// xidPattern matches xids (20 characters base32hex, e.g. "9m4e2mr0ui3e8a215n4g") used as node IDs by bpm
+var xidPattern = regexp.MustCompile(`^[0-9a-v]{20}$`)
+
+// Validate checks that the node is complete enough to be used
+//
+// The node ID has to be a UUID or an xid. Missing parameter maps are not an error, Load initializes them.
+func (c Node) Validate() error {
+	if c.nodeFile == "" {
+		return fmt.Errorf("the node file is not set")
+	}
+
+	if !uuidPattern.MatchString(c.ID) && !xidPattern.MatchString(c.ID) {
+		return fmt.Errorf("the node ID %q is neither a UUID nor an xid", c.ID)
+	}
+
+	if c.PluginName == "" {
+		return fmt.Errorf("the plugin name is empty")
+	}
+
+	return nil
+}
+
 // New initializes a new instance of Node
 func New(nodeFile string) Node {
 	return Node{nodeFile: nodeFile}
 }
 
+// NewWithID initializes a new instance of Node with the given ID, e.g. one created by GenerateID
+func NewWithID(nodeFile, id string) Node {
+	node := New(nodeFile)
+	node.ID = id
+
+	return node
+}
+
+// GenerateID returns a new random node ID, a version 4 UUID in lowercase (e.g. "3f2b8a0c-5d1e-4c3b-9a7f-2e6d1b0c8a9e")
+func GenerateID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic(err) // Should never happen, crypto/rand only fails if the OS has no source of randomness
+	}
+
+	id[6] = (id[6] & 0x0f) | 0x40 // version 4
+	id[8] = (id[8] & 0x3f) | 0x80 // variant RFC 4122
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:16])
+}
+
 // Load all the data for a particular node and creates all required directories
+//
+// String parameters can reference environment variables using `${VAR}`, e.g. `"data-dir": "${HOME}/chains/eth"`.
+// Referencing an undefined variable is an error. A value that is exactly `$VAR` (e.g. `"rpc-password": "$RPC_PASSWORD"`)
+// is replaced too but kept as it is if the variable is undefined. Save writes the references back instead of the
+// expanded values. Bool parameters are JSON booleans and cannot reference environment variables.
 func Load(nodeFile string) (Node, error) {
+	file, err := os.Open(nodeFile)
+	if err != nil {
+		return New(nodeFile), err
+	}
+	defer file.Close()
+
+	return LoadFromReader(file, nodeFile)
+}
+
+// LoadFromReader works like Load but reads the node data from r instead of the node file
+//
+// The node file is not read, it is still needed because the node directory is derived from it. Missing parameter
+// maps (e.g. in node files written by older versions of bpm) are initialized empty, then the node is checked with
+// Validate.
+func LoadFromReader(r io.Reader, nodeFile string) (Node, error) {
 	node := New(nodeFile)
 
+	nodeDirectory, err := resolveNodeDirectory(nodeFile)
+	if err != nil {
+		return node, fmt.Errorf("cannot determine the directory of node file %q: %s", nodeFile, err)
+	}
+	node.nodeDirectory = nodeDirectory
+
 	// Load node data
-	nodeData, err := ioutil.ReadFile(nodeFile)
+	nodeData, err := ioutil.ReadAll(r)
 	if err != nil {
 		return node, err
 	}
@@ -111,8 +348,79 @@ func Load(nodeFile string) (Node, error) {
 		return node, err
 	}
 
+	if node.StrParameters == nil {
+		node.StrParameters = map[string]string{}
+	}
+	if node.BoolParameters == nil {
+		node.BoolParameters = map[string]bool{}
+	}
+
+	if err = node.Validate(); err != nil {
+		return node, fmt.Errorf("invalid node file %q: %s", nodeFile, err)
+	}
+
+	if err = node.expandStrParameters(); err != nil {
+		return node, err
+	}
+
 	// Initialize temporary data store
 	node.Data = make(map[string]interface{})
 
 	return node, nil
 }
+
+// FindNodeFile searches searchDir recursively for a node file (`node.json`) that belongs to the node with the ID nodeID
+func FindNodeFile(searchDir, nodeID string) (string, error) {
+	searchDir, err := homedir.Expand(searchDir)
+	if err != nil {
+		return "", err
+	}
+
+	found := []string{}
+
+	err = filepath.Walk(searchDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == searchDir {
+				return err
+			}
+
+			// Skip what can't be read (e.g. directories of other users) instead of aborting the whole search
+			return nil
+		}
+
+		if info.IsDir() || info.Name() != nodeFileName {
+			return nil
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+
+		var candidate struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(data, &candidate); err != nil {
+			// Not every file with that name has to be a node file, ignore it
+			return nil
+		}
+
+		if candidate.ID == nodeID {
+			found = append(found, path)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("cannot find a node with the ID %q in %q", nodeID, searchDir)
+	case 1:
+		return found[0], nil
+	default:
+		return "", fmt.Errorf("found multiple nodes with the ID %q: %s", nodeID, strings.Join(found, ", "))
+	}
+}
@@ -0,0 +1,79 @@
+package node
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateID(t *testing.T) {
+	seen := map[string]bool{}
+
+	for i := 0; i < 100; i++ {
+		id := GenerateID()
+
+		parsed, err := uuid.Parse(id)
+		require.NoError(t, err)
+		assert.Equal(t, uuid.Version(4), parsed.Version())
+		assert.Equal(t, uuid.RFC4122, parsed.Variant())
+
+		// Lowercase with hyphens, i.e. the canonical form
+		assert.Equal(t, parsed.String(), id)
+
+		assert.False(t, seen[id], "duplicate ID %q", id)
+		seen[id] = true
+	}
+}
+
+func TestNewWithID(t *testing.T) {
+	id := GenerateID()
+	currentNode := NewWithID("/tmp/node.json", id)
+	currentNode.PluginName = "test"
+
+	assert.Equal(t, id, currentNode.ID)
+	assert.NoError(t, currentNode.Validate())
+}
+
+func TestLoadFromReaderValidates(t *testing.T) {
+	testCases := map[string]struct {
+		content     string
+		expectedErr string
+	}{
+		"uuid": {
+			content: `{"id": "3f2b8a0c-5d1e-4c3b-9a7f-2e6d1b0c8a9e", "plugin": "test"}`,
+		},
+		"xid without parameter maps": {
+			content: `{"id": "bmvd5i3e2bp5bhubhmpg", "plugin": "test"}`,
+		},
+		"missing ID": {
+			content:     `{"plugin": "test"}`,
+			expectedErr: `invalid node file "/tmp/node.json": the node ID "" is neither a UUID nor an xid`,
+		},
+		"invalid ID": {
+			content:     `{"id": "my-node", "plugin": "test"}`,
+			expectedErr: `invalid node file "/tmp/node.json": the node ID "my-node" is neither a UUID nor an xid`,
+		},
+		"missing plugin": {
+			content:     `{"id": "bmvd5i3e2bp5bhubhmpg"}`,
+			expectedErr: `invalid node file "/tmp/node.json": the plugin name is empty`,
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			currentNode, err := LoadFromReader(strings.NewReader(testCase.content), "/tmp/node.json")
+
+			if testCase.expectedErr != "" {
+				assert.EqualError(t, err, testCase.expectedErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.NotNil(t, currentNode.StrParameters)
+			assert.NotNil(t, currentNode.BoolParameters)
+		})
+	}
+}
@@ -0,0 +1,26 @@
+// Package parameters provides helpers to validate and work with node parameters.
+package parameters
+
+import (
+	"fmt"
+	"time"
+
+	"go.blockdaemon.com/bpm/sdk/pkg/node"
+)
+
+// ParseDuration returns the value of a duration parameter (e.g. "30s" or "5m")
+//
+// Duration parameters are stored as string parameters.
+func ParseDuration(n node.Node, paramName string) (time.Duration, error) {
+	value, ok := n.StrParameters[paramName]
+	if !ok {
+		return 0, fmt.Errorf("the parameter %q is missing", paramName)
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("the parameter %q is not a valid duration (e.g. \"30s\" or \"5m\"): %q", paramName, value)
+	}
+
+	return duration, nil
+}
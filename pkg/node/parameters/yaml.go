@@ -0,0 +1,36 @@
+package parameters
+
+import (
+	"go.blockdaemon.com/bpm/sdk/pkg/node"
+	"gopkg.in/yaml.v2"
+)
+
+// ToYAML returns the node parameters as YAML document, e.g. for clients that read their configuration from a YAML file
+//
+// keyMapping maps parameter names to the keys used in the YAML document. Parameters not included in keyMapping
+// are left out. If keyMapping is empty all parameters are included using their parameter names as keys.
+// String and bool parameters keep their type.
+func ToYAML(n node.Node, keyMapping map[string]string) ([]byte, error) {
+	values := map[string]interface{}{}
+
+	add := func(name string, value interface{}) {
+		if len(keyMapping) == 0 {
+			values[name] = value
+			return
+		}
+
+		if key, ok := keyMapping[name]; ok {
+			values[key] = value
+		}
+	}
+
+	for name, value := range n.StrParameters {
+		add(name, value)
+	}
+
+	for name, value := range n.BoolParameters {
+		add(name, value)
+	}
+
+	return yaml.Marshal(values)
+}
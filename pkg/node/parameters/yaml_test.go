@@ -0,0 +1,60 @@
+package parameters
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.blockdaemon.com/bpm/sdk/pkg/node"
+	"gopkg.in/yaml.v2"
+)
+
+func testNode() node.Node {
+	currentNode := node.NewWithID("/tmp/node.json", "bmvd5i3e2bp5bhubhmpg")
+	currentNode.StrParameters = map[string]string{
+		"network":  "mainnet",
+		"data-dir": "/data: with colon",
+		"port":     "8545",
+	}
+	currentNode.BoolParameters = map[string]bool{
+		"archive": true,
+	}
+
+	return currentNode
+}
+
+func TestToYAMLRoundTrip(t *testing.T) {
+	data, err := ToYAML(testNode(), nil)
+	require.NoError(t, err)
+
+	var values map[string]interface{}
+	require.NoError(t, yaml.Unmarshal(data, &values))
+
+	// Strings stay strings, even if they look like numbers, and bools stay bools
+	assert.Equal(t, map[string]interface{}{
+		"network":  "mainnet",
+		"data-dir": "/data: with colon",
+		"port":     "8545",
+		"archive":  true,
+	}, values)
+
+	roundTripped, err := yaml.Marshal(values)
+	require.NoError(t, err)
+	assert.Equal(t, string(data), string(roundTripped))
+}
+
+func TestToYAMLKeyMapping(t *testing.T) {
+	data, err := ToYAML(testNode(), map[string]string{
+		"data-dir": "datadir",
+		"archive":  "archive-mode",
+	})
+	require.NoError(t, err)
+
+	var values map[string]interface{}
+	require.NoError(t, yaml.Unmarshal(data, &values))
+
+	assert.Equal(t, map[string]interface{}{
+		"datadir":      "/data: with colon",
+		"archive-mode": true,
+	}, values)
+}
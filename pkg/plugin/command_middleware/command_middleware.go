@@ -0,0 +1,175 @@
+// Package command_middleware wraps cobra commands with cross-cutting concerns like locking, signal handling and metrics.
+//
+// Example:
+//
+//	cmd = command_middleware.Chain(
+//		command_middleware.WithSignalHandling(cancel),
+//		command_middleware.WithLock(""),
+//		command_middleware.WithMetrics(recorder),
+//	)(cmd)
+package command_middleware
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/cobra"
+)
+
+// RunE is the signature of cobra.Command.RunE
+type RunE func(cmd *cobra.Command, args []string) error
+
+// Middleware wraps the RunE function of a command
+type Middleware func(next RunE) RunE
+
+// Chain returns a function that wraps the RunE function of a command with all middlewares
+//
+// The first middleware is the outermost one, i.e. it runs first and sees the result of all others.
+func Chain(middlewares ...Middleware) func(cmd *cobra.Command) *cobra.Command {
+	return func(cmd *cobra.Command) *cobra.Command {
+		if cmd.RunE == nil {
+			return cmd
+		}
+
+		runE := RunE(cmd.RunE)
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			runE = middlewares[i](runE)
+		}
+		cmd.RunE = runE
+
+		return cmd
+	}
+}
+
+// WithLock makes sure only one locked command runs for a node at a time
+//
+// The lock is an exclusive lock on the file `<node-file>.lock` (flock, or LockFileEx on Windows). If nodeFile is empty, the first command argument is
+// used. The command fails right away instead of waiting if another command holds the lock.
+func WithLock(nodeFile string) Middleware {
+	return func(next RunE) RunE {
+		return func(cmd *cobra.Command, args []string) error {
+			lockedFile := nodeFile
+			if lockedFile == "" {
+				if len(args) == 0 {
+					return fmt.Errorf("cannot lock the node, no node file passed")
+				}
+				lockedFile = args[0]
+			}
+
+			lockFile, err := os.OpenFile(lockedFile+".lock", os.O_CREATE|os.O_RDWR, 0644)
+			if err != nil {
+				return fmt.Errorf("cannot create lock file: %s", err)
+			}
+			defer lockFile.Close()
+
+			locked, err := tryLock(lockFile)
+			if err != nil {
+				return fmt.Errorf("cannot lock %q: %s", lockFile.Name(), err)
+			}
+			if !locked {
+				return fmt.Errorf("another command is running for the node %q", lockedFile)
+			}
+			// Closing the file releases the lock
+
+			return next(cmd, args)
+		}
+	}
+}
+
+// WithSignalHandling calls cancel when the process receives SIGINT or SIGTERM while the command runs
+//
+// cancel should cancel the context used by the command so it can stop cleanly. A second signal exits immediately.
+func WithSignalHandling(cancel context.CancelFunc) Middleware {
+	return func(next RunE) RunE {
+		return func(cmd *cobra.Command, args []string) error {
+			stop := HandleSignals(cancel)
+			defer stop()
+
+			return next(cmd, args)
+		}
+	}
+}
+
+// HandleSignals calls cancel when the process receives SIGINT or SIGTERM until stop is called
+//
+// A second signal exits immediately.
+func HandleSignals(cancel context.CancelFunc) (stop func()) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case sig := <-signals:
+			fmt.Fprintf(os.Stderr, "Received %s, stopping. Send it again to exit immediately\n", sig)
+			cancel()
+		case <-done:
+			return
+		}
+
+		select {
+		case <-signals:
+			os.Exit(1)
+		case <-done:
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// Recorder records the result of a command
+type Recorder interface {
+	RecordCommand(command string, duration time.Duration, err error)
+}
+
+// WithMetrics records the duration and result of every run of the command
+func WithMetrics(recorder Recorder) Middleware {
+	return func(next RunE) RunE {
+		return func(cmd *cobra.Command, args []string) error {
+			start := time.Now()
+			err := next(cmd, args)
+			recorder.RecordCommand(cmd.Name(), time.Since(start), err)
+
+			return err
+		}
+	}
+}
+
+// PrometheusRecorder records command durations in the histogram `bpm_command_duration_seconds`
+type PrometheusRecorder struct {
+	durations *prometheus.HistogramVec
+}
+
+// NewPrometheusRecorder creates a PrometheusRecorder and registers its histogram
+func NewPrometheusRecorder(registerer prometheus.Registerer) (*PrometheusRecorder, error) {
+	durations := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "bpm_command_duration_seconds",
+		Help:    "Duration of plugin commands",
+		Buckets: []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800},
+	}, []string{"command", "result"})
+
+	if err := registerer.Register(durations); err != nil {
+		return nil, err
+	}
+
+	return &PrometheusRecorder{durations: durations}, nil
+}
+
+// RecordCommand implements Recorder
+func (r *PrometheusRecorder) RecordCommand(command string, duration time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+
+	r.durations.WithLabelValues(command, result).Observe(duration.Seconds())
+}
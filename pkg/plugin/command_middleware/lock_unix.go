@@ -0,0 +1,22 @@
+//go:build !windows
+// +build !windows
+
+package command_middleware
+
+import (
+	"os"
+	"syscall"
+)
+
+// tryLock takes an exclusive flock on file without waiting, it returns false if another process holds the lock
+func tryLock(file *os.File) (bool, error) {
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		if err == syscall.EWOULDBLOCK {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,33 @@
+package command_middleware
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+
+	errorLockViolation syscall.Errno = 33
+)
+
+var procLockFileEx = syscall.NewLazyDLL("kernel32.dll").NewProc("LockFileEx")
+
+// tryLock locks the first byte of file using LockFileEx without waiting, it returns false if another process holds
+// the lock
+func tryLock(file *os.File) (bool, error) {
+	var overlapped syscall.Overlapped
+
+	ok, _, err := procLockFileEx.Call(file.Fd(), lockfileExclusiveLock|lockfileFailImmediately, 0, 1, 0, uintptr(unsafe.Pointer(&overlapped)))
+	if ok == 0 {
+		if err == errorLockViolation {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return true, nil
+}
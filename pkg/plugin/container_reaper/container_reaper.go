@@ -0,0 +1,36 @@
+// Package container_reaper removes containers left behind in a broken state, e.g. by a plugin that crashed while starting.
+package container_reaper
+
+import (
+	"context"
+	"fmt"
+
+	"go.blockdaemon.com/bpm/sdk/pkg/docker"
+)
+
+// ReapOrphans removes containers that were created but never started or that docker failed to remove
+//
+// A crash between creating and starting a container leaves it in the "created" state. Because ContainerRuns only
+// creates missing containers, such a container would be started as it is even if it was created with an incomplete
+// configuration. Removing it lets the next start create it again. Stopped containers are left alone, they were
+// stopped on purpose and get started again.
+func ReapOrphans(ctx context.Context, mgr *docker.BasicManager, containers []docker.Container) error {
+	for _, container := range containers {
+		status, err := mgr.ContainerStatus(ctx, container.Name)
+		if err != nil {
+			return err
+		}
+
+		if status != "created" && status != "dead" {
+			continue
+		}
+
+		fmt.Printf("Container '%s' was left behind in state '%s', removing it so it gets recreated\n", container.Name, status)
+
+		if err := mgr.ContainerAbsent(ctx, container); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
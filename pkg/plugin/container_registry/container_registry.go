@@ -0,0 +1,149 @@
+// Package container_registry keeps container definitions in one place so they can be reused for many nodes.
+package container_registry
+
+import (
+	"fmt"
+	"sync"
+
+	"go.blockdaemon.com/bpm/sdk/pkg/docker"
+	"go.blockdaemon.com/bpm/sdk/pkg/node"
+	"go.blockdaemon.com/bpm/sdk/pkg/template"
+)
+
+// Registry holds container definitions whose image, cmd and mounts can contain templates
+//
+// The templates are rendered for a specific node when instantiating a container, e.g.:
+//
+//	registry := container_registry.NewRegistry()
+//	registry.Register("client", docker.Container{
+//		Name:  "client",
+//		Image: "docker.io/example/client:{{ index .Node.StrParameters \"client-version\" }}",
+//		Cmd:   []string{"--network", "{{ index .Node.StrParameters \"network\" }}"},
+//	})
+//
+//	container, err := registry.Instantiate("client", currentNode)
+//
+// It is safe for concurrent use.
+type Registry struct {
+	mutex      sync.RWMutex
+	containers map[string]docker.Container
+}
+
+// NewRegistry creates an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{
+		containers: map[string]docker.Container{},
+	}
+}
+
+// Register adds a container definition, an existing definition with the same name gets replaced
+func (r *Registry) Register(name string, tmpl docker.Container) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.containers[name] = tmpl
+}
+
+// Instantiate returns a copy of the registered container definition with the templates rendered for a node
+//
+// The copy shares no maps or slices with the registered definition, so instances can be changed independently.
+//
+// The templates get the same data and functions as configuration file templates (see template.RenderString).
+func (r *Registry) Instantiate(name string, n node.Node) (docker.Container, error) {
+	r.mutex.RLock()
+	tmpl, ok := r.containers[name]
+	r.mutex.RUnlock()
+
+	if !ok {
+		return docker.Container{}, fmt.Errorf("no container definition registered as %q", name)
+	}
+
+	templateData := template.TemplateData{
+		Node: n,
+	}
+
+	render := func(field, value string) (string, error) {
+		rendered, err := template.RenderString(value, templateData)
+		if err != nil {
+			return "", fmt.Errorf("cannot render %s of container %q: %s", field, name, err)
+		}
+
+		return rendered, nil
+	}
+
+	container := tmpl
+	var err error
+
+	if container.Image, err = render("image", tmpl.Image); err != nil {
+		return docker.Container{}, err
+	}
+
+	// Copy the slices so the registered definition isn't modified
+	container.Cmd = make([]string, len(tmpl.Cmd))
+	for i, arg := range tmpl.Cmd {
+		if container.Cmd[i], err = render("cmd", arg); err != nil {
+			return docker.Container{}, err
+		}
+	}
+
+	container.Mounts = make([]docker.Mount, len(tmpl.Mounts))
+	for i, mount := range tmpl.Mounts {
+		if mount.From, err = render("mount source", mount.From); err != nil {
+			return docker.Container{}, err
+		}
+
+		if mount.To, err = render("mount target", mount.To); err != nil {
+			return docker.Container{}, err
+		}
+
+		container.Mounts[i] = mount
+	}
+
+	container.Env = copyStringMap(tmpl.Env)
+	container.Labels = copyStringMap(tmpl.Labels)
+	container.Sysctls = copyStringMap(tmpl.Sysctls)
+	container.Networks = copyStrings(tmpl.Networks)
+	container.CapAdd = copyStrings(tmpl.CapAdd)
+	container.CapDrop = copyStrings(tmpl.CapDrop)
+	container.DNS = copyStrings(tmpl.DNS)
+	container.DNSSearch = copyStrings(tmpl.DNSSearch)
+	container.ExtraHosts = copyStrings(tmpl.ExtraHosts)
+
+	if tmpl.Ports != nil {
+		container.Ports = append([]docker.Port{}, tmpl.Ports...)
+	}
+
+	if tmpl.Ulimits != nil {
+		container.Ulimits = append([]docker.ContainerUlimit{}, tmpl.Ulimits...)
+	}
+
+	if tmpl.Metrics != nil {
+		metrics := *tmpl.Metrics
+		container.Metrics = &metrics
+	}
+
+	return container, nil
+}
+
+// copyStrings returns a copy of values, nil stays nil
+func copyStrings(values []string) []string {
+	if values == nil {
+		return nil
+	}
+
+	return append([]string{}, values...)
+}
+
+// copyStringMap returns a copy of values, nil stays nil
+func copyStringMap(values map[string]string) map[string]string {
+	if values == nil {
+		return nil
+	}
+
+	copied := make(map[string]string, len(values))
+	for key, value := range values {
+		copied[key] = value
+	}
+
+	return copied
+}
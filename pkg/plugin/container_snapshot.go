@@ -0,0 +1,106 @@
+package plugin
+
+import (
+	"context"
+	"time"
+
+	"go.blockdaemon.com/bpm/sdk/pkg/docker"
+)
+
+// RollbackTimeout limits how long rolling back a failed upgrade may take, see NewRollbackContext
+const RollbackTimeout = 10 * time.Minute
+
+// NewRollbackContext returns the context to restore a ContainerSnapshot with after an upgrade failed
+//
+// It is not derived from the context of the upgrade because that is cancelled if the upgrade failed due to --timeout
+// or a signal, and the node would be left half upgraded. The rollback is limited by RollbackTimeout instead.
+func NewRollbackContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), RollbackTimeout)
+}
+
+// ContainerSnapshot records the image IDs of a node's containers and which of them were running
+//
+// It is used to roll back failed upgrades, see SafeDockerUpgrader and upgrade_rollback.WithRollback. The images are
+// recorded by ID rather than by tag, so a tag that got updated in the meantime doesn't change what gets restored.
+type ContainerSnapshot struct {
+	containers []docker.Container
+	// Image IDs by container name, empty if the container didn't exist
+	images  map[string]string
+	running map[string]bool
+}
+
+// TakeContainerSnapshot records the current image ID and running state of the containers
+func TakeContainerSnapshot(ctx context.Context, client *docker.BasicManager, containers []docker.Container) (ContainerSnapshot, error) {
+	snapshot := ContainerSnapshot{
+		containers: containers,
+		images:     map[string]string{},
+		running:    map[string]bool{},
+	}
+
+	for _, container := range containers {
+		image, err := client.ResolveImageDigest(ctx, container.Name)
+		if err != nil {
+			return ContainerSnapshot{}, err
+		}
+
+		running, err := client.IsContainerRunning(ctx, container.Name)
+		if err != nil {
+			return ContainerSnapshot{}, err
+		}
+
+		snapshot.images[container.Name] = image
+		snapshot.running[container.Name] = running
+	}
+
+	return snapshot, nil
+}
+
+// Restore recreates the containers that changed since the snapshot was taken in reverse order
+//
+// Containers that run the same image and are in the same state as when the snapshot was taken are left alone.
+// Containers that didn't exist are removed, containers that existed but didn't run are removed as well because they
+// get created again on the next start. If stablePeriod is not 0, Restore waits for each restarted container to
+// become ready (see BasicManager.ContainerReady).
+func (s ContainerSnapshot) Restore(ctx context.Context, client *docker.BasicManager, stablePeriod time.Duration) error {
+	for i := len(s.containers) - 1; i >= 0; i-- {
+		container := s.containers[i]
+
+		image, err := client.ResolveImageDigest(ctx, container.Name)
+		if err != nil {
+			return err
+		}
+
+		running, err := client.IsContainerRunning(ctx, container.Name)
+		if err != nil {
+			return err
+		}
+
+		if image == s.images[container.Name] && running == s.running[container.Name] {
+			continue
+		}
+
+		if err := client.ContainerAbsent(ctx, container); err != nil {
+			return err
+		}
+
+		if !s.running[container.Name] {
+			continue
+		}
+
+		container.Image = s.images[container.Name]
+		// Don't pull, the image ID only exists locally
+		container.PullPolicy = docker.PullPolicyIfNotPresent
+
+		if err := client.ContainerRuns(ctx, container); err != nil {
+			return err
+		}
+
+		if stablePeriod != 0 {
+			if err := client.ContainerReady(ctx, container.Name, stablePeriod); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,277 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.blockdaemon.com/bpm/sdk/pkg/docker"
+	"go.blockdaemon.com/bpm/sdk/pkg/fileutil"
+	"go.blockdaemon.com/bpm/sdk/pkg/node"
+)
+
+const (
+	backupNodeFile      = "node.json"
+	backupVolumesDir    = "volumes"
+	backupBindMountsDir = "binds"
+)
+
+// DockerBackupProvider backs up and restores docker based nodes
+//
+// A backup contains the node file, the configs directory, an archive of every volume and a copy of every
+// bind mount and encrypted secret (still encrypted) of the node containers:
+//
+//	<dir>/node.json
+//	<dir>/configs/...
+//	<dir>/volumes/<volume>.tar.gz
+//	<dir>/binds/<container>/<mount target>/...
+//
+// The node needs to be stopped to get a consistent backup.
+type DockerBackupProvider struct {
+	containers []docker.Container
+}
+
+// NewDockerBackupProvider creates an instance of DockerBackupProvider
+func NewDockerBackupProvider(containers []docker.Container) DockerBackupProvider {
+	return DockerBackupProvider{containers: containers}
+}
+
+// Backup copies the node file, configs and all container data to dstDir
+func (d DockerBackupProvider) Backup(ctx context.Context, currentNode node.Node, dstDir string) error {
+	client, err := docker.NewBasicManagerWithContext(ctx, currentNode)
+	if err != nil {
+		return err
+	}
+
+	if err := d.ensureStopped(ctx, client); err != nil {
+		return err
+	}
+
+	if _, err := fileutil.MakeDirectory(dstDir, backupVolumesDir); err != nil {
+		return err
+	}
+
+	fmt.Printf("Backing up node file to %q\n", dstDir)
+	if err := fileutil.CopyFile(currentNode.NodeFile(), filepath.Join(dstDir, backupNodeFile)); err != nil {
+		return err
+	}
+
+	configsPath := currentNode.ConfigsDirectory()
+	exists, err := fileutil.FileExists(configsPath)
+	if err != nil {
+		return err
+	}
+	if exists {
+		fmt.Printf("Backing up %q\n", configsPath)
+		if err := fileutil.CopyDir(configsPath, filepath.Join(dstDir, ConfigsDirectory)); err != nil {
+			return err
+		}
+	}
+
+	for _, container := range d.containers {
+		for _, mount := range container.Mounts {
+			if mount.Type == "volume" {
+				if err := client.VolumeBackup(ctx, mount.From, filepath.Join(dstDir, backupVolumesDir, mount.From+".tar.gz")); err != nil {
+					return err
+				}
+				continue
+			}
+
+			source, err := backupSource(client, mount)
+			if err != nil {
+				return err
+			}
+
+			target := filepath.Join(dstDir, backupBindMountsDir, container.Name, bindMountBackupName(mount))
+			fmt.Printf("Backing up %q\n", source)
+			if err := copyPath(source, target); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Restore restores the node file, configs and all container data from a backup created with Backup
+//
+// Existing data is replaced. The backup has to belong to the same node. Nothing is replaced if a volume archive is
+// missing and every path is only replaced once its copy from the backup is complete.
+func (d DockerBackupProvider) Restore(ctx context.Context, currentNode node.Node, srcDir string) error {
+	client, err := docker.NewBasicManagerWithContext(ctx, currentNode)
+	if err != nil {
+		return err
+	}
+
+	backupNode, err := node.Load(filepath.Join(srcDir, backupNodeFile))
+	if err != nil {
+		return fmt.Errorf("cannot read the node file of the backup: %s", err)
+	}
+	if backupNode.ID != currentNode.ID {
+		return fmt.Errorf("the backup belongs to node %q, cannot restore it to node %q", backupNode.ID, currentNode.ID)
+	}
+
+	if err := d.ensureStopped(ctx, client); err != nil {
+		return err
+	}
+
+	// Make sure the backup is complete before anything gets replaced
+	for _, container := range d.containers {
+		for _, mount := range container.Mounts {
+			if mount.Type != "volume" {
+				continue
+			}
+
+			archive := filepath.Join(srcDir, backupVolumesDir, mount.From+".tar.gz")
+			exists, err := fileutil.FileExists(archive)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return fmt.Errorf("the backup is incomplete, cannot find %q", archive)
+			}
+		}
+	}
+
+	fmt.Printf("Restoring node file %q\n", currentNode.NodeFile())
+	if err := fileutil.CopyFile(filepath.Join(srcDir, backupNodeFile), currentNode.NodeFile()); err != nil {
+		return err
+	}
+
+	backupConfigsPath := filepath.Join(srcDir, ConfigsDirectory)
+	exists, err := fileutil.FileExists(backupConfigsPath)
+	if err != nil {
+		return err
+	}
+	if exists {
+		configsPath := currentNode.ConfigsDirectory()
+		fmt.Printf("Restoring %q\n", configsPath)
+		if err := replacePath(backupConfigsPath, configsPath); err != nil {
+			return err
+		}
+	}
+
+	for _, container := range d.containers {
+		for _, mount := range container.Mounts {
+			if mount.Type == "volume" {
+				if err := client.VolumeRestore(ctx, mount.From, filepath.Join(srcDir, backupVolumesDir, mount.From+".tar.gz")); err != nil {
+					return err
+				}
+				continue
+			}
+
+			target, err := backupSource(client, mount)
+			if err != nil {
+				return err
+			}
+
+			// Only replace what is actually in the backup, e.g. sockets are never backed up
+			backupPath := filepath.Join(srcDir, backupBindMountsDir, container.Name, bindMountBackupName(mount))
+			exists, err := fileutil.FileExists(backupPath)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				fmt.Printf("Backup doesn't contain %q, skipping restore\n", target)
+				continue
+			}
+
+			fmt.Printf("Restoring %q\n", target)
+			if err := replacePath(backupPath, target); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// ensureStopped returns an error if any of the node containers is running
+func (d DockerBackupProvider) ensureStopped(ctx context.Context, client *docker.BasicManager) error {
+	runningContainers := []string{}
+	for _, container := range d.containers {
+		running, err := client.IsContainerRunning(ctx, container.Name)
+		if err != nil {
+			return err
+		}
+		if running {
+			runningContainers = append(runningContainers, container.Name)
+		}
+	}
+
+	if len(runningContainers) > 0 {
+		return fmt.Errorf("containers are still running: %s. Please stop the node first", strings.Join(runningContainers, ", "))
+	}
+
+	return nil
+}
+
+// backupSource returns the path that gets backed up for a bind or encrypted secret mount
+//
+// Encrypted secrets are backed up encrypted, their plaintext only exists while the node runs and must never end up in
+// a backup.
+func backupSource(client *docker.BasicManager, mount docker.Mount) (string, error) {
+	if mount.Type == docker.MountTypeEncryptedSecret {
+		mount.Type = "bind"
+	}
+
+	return client.MountSource(mount)
+}
+
+// bindMountBackupName returns the name under which a bind mount is stored in the backup
+func bindMountBackupName(mount docker.Mount) string {
+	return strings.Trim(strings.Replace(mount.To, "/", "_", -1), "_")
+}
+
+// copyPath copies a file or a directory
+func copyPath(src, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		return fileutil.CopyDir(src, dst)
+	}
+
+	if !info.Mode().IsRegular() {
+		fmt.Printf("Skipping copying special file %q\n", src)
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+
+	return fileutil.CopyFile(src, dst)
+}
+
+// replacePath replaces dst with a copy of src
+//
+// src is copied next to dst first so dst is only removed once the copy succeeded.
+func replacePath(src, dst string) error {
+	staging := dst + ".bpm-restore"
+
+	if err := os.RemoveAll(staging); err != nil {
+		return err
+	}
+
+	if err := copyPath(src, staging); err != nil {
+		os.RemoveAll(staging)
+		return err
+	}
+
+	// Special files are not copied, keep what's there
+	exists, err := fileutil.FileExists(staging)
+	if err != nil || !exists {
+		return err
+	}
+
+	if err := os.RemoveAll(dst); err != nil {
+		return err
+	}
+
+	return os.Rename(staging, dst)
+}
@@ -0,0 +1,61 @@
+package plugin
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.blockdaemon.com/bpm/sdk/pkg/docker"
+	"go.blockdaemon.com/bpm/sdk/pkg/secrets"
+)
+
+func TestBackupAndRestoreEncryptedSecrets(t *testing.T) {
+	for name, leftoverPlaintext := range map[string]bool{"stopped": false, "leftover plaintext": true} {
+		t.Run(name, func(t *testing.T) {
+			currentNode, cleanup := testNode(t)
+			defer cleanup()
+
+			fake := newFakeDocker(t)
+			defer fake.close()
+			fake.use(currentNode)
+
+			require.NoError(t, currentNode.Save())
+
+			secretPath := filepath.Join(currentNode.NodeDirectory(), "secrets", "key.enc")
+			require.NoError(t, os.MkdirAll(filepath.Dir(secretPath), 0700))
+			require.NoError(t, ioutil.WriteFile(secretPath, []byte("ciphertext"), 0600))
+
+			if leftoverPlaintext {
+				plaintextPath := secrets.PlaintextPath(currentNode, secretPath)
+				require.NoError(t, os.MkdirAll(filepath.Dir(plaintextPath), 0700))
+				defer os.RemoveAll(secrets.PlaintextDirectory(currentNode))
+				require.NoError(t, ioutil.WriteFile(plaintextPath, []byte("plaintext"), 0600))
+			}
+
+			provider := NewDockerBackupProvider([]docker.Container{
+				{
+					Name:   "client",
+					Mounts: []docker.Mount{{Type: docker.MountTypeEncryptedSecret, From: "secrets/key.enc", To: "/secrets/key"}},
+				},
+			})
+
+			backupDir := filepath.Join(currentNode.NodeDirectory(), "backup")
+			require.NoError(t, provider.Backup(context.Background(), currentNode, backupDir))
+
+			backedUp, err := ioutil.ReadFile(filepath.Join(backupDir, backupBindMountsDir, "client", "secrets_key"))
+			require.NoError(t, err)
+			assert.Equal(t, "ciphertext", string(backedUp), "the secret is backed up encrypted")
+
+			require.NoError(t, ioutil.WriteFile(secretPath, []byte("changed"), 0600))
+			require.NoError(t, provider.Restore(context.Background(), currentNode, backupDir))
+
+			restored, err := ioutil.ReadFile(secretPath)
+			require.NoError(t, err)
+			assert.Equal(t, "ciphertext", string(restored))
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/docker/docker/api/types/versions"
+	"go.blockdaemon.com/bpm/sdk/pkg/docker"
+	"go.blockdaemon.com/bpm/sdk/pkg/node"
+)
+
+// The oldest docker API version supporting all features used by BasicManager (e.g. mounts)
+const minDockerAPIVersion = "1.25"
+
+// DockerEnvironmentValidator checks that the host can run a docker based node
+type DockerEnvironmentValidator struct {
+	containers []docker.Container
+}
+
+// NewDockerEnvironmentValidator creates an instance of DockerEnvironmentValidator
+func NewDockerEnvironmentValidator(containers []docker.Container) DockerEnvironmentValidator {
+	return DockerEnvironmentValidator{
+		containers: containers,
+	}
+}
+
+// ValidateEnvironment checks the docker daemon, the monitoring pack and the host ports
+//
+// The docker daemon has to be reachable and support at least API version 1.25. The host ports of containers that
+// are not running yet have to be free, ports of running containers are expected to be taken by the node itself.
+// The ports are probed on this host, so they are not checked if the docker daemon is remote.
+// Privileged containers are allowed but a warning is printed.
+func (d DockerEnvironmentValidator) ValidateEnvironment(ctx context.Context, currentNode node.Node) error {
+	client, err := docker.NewBasicManagerWithContext(ctx, currentNode)
+	if err != nil {
+		return err
+	}
+
+	apiVersion, err := client.ServerAPIVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if versions.LessThan(apiVersion, minDockerAPIVersion) {
+		return fmt.Errorf("the docker daemon supports API version %s but at least %s is required", apiVersion, minDockerAPIVersion)
+	}
+
+	if monitoringPack := currentNode.StrParameters["monitoring-pack"]; monitoringPack != "" {
+		file, err := os.Open(currentNode.ExpandPath(monitoringPack))
+		if err != nil {
+			return fmt.Errorf("cannot read the monitoring pack: %s", err)
+		}
+		file.Close()
+	}
+
+	checkPorts := !client.IsRemote()
+	if !checkPorts {
+		fmt.Println("WARNING: The docker daemon is remote, the host ports cannot be checked")
+	}
+
+	for _, container := range d.containers {
+		if container.Privileged {
+			fmt.Printf("WARNING: Container %q runs privileged and has full access to the host\n", container.Name)
+		}
+
+		if !checkPorts || len(container.Ports) == 0 {
+			continue
+		}
+
+		running, err := client.IsContainerRunning(ctx, container.Name)
+		if err != nil {
+			return err
+		}
+		if running {
+			continue
+		}
+
+		for _, port := range container.Ports {
+			if err := portAvailable(port); err != nil {
+				return fmt.Errorf("port %s/%s of container %q is not available: %s", port.HostPort, port.Protocol, container.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// portAvailable checks if a host port is free by briefly listening on it
+func portAvailable(port docker.Port) error {
+	address := net.JoinHostPort(port.HostIP, port.HostPort)
+
+	if port.Protocol == "udp" {
+		conn, err := net.ListenPacket("udp", address)
+		if err != nil {
+			return err
+		}
+
+		return conn.Close()
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+
+	return listener.Close()
+}
@@ -0,0 +1,76 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"go.blockdaemon.com/bpm/sdk/pkg/docker"
+	"go.blockdaemon.com/bpm/sdk/pkg/node"
+)
+
+// DockerHealthChecker reports the health of a node based on the docker health checks of its containers
+//
+// A node is healthy if all containers run and none of them is unhealthy or still starting. Containers whose image
+// doesn't define a health check only need to run.
+type DockerHealthChecker struct {
+	containers []docker.Container
+}
+
+// NewDockerHealthChecker creates an instance of DockerHealthChecker
+func NewDockerHealthChecker(containers []docker.Container) DockerHealthChecker {
+	return DockerHealthChecker{
+		containers: containers,
+	}
+}
+
+// HealthCheck returns the health of the node
+//
+// The details contain the health check state of every container by name.
+func (d DockerHealthChecker) HealthCheck(ctx context.Context, currentNode node.Node) (HealthStatus, error) {
+	client, err := docker.NewBasicManagerWithContext(ctx, currentNode)
+	if err != nil {
+		return HealthStatus{}, err
+	}
+
+	status := HealthStatus{
+		Healthy: true,
+		Details: map[string]interface{}{},
+	}
+	problems := []string{}
+
+	for _, container := range d.containers {
+		health, err := client.ContainerHealth(ctx, container.Name)
+		if err != nil {
+			return HealthStatus{}, err
+		}
+
+		status.Details[container.Name] = map[string]interface{}{
+			"running":        health.Running,
+			"health":         health.Status,
+			"failing_streak": health.FailingStreak,
+			"last_output":    health.LastOutput,
+		}
+
+		switch {
+		case !health.Exists:
+			problems = append(problems, fmt.Sprintf("container %q doesn't exist", container.Name))
+		case !health.Running:
+			problems = append(problems, fmt.Sprintf("container %q is not running", container.Name))
+		case health.Status == types.Unhealthy:
+			problems = append(problems, fmt.Sprintf("container %q is unhealthy after %d failed checks", container.Name, health.FailingStreak))
+		case health.Status == types.Starting:
+			problems = append(problems, fmt.Sprintf("container %q is still starting", container.Name))
+		}
+	}
+
+	if len(problems) > 0 {
+		status.Healthy = false
+		status.Message = strings.Join(problems, "; ")
+	} else {
+		status.Message = "all containers are healthy"
+	}
+
+	return status, nil
+}
@@ -14,45 +14,148 @@ import (
 	"text/template"
 	"time"
 
+	"github.com/thoas/go-funk"
 	"go.blockdaemon.com/bpm/sdk/pkg/docker"
+	"go.blockdaemon.com/bpm/sdk/pkg/docker/compose"
+	"go.blockdaemon.com/bpm/sdk/pkg/docker/image"
 	"go.blockdaemon.com/bpm/sdk/pkg/fileutil"
 	"go.blockdaemon.com/bpm/sdk/pkg/node"
+	"go.blockdaemon.com/bpm/sdk/pkg/plugin/container_reaper"
+	"go.blockdaemon.com/bpm/sdk/pkg/progress"
+	"go.blockdaemon.com/bpm/sdk/pkg/secrets"
 	sdktemplate "go.blockdaemon.com/bpm/sdk/pkg/template"
+	"golang.org/x/sync/errgroup"
 )
 
 // DockerLifecycleHandler provides functions to manage a node using plain docker containers
 type DockerLifecycleHandler struct {
 	containers []docker.Container
+
+	// DisableFilebeat prevents the filebeat container from being started. This is useful in environments that
+	// already collect logs centrally.
+	DisableFilebeat bool
+
+	// FilebeatImage is the container image used for filebeat. Defaults to the image tested with this SDK if empty.
+	FilebeatImage string
+
+	// FilebeatConfigTemplate replaces the filebeat inputs and processors, the output still comes from the monitoring
+	// pack. It gets the same data as DefaultFilebeatConfigTemplate. Defaults to DefaultFilebeatConfigTemplate if empty.
+	FilebeatConfigTemplate string
+
+	// FilebeatLogFiles are globs of log files written by processes other than the container main process, e.g.
+	// "logs/node-*.log". Filebeat ships them in addition to the container logs. The globs are rendered as templates
+	// with the node data and have to be within the node directory, relative globs are relative to it.
+	FilebeatLogFiles []string
+
+	// FilebeatInputOptions are added to the container input of the default filebeat config, one YAML line each,
+	// e.g. "multiline.pattern: '^[[:space:]]'" to group stack traces into one event
+	FilebeatInputOptions []string
+
+	// KeepLogs prevents TearDownEnvironment from removing the logs directory, e.g. for post-mortem analysis
+	KeepLogs bool
+
+	// MetricsAddr is the address (e.g. ":9100") on which Start serves prometheus metrics about the containers.
+	// This is only useful when the SDK is used as a library in a long running process. The metrics are served by a
+	// goroutine that ends with the process, so a plugin run from the command line stops serving right after start.
+	MetricsAddr string
+
+	// MonitoringFields are added to every log event collected by filebeat, e.g. the network name or the region
+	MonitoringFields map[string]string
+
+	// MonitoringProcessors are filebeat processor definitions (YAML list items, e.g. "- add_host_metadata: ~")
+	// that are added to the filebeat config
+	MonitoringProcessors []string
+
+	// RestartStablePeriod enables waiting for each container to become ready (see BasicManager.ContainerReady) during
+	// Restart before the next container is restarted. Containers without health check are ready after running for this
+	// period without restarting. If zero, Restart doesn't wait.
+	RestartStablePeriod time.Duration
+
+	// SafeUpgrades makes NewDockerPlugin use a SafeDockerUpgrader instead of a DockerUpgrader. SafeUpgradeStablePeriod
+	// is its ReadyStablePeriod, the SafeDockerUpgrader default is used if it is zero.
+	SafeUpgrades            bool
+	SafeUpgradeStablePeriod time.Duration
+
+	// CrashLoopRestarts and CrashLoopPeriod define when a container that is not restarting right now is considered
+	// crashing: docker restarted it more than CrashLoopRestarts times in total and the last restart was less than
+	// CrashLoopPeriod ago. Default to 3 and 10 minutes.
+	CrashLoopRestarts int
+	CrashLoopPeriod   time.Duration
+
+	// SetupConcurrency is the maximum number of steps SetUpEnvironment runs concurrently. Steps run one after
+	// another if it is 0 or 1.
+	SetupConcurrency int
+
+	// ProgressEmitter receives progress events, e.g. of image pulls. If nil, the emitter attached to the context is
+	// used (see progress.ContextWithEmitter).
+	ProgressEmitter progress.Emitter
+}
+
+// DockerLifecycleHandlerOption is a functional option to configure a DockerLifecycleHandler
+type DockerLifecycleHandlerOption func(*DockerLifecycleHandler)
+
+// WithFilebeatDisabled disables the filebeat container
+func WithFilebeatDisabled() DockerLifecycleHandlerOption {
+	return func(d *DockerLifecycleHandler) {
+		d.DisableFilebeat = true
+	}
 }
 
 const (
 	// LogsDirectory is the subdirectory under the node directory where logs are saved
-	LogsDirectory          = "logs"
+	LogsDirectory          = node.LogsDirectoryName
 	filebeatContainerImage = "docker.elastic.co/beats/filebeat:7.4.1"
 	filebeatContainerName  = "filebeat"
 	filebeatConfigFile     = "filebeat.yml"
 	filebeatBaseConfigTpl  = `filebeat.inputs:
+{{- if .PluginData.ContainerLogPaths }}
 - type: container
   paths:
-  - '/var/lib/docker/containers/*/*.log'
+  {{- range .PluginData.ContainerLogPaths }}
+  - '{{ . }}'
+  {{- end }}
+{{- range .PluginData.InputOptions }}
+  {{ . }}
+{{- end }}
+{{- end }}
+{{- if .PluginData.LogFiles }}
+- type: log
+  paths:
+  {{- range .PluginData.LogFiles }}
+  - '{{ . }}'
+  {{- end }}
+{{- end }}
 fields:
   node:
-    project: development
+    project: {{ printf "%q" .PluginData.Project }}
     protocol_type: {{ .Node.PluginName | ToUpper }}
-    user_id: bpm
+    user_id: {{ printf "%q" .PluginData.UserID }}
     xid: {{ .Node.ID }}
+{{- range $key, $value := .PluginData.Fields }}
+  {{ $key }}: {{ printf "%q" $value }}
+{{- end }}
 fields_under_root: true
 processors:
+{{- if .PluginData.DockerMetadata }}
 - add_docker_metadata: null
-{{- if .PluginData.Containers }}
+{{- end }}
+{{- range .PluginData.Processors }}
+{{ . }}
+{{- end }}
+{{- if not .PluginData.DockerMetadata }}
+- add_fields:
+    fields.log_type: user
+    target: ''
+{{- else if or .PluginData.LogContainers .PluginData.LogFiles }}
 - else.add_fields:
     fields.log_type: system
     target: ''
   if.or:
-  {{- range $container := .PluginData.Containers }}
-    {{- if $container.CollectLogs }}
+  {{- range $container := .PluginData.LogContainers }}
   - equals.container.name: {{ $.Node.NamePrefix }}{{ $container.Name }}
-    {{- end }}
+  {{- end }}
+  {{- if .PluginData.LogFiles }}
+  - equals.input.type: log
   {{- end }}
   then.add_fields:
     fields.log_type: user
@@ -60,15 +163,241 @@ processors:
 {{- end }}
 - drop_event.when.not.equals.log_type: user
 `
-	filebeatConsoleConfigTpl = `output:
+	// DefaultFilebeatConfigTemplate defines the filebeat inputs and processors, see
+	// DockerLifecycleHandler.FilebeatConfigTemplate. It gets the node as .Node and the following .PluginData:
+	// Containers, LogContainers (containers with CollectLogs), ContainerLogPaths (the docker log files of the
+	// LogContainers that exist), Project, UserID, Fields, Processors, InputOptions, LogFiles (rendered
+	// FilebeatLogFiles), Parameters (all node parameters) and DockerMetadata (whether the docker socket is mounted).
+	DefaultFilebeatConfigTemplate = filebeatBaseConfigTpl
+	filebeatConsoleConfigTpl      = `output:
   console:
     pretty: true
 `
 )
 
+// FilebeatDockerMetadataParameter is the node parameter that mounts the docker socket into the filebeat container
+// so it can add container metadata (e.g. the container name) to log events.
+//
+// It is off by default because access to the docker socket equals root access to the host. Container logs are
+// collected either way, filebeat only reads the log files of the node containers (see containerLogPaths).
+const FilebeatDockerMetadataParameter = "filebeat-docker-metadata"
+
+// dockerContainersDirectory is where docker keeps the json-file logs of the containers, one directory per container ID
+const dockerContainersDirectory = "/var/lib/docker/containers"
+
+// FilebeatDataDirectory is the subdirectory under the node directory where filebeat keeps its registry
+// (which log lines were shipped already) so it survives recreating the filebeat container
+const FilebeatDataDirectory = "filebeat-data"
+
+// Defaults used if the monitoring-project and monitoring-user-id parameters are not set
+const (
+	defaultMonitoringProject = "development"
+	defaultMonitoringUserID  = "bpm"
+)
+
+// Defaults for DockerLifecycleHandler.CrashLoopRestarts and DockerLifecycleHandler.CrashLoopPeriod
+const (
+	defaultCrashLoopRestarts = 3
+	defaultCrashLoopPeriod   = 10 * time.Minute
+)
+
+// WithFilebeatImage sets the container image used for filebeat, e.g. to use a mirrored registry
+func WithFilebeatImage(image string) DockerLifecycleHandlerOption {
+	return func(d *DockerLifecycleHandler) {
+		d.FilebeatImage = image
+	}
+}
+
+// WithFilebeatConfigTemplate replaces the filebeat inputs and processors (see DefaultFilebeatConfigTemplate)
+func WithFilebeatConfigTemplate(tpl string) DockerLifecycleHandlerOption {
+	return func(d *DockerLifecycleHandler) {
+		d.FilebeatConfigTemplate = tpl
+	}
+}
+
+// WithFilebeatInputOptions adds options to the container input of the default filebeat config, e.g. for multiline
+func WithFilebeatInputOptions(options ...string) DockerLifecycleHandlerOption {
+	return func(d *DockerLifecycleHandler) {
+		d.FilebeatInputOptions = append(d.FilebeatInputOptions, options...)
+	}
+}
+
+// WithFilebeatLogFiles makes filebeat ship log files within the node directory (see DockerLifecycleHandler.FilebeatLogFiles)
+func WithFilebeatLogFiles(globs ...string) DockerLifecycleHandlerOption {
+	return func(d *DockerLifecycleHandler) {
+		d.FilebeatLogFiles = append(d.FilebeatLogFiles, globs...)
+	}
+}
+
+// WithKeepLogs keeps the logs directory when tearing down the environment
+func WithKeepLogs() DockerLifecycleHandlerOption {
+	return func(d *DockerLifecycleHandler) {
+		d.KeepLogs = true
+	}
+}
+
+// WithMetricsAddr serves prometheus metrics about the containers on addr
+//
+// Only use it when the SDK is embedded in a long running process, see DockerLifecycleHandler.MetricsAddr. For the
+// plugin command line there is nothing left to scrape after the start command returned.
+func WithMetricsAddr(addr string) DockerLifecycleHandlerOption {
+	return func(d *DockerLifecycleHandler) {
+		d.MetricsAddr = addr
+	}
+}
+
+// WithMonitoringFields adds fields to every log event collected by filebeat
+func WithMonitoringFields(fields map[string]string) DockerLifecycleHandlerOption {
+	return func(d *DockerLifecycleHandler) {
+		d.MonitoringFields = fields
+	}
+}
+
+// WithMonitoringProcessors adds filebeat processor definitions to the filebeat config
+func WithMonitoringProcessors(processors ...string) DockerLifecycleHandlerOption {
+	return func(d *DockerLifecycleHandler) {
+		d.MonitoringProcessors = append(d.MonitoringProcessors, processors...)
+	}
+}
+
+// WithRestartReadyCheck makes Restart wait until each container is ready before restarting the next one
+func WithRestartReadyCheck(stablePeriod time.Duration) DockerLifecycleHandlerOption {
+	return func(d *DockerLifecycleHandler) {
+		d.RestartStablePeriod = stablePeriod
+	}
+}
+
+// WithSafeUpgrades makes NewDockerPlugin use a SafeDockerUpgrader, see DockerLifecycleHandler.SafeUpgrades
+func WithSafeUpgrades(stablePeriod time.Duration) DockerLifecycleHandlerOption {
+	return func(d *DockerLifecycleHandler) {
+		d.SafeUpgrades = true
+		d.SafeUpgradeStablePeriod = stablePeriod
+	}
+}
+
+// WithConcurrentSetup makes SetUpEnvironment run up to concurrency independent steps at the same time
+func WithConcurrentSetup(concurrency int) DockerLifecycleHandlerOption {
+	return func(d *DockerLifecycleHandler) {
+		d.SetupConcurrency = concurrency
+	}
+}
+
+// WithCrashLoopDetection sets when a container is considered crashing (see DockerLifecycleHandler.CrashLoopRestarts)
+func WithCrashLoopDetection(restarts int, period time.Duration) DockerLifecycleHandlerOption {
+	return func(d *DockerLifecycleHandler) {
+		d.CrashLoopRestarts = restarts
+		d.CrashLoopPeriod = period
+	}
+}
+
+// WithProgressEmitter sends progress events to e (see DockerLifecycleHandler.ProgressEmitter)
+func WithProgressEmitter(e progress.Emitter) DockerLifecycleHandlerOption {
+	return func(d *DockerLifecycleHandler) {
+		d.ProgressEmitter = e
+	}
+}
+
 // NewDockerLifecycleHandler creates an instance of DockerLifecycleHandler
-func NewDockerLifecycleHandler(containers []docker.Container) DockerLifecycleHandler {
-	return DockerLifecycleHandler{containers: containers}
+func NewDockerLifecycleHandler(containers []docker.Container, options ...DockerLifecycleHandlerOption) DockerLifecycleHandler {
+	handler := DockerLifecycleHandler{containers: containers}
+
+	for _, option := range options {
+		option(&handler)
+	}
+
+	return handler
+}
+
+// basicManager connects to the docker daemon of a node, reporting progress to ProgressEmitter
+func (d DockerLifecycleHandler) basicManager(ctx context.Context, currentNode node.Node) (*docker.BasicManager, error) {
+	return docker.NewBasicManagerWithContext(ctx, currentNode, docker.WithProgressEmitter(d.ProgressEmitter))
+}
+
+// filebeatEnabled returns true if the filebeat container runs
+//
+// Filebeat refuses to start without inputs, it only runs if there is something to collect: containers with
+// CollectLogs or FilebeatLogFiles. A custom FilebeatConfigTemplate is assumed to define its own inputs.
+func (d DockerLifecycleHandler) filebeatEnabled() bool {
+	if d.DisableFilebeat {
+		return false
+	}
+
+	return len(d.logContainers()) > 0 || len(d.FilebeatLogFiles) > 0 || d.FilebeatConfigTemplate != ""
+}
+
+// logContainers returns the containers whose logs get collected, they are guaranteed to use the json-file log
+// driver filebeat can read
+func (d DockerLifecycleHandler) logContainers() []docker.Container {
+	logContainers := []docker.Container{}
+	for _, container := range d.containers {
+		if container.CollectLogs {
+			logContainers = append(logContainers, container)
+		}
+	}
+
+	return logContainers
+}
+
+// containerLogPaths returns the docker log files of the containers with CollectLogs that exist
+//
+// Docker names the directories of the log files after the container IDs, which change whenever a container gets
+// recreated. Filebeat only reads these paths, so it doesn't collect the logs of other containers on the host even
+// without docker metadata.
+func (d DockerLifecycleHandler) containerLogPaths(ctx context.Context, client *docker.BasicManager) ([]string, error) {
+	paths := []string{}
+
+	for _, container := range d.logContainers() {
+		info, err := client.ContainerInfo(ctx, container.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		if info.Exists {
+			paths = append(paths, path.Join(dockerContainersDirectory, info.ID, "*.log"))
+		}
+	}
+
+	return paths, nil
+}
+
+func (d DockerLifecycleHandler) filebeatImage() string {
+	if d.FilebeatImage == "" {
+		return filebeatContainerImage
+	}
+
+	return d.FilebeatImage
+}
+
+func (d DockerLifecycleHandler) filebeatConfigTemplate() string {
+	if d.FilebeatConfigTemplate == "" {
+		return DefaultFilebeatConfigTemplate
+	}
+
+	return d.FilebeatConfigTemplate
+}
+
+// filebeatLogFiles renders the FilebeatLogFiles globs and makes them absolute
+func (d DockerLifecycleHandler) filebeatLogFiles(currentNode node.Node) ([]string, error) {
+	globs := []string{}
+
+	for _, glob := range d.FilebeatLogFiles {
+		rendered, err := sdktemplate.RenderString(glob, sdktemplate.TemplateData{Node: currentNode})
+		if err != nil {
+			return nil, fmt.Errorf("cannot render log file glob %q: %s", glob, err)
+		}
+
+		rendered = currentNode.ExpandPath(rendered)
+
+		// Filebeat only sees the node directory, see filebeatContainer
+		relative, err := filepath.Rel(currentNode.NodeDirectory(), rendered)
+		if err != nil || relative == ".." || strings.HasPrefix(relative, "../") {
+			return nil, fmt.Errorf("log file glob %q is not within the node directory %q", rendered, currentNode.NodeDirectory())
+		}
+
+		globs = append(globs, rendered)
+	}
+
+	return globs, nil
 }
 
 // renderMonitoringConfig renders the configuration file for filebeat
@@ -77,25 +406,25 @@ func NewDockerLifecycleHandler(containers []docker.Container) DockerLifecycleHan
 //
 // - If disabled we just use the base config and add a console output to it
 // - If enabled (via --monitoring-pack) we extract the monitoring pack which contains a filebeat output and combine it with the base config
-func (d DockerLifecycleHandler) renderMonitoringConfig(monitoringPath string, currentNode node.Node) error {
+//
+// The monitoring pack needs to contain a manifest.yml with the format version and the files it contains.
+// The base config can be replaced with FilebeatConfigTemplate. containerLogPaths are the log files of the node
+// containers, see containerLogPaths.
+func (d DockerLifecycleHandler) renderMonitoringConfig(ctx context.Context, monitoringPath string, currentNode node.Node, containerLogPaths []string) error {
 	filebeatConfigTpl := ""
 
 	if currentNode.StrParameters["monitoring-pack"] == "" {
 		fmt.Println("Forwarding of monitoring is disabled. Specify `--monitoring-pack` to enable it.")
 		// Instead of forwarding we'll just create filebeat with a simple log output
-		filebeatConfigTpl = filebeatBaseConfigTpl + "\n" + filebeatConsoleConfigTpl
+		filebeatConfigTpl = d.filebeatConfigTemplate() + "\n" + filebeatConsoleConfigTpl
 	} else {
 		fmt.Println("Enabling forwarding of monitoring data.")
 
-		if err := fileutil.ExtractTarGz(currentNode.StrParameters["monitoring-pack"], monitoringPath); err != nil {
-			return err
-		}
-
-		monitoringPackConfig, err := ioutil.ReadFile(filepath.Join(monitoringPath, "config.tpl"))
+		monitoringPackConfig, err := ioutil.ReadFile(filepath.Join(monitoringPath, monitoringPackConfigFile))
 		if err != nil {
 			return err
 		}
-		filebeatConfigTpl = filebeatBaseConfigTpl + "\n" + string(monitoringPackConfig)
+		filebeatConfigTpl = d.filebeatConfigTemplate() + "\n" + string(monitoringPackConfig)
 	}
 
 	// Render filebeat config
@@ -107,9 +436,44 @@ func (d DockerLifecycleHandler) renderMonitoringConfig(monitoringPath string, cu
 	if err != nil {
 		return err
 	}
+	project := currentNode.StrParameters["monitoring-project"]
+	if project == "" {
+		project = defaultMonitoringProject
+	}
+	logFiles, err := d.filebeatLogFiles(currentNode)
+	if err != nil {
+		return err
+	}
+
+	userID := currentNode.StrParameters["monitoring-user-id"]
+	if userID == "" {
+		userID = defaultMonitoringUserID
+	}
+
+	// All node parameters in one map so a monitoring pack can easily reference them
+	parameters := map[string]interface{}{}
+	for name, value := range currentNode.StrParameters {
+		parameters[name] = value
+	}
+	for name, value := range currentNode.BoolParameters {
+		parameters[name] = value
+	}
+
 	templateData := sdktemplate.TemplateData{
-		Node:       currentNode,
-		PluginData: map[string]interface{}{"Containers": d.containers},
+		Node: currentNode,
+		PluginData: map[string]interface{}{
+			"Containers":        d.containers,
+			"LogContainers":     d.logContainers(),
+			"ContainerLogPaths": containerLogPaths,
+			"Project":           project,
+			"UserID":            userID,
+			"Fields":            d.MonitoringFields,
+			"Processors":        d.MonitoringProcessors,
+			"InputOptions":      d.FilebeatInputOptions,
+			"LogFiles":          logFiles,
+			"Parameters":        parameters,
+			"DockerMetadata":    currentNode.BoolParameters[FilebeatDockerMetadataParameter],
+		},
 	}
 	output := bytes.NewBufferString("")
 	err = tmpl.Execute(output, templateData)
@@ -117,81 +481,226 @@ func (d DockerLifecycleHandler) renderMonitoringConfig(monitoringPath string, cu
 		return err
 	}
 
-	return ioutil.WriteFile(outputFilename, output.Bytes(), 0644)
+	// Catch errors in the templates now rather than when the filebeat container fails to start
+	if err := validateYAML(output.Bytes()); err != nil {
+		return fmt.Errorf("the rendered filebeat config is invalid: %s", err)
+	}
+
+	if err := ioutil.WriteFile(outputFilename, output.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	progress.Emit(ctx, d.ProgressEmitter, progress.StageRender, outputFilename, "rendered filebeat config", 100)
+
+	return nil
 }
 
 // SetUpEnvironment configures the monitoring agents
-func (d DockerLifecycleHandler) SetUpEnvironment(currentNode node.Node) error {
-	client, err := docker.NewBasicManager(currentNode)
+//
+// Independent steps (creating directories, rendering configs) run concurrently if enabled with WithConcurrentSetup.
+func (d DockerLifecycleHandler) SetUpEnvironment(ctx context.Context, currentNode node.Node) error {
+	client, err := d.basicManager(ctx, currentNode)
 	if err != nil {
 		return err
 	}
 
-	// Create logs directory if it doesn't exist yet
-	_, err = fileutil.MakeDirectory(currentNode.NodeDirectory(), LogsDirectory)
-	if err != nil {
+	collectMetrics := currentNode.BoolParameters["collect-metrics"]
+	runFilebeat := d.filebeatEnabled()
+	monitoringPath := client.AddBasePath("monitoring")
+
+	// Create directories if they don't exist yet
+	dirs := []string{currentNode.LogsDirectory(), currentNode.DataDirectory()}
+	if runFilebeat || collectMetrics {
+		dirs = append(dirs, monitoringPath)
+	}
+	if runFilebeat {
+		// The filebeat data directory is not part of the monitoring directory because that gets removed by
+		// TearDownEnvironment while the registry should only be removed together with the data
+		dirs = append(dirs, client.AddBasePath(FilebeatDataDirectory))
+	}
+
+	tasks := []func() error{}
+	for _, dir := range dirs {
+		dir := dir
+		tasks = append(tasks, func() error {
+			_, err := fileutil.MakeDirectory(dir)
+			return err
+		})
+	}
+
+	if err := d.runSetupTasks(ctx, tasks...); err != nil {
 		return err
 	}
 
-	// Create data directory if it doesn't exist yet
-	_, err = fileutil.MakeDirectory(client.AddBasePath(currentNode.StrParameters["data-dir"]))
-	if err != nil {
+	// Create the docker network if it doesn't exist yet
+	if err := client.NetworkExists(ctx, currentNode.DockerNetwork()); err != nil {
 		return err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
-	defer cancel()
+	if !runFilebeat && !collectMetrics {
+		fmt.Println("Filebeat is disabled, skipping monitoring set up")
+		return nil
+	}
 
-	// Create the docker network if it doesn't exist yet
-	if err := client.NetworkExists(ctx, currentNode.StrParameters["docker-network"]); err != nil {
+	if err := extractMonitoringPack(monitoringPath, currentNode); err != nil {
 		return err
 	}
 
-	// Create monitoring directory
-	monitoringPath := client.AddBasePath("monitoring")
-	_, err = fileutil.MakeDirectory(monitoringPath)
+	// Render the configs
+	tasks = []func() error{}
+	if runFilebeat {
+		tasks = append(tasks, func() error {
+			// Catches errors in the config early, Start renders it again once the containers exist
+			containerLogPaths, err := d.containerLogPaths(ctx, client)
+			if err != nil {
+				return err
+			}
+
+			return d.renderMonitoringConfig(ctx, monitoringPath, currentNode, containerLogPaths)
+		})
+	}
+	if collectMetrics {
+		tasks = append(tasks, func() error {
+			return d.renderMetricsConfig(ctx, monitoringPath, currentNode)
+		})
+	}
+
+	return d.runSetupTasks(ctx, tasks...)
+}
+
+// runSetupTasks runs independent set up tasks, concurrently if SetupConcurrency is greater than 1
+//
+// The first error is returned, tasks that didn't start yet are skipped.
+func (d DockerLifecycleHandler) runSetupTasks(ctx context.Context, tasks ...func() error) error {
+	if d.SetupConcurrency <= 1 {
+		for _, task := range tasks {
+			if err := task(); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	group, ctx := errgroup.WithContext(ctx)
+	semaphore := make(chan struct{}, d.SetupConcurrency)
+
+	for _, task := range tasks {
+		task := task
+		group.Go(func() error {
+			select {
+			case semaphore <- struct{}{}:
+				defer func() { <-semaphore }()
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			return task()
+		})
+	}
+
+	return group.Wait()
+}
+
+// TearDownEnvironment removes everything created by SetUpEnvironment except the data directory
+//
+// The docker network is only removed if it was created by this node and no other containers use it anymore.
+func (d DockerLifecycleHandler) TearDownEnvironment(ctx context.Context, currentNode node.Node) error {
+	client, err := d.basicManager(ctx, currentNode)
 	if err != nil {
 		return err
 	}
 
-	// Render the config
-	return d.renderMonitoringConfig(monitoringPath, currentNode)
+	// Remove monitoring directory
+	if err := directoryAbsent(client.AddBasePath("monitoring")); err != nil {
+		return err
+	}
+
+	// Remove the docker networks if nobody else needs them
+	for _, networkID := range d.networks(client, currentNode) {
+		canRemove, err := client.CanRemoveNetwork(ctx, networkID)
+		if err != nil {
+			return err
+		}
+		if canRemove {
+			if err := client.NetworkAbsent(ctx, networkID); err != nil {
+				return err
+			}
+		} else {
+			fmt.Printf("Network '%s' was not created by this node or is still in use, skipping removal\n", networkID)
+		}
+	}
+
+	// Remove logs directory
+	if d.KeepLogs {
+		fmt.Println("Keeping logs directory")
+		return nil
+	}
+
+	return directoryAbsent(currentNode.LogsDirectory())
 }
 
-// TearDownEnvironment is currently just a placeholder that does nothing
-func (d DockerLifecycleHandler) TearDownEnvironment(currentNode node.Node) error {
-	return nil
+// directoryAbsent removes a directory if it exists
+func directoryAbsent(dir string) error {
+	exists, err := fileutil.FileExists(dir)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		fmt.Printf("Cannot find directory %q, skipping removal\n", dir)
+		return nil
+	}
+
+	fmt.Printf("Removing directory %q\n", dir)
+	return os.RemoveAll(dir)
 }
 
-// Start starts monitoring agents and delegates to another function to start blockchain containers
-func (d DockerLifecycleHandler) Start(currentNode node.Node) error {
-	client, err := docker.NewBasicManager(currentNode)
+// fileAbsent removes a file if it exists
+func fileAbsent(file string) error {
+	exists, err := fileutil.FileExists(file)
 	if err != nil {
 		return err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
-	defer cancel()
+	if !exists {
+		fmt.Printf("Cannot find file %q, skipping removal\n", file)
+		return nil
+	}
+
+	fmt.Printf("Removing file %q\n", file)
+	return os.Remove(file)
+}
 
+// filebeatContainer returns the filebeat container that collects the logs of the node containers
+//
+// The docker socket is only mounted if enabled with FilebeatDockerMetadataParameter. The node directory is mounted
+// read only if FilebeatLogFiles are set.
+func (d DockerLifecycleHandler) filebeatContainer(client *docker.BasicManager, currentNode node.Node) docker.Container {
 	monitoringPath := client.AddBasePath("monitoring")
 	filebeatCombinedConfigPath := client.AddBasePath(path.Join("monitoring", filebeatConfigFile))
 
-	// Start filebeat container
-	filebeatContainer := docker.Container{
+	container := docker.Container{
 		Name:  filebeatContainerName,
-		Image: filebeatContainerImage,
+		Image: d.filebeatImage(),
 		Cmd:   []string{"-e", "-strict.perms=false"},
 		// using the first containers network is a decent default, if we ever do mult-network deployments we may need to rethink this
 		Mounts: []docker.Mount{
 			{
-				Type: "bind",
-				From: filebeatCombinedConfigPath,
-				To:   "/usr/share/filebeat/filebeat.yml",
+				Type:     "bind",
+				From:     filebeatCombinedConfigPath,
+				To:       "/usr/share/filebeat/filebeat.yml",
+				ReadOnly: true,
 			},
 			{
-				Type: "bind",
-				From: "/var/lib/docker/containers",
-				To:   "/var/lib/docker/containers",
+				Type:     "bind",
+				From:     dockerContainersDirectory,
+				To:       dockerContainersDirectory,
+				ReadOnly: true,
 			},
 			{
 				Type: "bind",
@@ -200,38 +709,271 @@ func (d DockerLifecycleHandler) Start(currentNode node.Node) error {
 			},
 			{
 				Type: "bind",
-				From: "/var/run/docker.sock",
-				To:   "/var/run/docker.sock",
+				From: client.AddBasePath(FilebeatDataDirectory),
+				To:   "/usr/share/filebeat/data",
 			},
 		},
 		User: "root",
 	}
 
-	if err := client.ContainerRuns(ctx, filebeatContainer); err != nil {
+	// Log files written next to the container logs (FilebeatLogFiles) are read at the same path as on the host
+	if len(d.FilebeatLogFiles) > 0 {
+		container.Mounts = append(container.Mounts, docker.Mount{
+			Type:     "bind",
+			From:     currentNode.NodeDirectory(),
+			To:       currentNode.NodeDirectory(),
+			ReadOnly: true,
+		})
+	}
+
+	if currentNode.BoolParameters[FilebeatDockerMetadataParameter] {
+		container.Mounts = append(container.Mounts, docker.Mount{
+			Type:     "bind",
+			From:     "/var/run/docker.sock",
+			To:       "/var/run/docker.sock",
+			ReadOnly: true,
+		})
+	}
+
+	return container
+}
+
+// Start starts monitoring agents and delegates to another function to start blockchain containers
+func (d DockerLifecycleHandler) Start(ctx context.Context, currentNode node.Node) error {
+	client, err := d.basicManager(ctx, currentNode)
+	if err != nil {
+		return err
+	}
+
+	monitoringPath := client.AddBasePath("monitoring")
+
+	// Containers that were created but never started (e.g. because a previous start crashed) get recreated
+	if err := container_reaper.ReapOrphans(ctx, client, d.managedContainers(client, currentNode)); err != nil {
 		return err
 	}
 
-	// Next, start the node containers
+	// Containers can be attached to networks other than the node network that was created when setting up the environment
+	for _, networkID := range d.networks(client, currentNode) {
+		if err := client.NetworkExists(ctx, networkID); err != nil {
+			return err
+		}
+	}
+
+	// Start the node containers
 	for _, container := range d.containers {
 		if err := client.ContainerRuns(ctx, container); err != nil {
 			return err
 		}
 	}
 
+	// Filebeat starts after the node containers because it needs their IDs, it still reads their logs from the start
+	if d.filebeatEnabled() {
+		if err := d.filebeatRuns(ctx, client, currentNode); err != nil {
+			return err
+		}
+	}
+
+	// Let prometheus know where to find the metrics
+	if err := d.writeMetricsTargets(monitoringPath, currentNode); err != nil {
+		return err
+	}
+
+	if currentNode.BoolParameters["collect-metrics"] {
+		metricsAgentConfigPath := client.AddBasePath(path.Join("monitoring", metricsAgentConfigFile))
+		if err := client.ContainerRunsWithConfigHash(ctx, metricsAgentContainer(client), metricsAgentConfigPath); err != nil {
+			return err
+		}
+	}
+
+	if d.MetricsAddr != "" {
+		go func() {
+			if err := d.ServeMetrics(ctx, currentNode); err != nil {
+				fmt.Printf("Serving metrics failed: %s\n", err)
+			}
+		}()
+	}
+
 	return nil
 }
 
+// filebeatRuns renders the filebeat config for the current node containers and makes sure filebeat runs with it
+//
+// Filebeat doesn't reload its config, the container is recreated if the config changed, e.g. because node containers
+// were recreated and got new IDs.
+func (d DockerLifecycleHandler) filebeatRuns(ctx context.Context, client *docker.BasicManager, currentNode node.Node) error {
+	containerLogPaths, err := d.containerLogPaths(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	if err := d.renderMonitoringConfig(ctx, client.AddBasePath("monitoring"), currentNode, containerLogPaths); err != nil {
+		return err
+	}
+
+	filebeatCombinedConfigPath := client.AddBasePath(path.Join("monitoring", filebeatConfigFile))
+
+	return client.ContainerRunsWithConfigHash(ctx, d.filebeatContainer(client, currentNode), filebeatCombinedConfigPath)
+}
+
+// UpdateLogCollection points a running filebeat to the current node containers
+//
+// Filebeat reads the container logs by container ID. Start takes care of this, but anything else that recreates node
+// containers (e.g. an Upgrader) has to call UpdateLogCollection afterwards or their logs are no longer collected.
+// Nothing happens if filebeat doesn't run.
+func (d DockerLifecycleHandler) UpdateLogCollection(ctx context.Context, currentNode node.Node) error {
+	if !d.filebeatEnabled() {
+		return nil
+	}
+
+	client, err := d.basicManager(ctx, currentNode)
+	if err != nil {
+		return err
+	}
+
+	running, err := client.IsContainerRunning(ctx, filebeatContainerName)
+	if err != nil || !running {
+		return err
+	}
+
+	return d.filebeatRuns(ctx, client, currentNode)
+}
+
+// PullImages downloads the images of all containers (including filebeat and the metrics agent if enabled) that
+// don't exist locally yet. Running containers are not touched.
+func (d DockerLifecycleHandler) PullImages(ctx context.Context, currentNode node.Node) error {
+	client, err := d.basicManager(ctx, currentNode)
+	if err != nil {
+		return err
+	}
+
+	images := []string{}
+	for _, container := range d.containers {
+		images = append(images, container.Image)
+	}
+
+	if d.filebeatEnabled() {
+		images = append(images, d.filebeatImage())
+	}
+
+	if currentNode.BoolParameters["collect-metrics"] {
+		images = append(images, metricsAgentContainer(client).Image)
+	}
+
+	return client.ImagesPulled(ctx, images)
+}
+
+// Drift compares the deployed containers (including filebeat and the metrics agent if enabled) with the
+// containers that Start would create
+func (d DockerLifecycleHandler) Drift(ctx context.Context, currentNode node.Node) (*compose.ComposeDiff, error) {
+	client, err := d.basicManager(ctx, currentNode)
+	if err != nil {
+		return nil, err
+	}
+
+	return compose.Diff(ctx, client, d.managedContainers(client, currentNode))
+}
+
+// managedContainers returns the node containers together with filebeat and the metrics agent if they are enabled
+func (d DockerLifecycleHandler) managedContainers(client *docker.BasicManager, currentNode node.Node) []docker.Container {
+	containers := []docker.Container{}
+	if d.filebeatEnabled() {
+		containers = append(containers, d.filebeatContainer(client, currentNode))
+	}
+
+	containers = append(containers, d.containers...)
+
+	if currentNode.BoolParameters["collect-metrics"] {
+		containers = append(containers, metricsAgentContainer(client))
+	}
+
+	return containers
+}
+
+// networks returns the node network and all other networks the node containers are attached to
+func (d DockerLifecycleHandler) networks(client *docker.BasicManager, currentNode node.Node) []string {
+	networks := []string{currentNode.DockerNetwork()}
+
+	for _, container := range d.containers {
+		for _, networkID := range client.ContainerNetworks(container) {
+			if !funk.ContainsString(networks, networkID) {
+				networks = append(networks, networkID)
+			}
+		}
+	}
+
+	return networks
+}
+
+// Stats returns the resource usage of all running containers, including filebeat and the metrics agent if enabled
+//
+// Containers that don't run are left out.
+func (d DockerLifecycleHandler) Stats(ctx context.Context, currentNode node.Node) ([]docker.Stats, error) {
+	client, err := d.basicManager(ctx, currentNode)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := []docker.Stats{}
+	for _, container := range d.managedContainers(client, currentNode) {
+		running, err := client.IsContainerRunning(ctx, container.Name)
+		if err != nil {
+			return nil, err
+		}
+		if !running {
+			continue
+		}
+
+		containerStats, err := client.ContainerStats(ctx, container.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		stats = append(stats, containerStats)
+	}
+
+	return stats, nil
+}
+
+// SBOMs returns the software bill of materials of the images of the node containers
+//
+// The images have to exist locally, e.g. after starting the node or running `pull-images`. Images used by several
+// containers are only analyzed once.
+func (d DockerLifecycleHandler) SBOMs(ctx context.Context, currentNode node.Node) ([]*image.SBOM, error) {
+	client, err := d.basicManager(ctx, currentNode)
+	if err != nil {
+		return nil, err
+	}
+
+	sboms := []*image.SBOM{}
+	seen := map[string]bool{}
+
+	for _, container := range d.containers {
+		if seen[container.Image] {
+			continue
+		}
+		seen[container.Image] = true
+
+		sbom, err := client.GenerateSBOM(ctx, container.Image)
+		if err != nil {
+			return nil, err
+		}
+
+		sboms = append(sboms, sbom)
+	}
+
+	return sboms, nil
+}
+
 // Status returns the status of the running blockchain client and monitoring containers
-func (d DockerLifecycleHandler) Status(currentNode node.Node) (string, error) {
-	client, err := docker.NewBasicManager(currentNode)
+//
+// The node is "unhealthy" if a container is stuck in a restart loop (see CrashingContainers).
+func (d DockerLifecycleHandler) Status(ctx context.Context, currentNode node.Node) (string, error) {
+	client, err := d.basicManager(ctx, currentNode)
 	if err != nil {
 		return "", err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
-	defer cancel()
-
-	exists, err := client.DoesNetworkExist(ctx, currentNode.StrParameters["docker-network"])
+	exists, err := client.DoesNetworkExist(ctx, currentNode.DockerNetwork())
 	if err != nil {
 		return "", err
 	}
@@ -239,7 +981,17 @@ func (d DockerLifecycleHandler) Status(currentNode node.Node) (string, error) {
 		return "incomplete", nil
 	}
 
+	crashing, err := d.CrashingContainers(ctx, currentNode)
+	if err != nil {
+		return "", err
+	}
+	if len(crashing) > 0 {
+		// A crash looping container is often reported as running, it has to be checked first
+		return "unhealthy", nil
+	}
+
 	containersRunning := 0
+	containersPaused := 0
 
 	for _, container := range d.containers {
 		running, err := client.IsContainerRunning(ctx, container.Name)
@@ -249,26 +1001,198 @@ func (d DockerLifecycleHandler) Status(currentNode node.Node) (string, error) {
 		if running {
 			containersRunning += 1
 		}
+
+		paused, err := client.IsContainerPaused(ctx, container.Name)
+		if err != nil {
+			return "", err
+		}
+		if paused {
+			containersPaused += 1
+		}
 	}
 
-	if containersRunning == 0 {
+	if len(d.containers) > 0 && containersPaused == len(d.containers) {
+		return "paused", nil
+	} else if containersRunning == 0 {
 		return "stopped", nil
-	} else if len(d.containers) == containersRunning {
+	} else if len(d.containers) == containersRunning && containersPaused == 0 {
+		// Paused containers count as running in docker, a partially paused node is not fully running
 		return "running", nil
 	}
 
 	return "incomplete", nil
 }
 
-// Stop removes all containers
-func (d DockerLifecycleHandler) Stop(currentNode node.Node) error {
-	client, err := docker.NewBasicManager(currentNode)
+// CrashingContainers returns the names of node containers that are stuck in a restart loop
+//
+// A container counts as crashing if docker is restarting it right now, i.e. it exited and waits for its next restart.
+// Otherwise it counts as crashing if it was restarted more than CrashLoopRestarts times in total and the last restart
+// was within CrashLoopPeriod. Docker only reports the total number of restarts and the time of the last start, so this
+// doesn't tell how many of the restarts happened within CrashLoopPeriod.
+func (d DockerLifecycleHandler) CrashingContainers(ctx context.Context, currentNode node.Node) ([]string, error) {
+	client, err := d.basicManager(ctx, currentNode)
+	if err != nil {
+		return nil, err
+	}
+
+	restarts := d.CrashLoopRestarts
+	if restarts <= 0 {
+		restarts = defaultCrashLoopRestarts
+	}
+	period := d.CrashLoopPeriod
+	if period <= 0 {
+		period = defaultCrashLoopPeriod
+	}
+
+	crashing := []string{}
+	for _, container := range d.containers {
+		info, err := client.ContainerInfo(ctx, container.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.Exists {
+			continue
+		}
+
+		if info.Restarting {
+			crashing = append(crashing, container.Name)
+			continue
+		}
+
+		if info.RestartCount > restarts && time.Since(info.StartedAt) < period {
+			crashing = append(crashing, container.Name)
+		}
+	}
+
+	return crashing, nil
+}
+
+// Pause pauses all node containers. Filebeat keeps running so the logs up to the pause are still collected.
+func (d DockerLifecycleHandler) Pause(ctx context.Context, currentNode node.Node) error {
+	client, err := d.basicManager(ctx, currentNode)
+	if err != nil {
+		return err
+	}
+
+	for _, container := range d.containers {
+		if err := client.ContainerPaused(ctx, container); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Resume unpauses all node containers
+func (d DockerLifecycleHandler) Resume(ctx context.Context, currentNode node.Node) error {
+	client, err := d.basicManager(ctx, currentNode)
+	if err != nil {
+		return err
+	}
+
+	for _, container := range d.containers {
+		if err := client.ContainerUnpaused(ctx, container); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Restart restarts the node containers one at a time so at most one container is down at any time
+//
+// This is useful to pick up configuration changes without taking the whole node down. The monitoring containers keep
+// running so the logs of the restart are collected.
+func (d DockerLifecycleHandler) Restart(ctx context.Context, currentNode node.Node) error {
+	client, err := d.basicManager(ctx, currentNode)
+	if err != nil {
+		return err
+	}
+
+	for _, container := range d.containers {
+		// ContainerStopped returns once the container exited
+		if err := client.ContainerStopped(ctx, container); err != nil {
+			return err
+		}
+
+		if err := client.ContainerRuns(ctx, container); err != nil {
+			return err
+		}
+
+		if d.RestartStablePeriod > 0 {
+			if err := client.ContainerReady(ctx, container.Name, d.RestartStablePeriod); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Logs returns the last lines of the logs of a container
+//
+// If containerName is empty, the logs of all node containers are returned one after another.
+func (d DockerLifecycleHandler) Logs(ctx context.Context, currentNode node.Node, containerName string, tail int) (string, error) {
+	client, err := d.basicManager(ctx, currentNode)
+	if err != nil {
+		return "", err
+	}
+
+	if containerName != "" {
+		return client.GetContainerLogs(ctx, containerName, tail)
+	}
+
+	output := ""
+	for _, container := range d.containers {
+		logs, err := client.GetContainerLogs(ctx, container.Name, tail)
+		if err != nil {
+			return "", err
+		}
+
+		output += fmt.Sprintf("==> %s <==\n%s\n", container.Name, logs)
+	}
+
+	return output, nil
+}
+
+// RotateLogs sends the configured LogRotation.Signal to all containers so they re-open their log files and
+// truncates old log files in LogRotation.TruncateDir
+//
+// The container logs collected by docker itself are rotated automatically according to LogRotation.
+func (d DockerLifecycleHandler) RotateLogs(ctx context.Context, currentNode node.Node) error {
+	client, err := d.basicManager(ctx, currentNode)
 	if err != nil {
 		return err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
-	defer cancel()
+	for _, container := range d.containers {
+		if container.LogRotation.Signal != "" {
+			if err := client.ContainerSignal(ctx, container.Name, container.LogRotation.Signal); err != nil {
+				return err
+			}
+		}
+
+		if container.LogRotation.TruncateDir != "" {
+			truncated, err := fileutil.TruncateOlderThan(client.AddBasePath(container.LogRotation.TruncateDir), container.LogRotation.TruncateOlderThan)
+			for _, file := range truncated {
+				fmt.Printf("Truncated log file %q\n", file)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Stop removes all containers
+func (d DockerLifecycleHandler) Stop(ctx context.Context, currentNode node.Node) error {
+	client, err := d.basicManager(ctx, currentNode)
+	if err != nil {
+		return err
+	}
 
 	for _, container := range d.containers {
 		if err = client.ContainerStopped(ctx, container); err != nil {
@@ -276,6 +1200,20 @@ func (d DockerLifecycleHandler) Stop(currentNode node.Node) error {
 		}
 	}
 
+	// The containers don't need the decrypted secrets anymore, they are decrypted again on start
+	if err := secrets.PlaintextsAbsent(currentNode); err != nil {
+		return err
+	}
+
+	// Also if collect-metrics is off, it might have been on when the agent was started
+	if err = client.ContainerStopped(ctx, docker.Container{Name: metricsAgentContainerName}); err != nil {
+		return err
+	}
+
+	if d.DisableFilebeat {
+		return nil
+	}
+
 	filebeatContainer := docker.Container{
 		Name: filebeatContainerName,
 	}
@@ -288,14 +1226,34 @@ func (d DockerLifecycleHandler) Stop(currentNode node.Node) error {
 }
 
 // RemoveData removes any data (typically the blockchain itself) related to the node
-func (d DockerLifecycleHandler) RemoveData(currentNode node.Node) error {
-	client, err := docker.NewBasicManager(currentNode)
+//
+// It refuses to remove anything while any of the node containers are still running.
+func (d DockerLifecycleHandler) RemoveData(ctx context.Context, currentNode node.Node) error {
+	client, err := d.basicManager(ctx, currentNode)
 	if err != nil {
 		return err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
-	defer cancel()
+	// Removing data from under a running container would leave it writing into a deleted directory
+	runningContainers := []string{}
+	for _, container := range d.containers {
+		running, err := client.IsContainerRunning(ctx, container.Name)
+		if err != nil {
+			return err
+		}
+		if running {
+			runningContainers = append(runningContainers, container.Name)
+		}
+	}
+	if len(runningContainers) > 0 {
+		return fmt.Errorf("cannot remove data while containers are running: %s. Please stop the node first", strings.Join(runningContainers, ", "))
+	}
+
+	// Filebeat runs as root so the files in its data directory are owned by root
+	if err := client.RootOwnedDirectoryAbsent(ctx, client.AddBasePath(FilebeatDataDirectory)); err != nil {
+		return err
+	}
+
 	// Remove volumes
 	for _, container := range d.containers {
 		for _, mount := range container.Mounts {
@@ -307,28 +1265,48 @@ func (d DockerLifecycleHandler) RemoveData(currentNode node.Node) error {
 		}
 	}
 
-	dataDir := client.AddBasePath(currentNode.StrParameters["data-dir"])
+	dataDir := currentNode.DataDirectory()
+
+	// A data-dir like "." or "/" would remove much more than the data
+	if rel, err := filepath.Rel(dataDir, currentNode.NodeDirectory()); err != nil || !(rel == ".." || strings.HasPrefix(rel, "../")) {
+		return fmt.Errorf("refusing to remove data directory %q because it contains the node directory", dataDir)
+	}
+
 	fmt.Printf("Removing directory %q\n", dataDir)
 
 	return os.RemoveAll(dataDir)
 }
 
 // RemoveRuntime removes the docker network and containers
-func (d DockerLifecycleHandler) RemoveRuntime(currentNode node.Node) error {
-	client, err := docker.NewBasicManager(currentNode)
+func (d DockerLifecycleHandler) RemoveRuntime(ctx context.Context, currentNode node.Node) error {
+	client, err := d.basicManager(ctx, currentNode)
 	if err != nil {
 		return err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Minute)
-	defer cancel()
-
 	for _, container := range d.containers {
 		if err = client.ContainerAbsent(ctx, container); err != nil {
 			return err
 		}
 	}
 
+	if err := secrets.PlaintextsAbsent(currentNode); err != nil {
+		return err
+	}
+
+	// Also if collect-metrics is off, it might have been on when the agent was started
+	if err = client.ContainerAbsent(ctx, docker.Container{Name: metricsAgentContainerName}); err != nil {
+		return err
+	}
+
+	if err := fileAbsent(client.AddBasePath(path.Join("monitoring", metricsTargetsFile))); err != nil {
+		return err
+	}
+
+	if d.DisableFilebeat {
+		return nil
+	}
+
 	filebeatContainer := docker.Container{
 		Name: filebeatContainerName,
 	}
@@ -0,0 +1,273 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.blockdaemon.com/bpm/sdk/pkg/docker"
+	"go.blockdaemon.com/bpm/sdk/pkg/node"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// filebeatConfig is the part of the rendered filebeat config checked by the tests
+type filebeatConfig struct {
+	Inputs []struct {
+		Type  string   `yaml:"type"`
+		Paths []string `yaml:"paths"`
+	} `yaml:"filebeat.inputs"`
+	Fields struct {
+		Node map[string]string `yaml:"node"`
+	} `yaml:"fields"`
+	Processors []map[string]interface{} `yaml:"processors"`
+}
+
+func testNode(t *testing.T) (node.Node, func()) {
+	dir, err := ioutil.TempDir("", "docker-lifecycle")
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "monitoring"), 0755))
+
+	currentNode := node.NewWithID(filepath.Join(dir, "node.json"), "bmvd5i3e2bp5bhubhmpg")
+	currentNode.PluginName = "test"
+	currentNode.StrParameters = map[string]string{}
+	currentNode.BoolParameters = map[string]bool{}
+
+	return currentNode, func() { os.RemoveAll(dir) }
+}
+
+func renderFilebeatConfig(t *testing.T, handler DockerLifecycleHandler, currentNode node.Node, containerLogPaths ...string) filebeatConfig {
+	require.NoError(t, handler.renderMonitoringConfig(context.Background(), filepath.Join(currentNode.NodeDirectory(), "monitoring"), currentNode, containerLogPaths))
+
+	content, err := ioutil.ReadFile(filepath.Join(currentNode.NodeDirectory(), "monitoring", filebeatConfigFile))
+	require.NoError(t, err)
+
+	var config filebeatConfig
+	require.NoError(t, yaml.Unmarshal(content, &config))
+
+	return config
+}
+
+func TestRenderMonitoringConfigDefaults(t *testing.T) {
+	currentNode, cleanup := testNode(t)
+	defer cleanup()
+
+	handler := NewDockerLifecycleHandler([]docker.Container{{Name: "node", CollectLogs: true}})
+	config := renderFilebeatConfig(t, handler, currentNode, "/var/lib/docker/containers/0123/*.log")
+
+	assert.Equal(t, map[string]string{
+		"project":       defaultMonitoringProject,
+		"protocol_type": "TEST",
+		"user_id":       defaultMonitoringUserID,
+		"xid":           currentNode.ID,
+	}, config.Fields.Node)
+
+	// Without docker metadata the container logs are still collected, only those of the node containers
+	require.Len(t, config.Inputs, 1)
+	assert.Equal(t, "container", config.Inputs[0].Type)
+	assert.Equal(t, []string{"/var/lib/docker/containers/0123/*.log"}, config.Inputs[0].Paths)
+	assert.NotContains(t, config.Processors, map[string]interface{}{"add_docker_metadata": nil})
+}
+
+func TestRenderMonitoringConfigQuotesFields(t *testing.T) {
+	currentNode, cleanup := testNode(t)
+	defer cleanup()
+
+	currentNode.StrParameters["monitoring-project"] = `my "project": #1`
+	currentNode.StrParameters["monitoring-user-id"] = "- 42"
+
+	config := renderFilebeatConfig(t, NewDockerLifecycleHandler(nil), currentNode)
+
+	assert.Equal(t, `my "project": #1`, config.Fields.Node["project"])
+	assert.Equal(t, "- 42", config.Fields.Node["user_id"])
+}
+
+func TestRenderMonitoringConfigDockerMetadata(t *testing.T) {
+	currentNode, cleanup := testNode(t)
+	defer cleanup()
+
+	currentNode.BoolParameters[FilebeatDockerMetadataParameter] = true
+
+	handler := NewDockerLifecycleHandler([]docker.Container{
+		{Name: "node", CollectLogs: true},
+		{Name: "sidecar"},
+	})
+	config := renderFilebeatConfig(t, handler, currentNode, "/var/lib/docker/containers/0123/*.log")
+
+	require.Len(t, config.Inputs, 1)
+	assert.Equal(t, "container", config.Inputs[0].Type)
+	assert.Equal(t, []string{"/var/lib/docker/containers/0123/*.log"}, config.Inputs[0].Paths)
+	assert.Contains(t, config.Processors, map[string]interface{}{"add_docker_metadata": nil})
+}
+
+func TestRenderMonitoringConfigLogFiles(t *testing.T) {
+	currentNode, cleanup := testNode(t)
+	defer cleanup()
+
+	handler := NewDockerLifecycleHandler(nil, WithFilebeatLogFiles("logs/*.log"))
+	config := renderFilebeatConfig(t, handler, currentNode)
+
+	require.Len(t, config.Inputs, 1)
+	assert.Equal(t, "log", config.Inputs[0].Type)
+	assert.Equal(t, []string{filepath.Join(currentNode.NodeDirectory(), "logs", "*.log")}, config.Inputs[0].Paths)
+	assert.NotContains(t, config.Processors, map[string]interface{}{"add_docker_metadata": nil})
+}
+
+func TestContainerLogPaths(t *testing.T) {
+	currentNode, cleanup := testNode(t)
+	defer cleanup()
+
+	fake := newFakeDocker(t)
+	defer fake.close()
+	fake.use(currentNode)
+
+	fake.addContainer(currentNode, "client", "sha256:client", true)
+	fake.addContainer(currentNode, "sidecar", "sha256:sidecar", true)
+
+	handler := NewDockerLifecycleHandler([]docker.Container{
+		{Name: "client", CollectLogs: true},
+		{Name: "sidecar"},
+		{Name: "validator", CollectLogs: true}, // doesn't exist yet
+	})
+
+	client, err := docker.NewBasicManagerWithContext(context.Background(), currentNode)
+	require.NoError(t, err)
+
+	paths, err := handler.containerLogPaths(context.Background(), client)
+	require.NoError(t, err)
+
+	// The fake daemon uses the container names as IDs
+	assert.Equal(t, []string{"/var/lib/docker/containers/" + currentNode.NamePrefix() + "client/*.log"}, paths)
+}
+
+func TestFilebeatContainerMounts(t *testing.T) {
+	currentNode, cleanup := testNode(t)
+	defer cleanup()
+
+	fake := newFakeDocker(t)
+	defer fake.close()
+	fake.use(currentNode)
+
+	client, err := docker.NewBasicManagerWithContext(context.Background(), currentNode)
+	require.NoError(t, err)
+
+	handler := NewDockerLifecycleHandler(nil)
+
+	mountSources := func() []string {
+		sources := []string{}
+		for _, mount := range handler.filebeatContainer(client, currentNode).Mounts {
+			sources = append(sources, mount.From)
+		}
+		return sources
+	}
+
+	assert.Contains(t, mountSources(), "/var/lib/docker/containers")
+	assert.NotContains(t, mountSources(), "/var/run/docker.sock")
+
+	currentNode.BoolParameters[FilebeatDockerMetadataParameter] = true
+	assert.Contains(t, mountSources(), "/var/lib/docker/containers")
+	assert.Contains(t, mountSources(), "/var/run/docker.sock")
+}
+
+func TestFilebeatEnabled(t *testing.T) {
+	assert.True(t, NewDockerLifecycleHandler([]docker.Container{{Name: "node", CollectLogs: true}}).filebeatEnabled())
+	assert.True(t, NewDockerLifecycleHandler(nil, WithFilebeatLogFiles("logs/*.log")).filebeatEnabled())
+	assert.False(t, NewDockerLifecycleHandler([]docker.Container{{Name: "node"}}).filebeatEnabled(), "nothing to collect")
+	assert.False(t, NewDockerLifecycleHandler([]docker.Container{{Name: "node", CollectLogs: true}}, WithFilebeatDisabled()).filebeatEnabled())
+}
+
+func TestRemoveDataRefusesWhileContainersRun(t *testing.T) {
+	testCases := map[string]struct {
+		running     map[string]bool
+		expectedErr string
+	}{
+		"running": {
+			running:     map[string]bool{"client": true, "validator": true},
+			expectedErr: "cannot remove data while containers are running: client, validator",
+		},
+		"partially running": {
+			running:     map[string]bool{"client": false, "validator": true},
+			expectedErr: "cannot remove data while containers are running: validator",
+		},
+		"stopped": {
+			running: map[string]bool{"client": false, "validator": false},
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			currentNode, cleanup := testNode(t)
+			defer cleanup()
+
+			fake := newFakeDocker(t)
+			defer fake.close()
+			fake.use(currentNode)
+
+			fake.addImage("sha256:client", "example.com/client:1")
+			fake.addImage("sha256:validator", "example.com/validator:1")
+			fake.addContainer(currentNode, "client", "sha256:client", testCase.running["client"])
+			fake.addContainer(currentNode, "validator", "sha256:validator", testCase.running["validator"])
+
+			dataDir := currentNode.DataDirectory()
+			require.NoError(t, os.MkdirAll(dataDir, 0755))
+
+			handler := NewDockerLifecycleHandler([]docker.Container{
+				{Name: "client", Image: "example.com/client:1"},
+				{Name: "validator", Image: "example.com/validator:1"},
+			})
+			err := handler.RemoveData(context.Background(), currentNode)
+
+			_, statErr := os.Stat(dataDir)
+			if testCase.expectedErr != "" {
+				assert.EqualError(t, err, testCase.expectedErr+". Please stop the node first")
+				assert.NoError(t, statErr, "the data directory must not be removed")
+			} else {
+				assert.NoError(t, err)
+				assert.True(t, os.IsNotExist(statErr), "the data directory should be removed")
+			}
+		})
+	}
+}
+
+func TestRemoveDataGuardsNodeDirectory(t *testing.T) {
+	testCases := map[string]bool{
+		"":  false, // falls back to the default data directory
+		".": true,
+		"/": true,
+	}
+
+	for dataDir, refused := range testCases {
+		t.Run(fmt.Sprintf("%q", dataDir), func(t *testing.T) {
+			currentNode, cleanup := testNode(t)
+			defer cleanup()
+
+			fake := newFakeDocker(t)
+			defer fake.close()
+			fake.use(currentNode)
+
+			currentNode.StrParameters["data-dir"] = dataDir
+			nodeFile := filepath.Join(currentNode.NodeDirectory(), "node.json")
+			require.NoError(t, ioutil.WriteFile(nodeFile, []byte("{}"), 0644))
+			require.NoError(t, os.MkdirAll(filepath.Join(currentNode.NodeDirectory(), node.DefaultDataDirectoryName), 0755))
+
+			err := NewDockerLifecycleHandler(nil).RemoveData(context.Background(), currentNode)
+
+			if refused {
+				assert.EqualError(t, err, fmt.Sprintf("refusing to remove data directory %q because it contains the node directory", currentNode.DataDirectory()))
+			} else {
+				assert.NoError(t, err)
+
+				_, statErr := os.Stat(currentNode.DataDirectory())
+				assert.True(t, os.IsNotExist(statErr), "the data directory should be removed")
+			}
+
+			// The node directory survives in any case
+			_, err = os.Stat(nodeFile)
+			assert.NoError(t, err)
+		})
+	}
+}
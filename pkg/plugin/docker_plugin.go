@@ -1,7 +1,11 @@
 package plugin
 
 import (
+	"context"
+
 	"go.blockdaemon.com/bpm/sdk/pkg/docker"
+	"go.blockdaemon.com/bpm/sdk/pkg/node"
+	"go.blockdaemon.com/bpm/sdk/pkg/secrets"
 )
 
 // DockerPlugin is an implementation of the Plugin interface. It provides based functionality for a docker based plugin
@@ -12,6 +16,28 @@ type DockerPlugin struct {
 	LifecycleHandler
 	Upgrader
 	Tester
+	LogProvider
+	LogRotator
+	ConfigDiffer
+	ImagePuller
+	BackupProvider
+	Pauser
+	DriftDetector
+	Restarter
+	StatsReporter
+	EnvironmentValidator
+	SBOMGenerator
+	HealthChecker
+	CrashReporter
+
+	// The networks, protocols, etc. this plugin supports. Nodes using other values fail validation.
+	SupportedParameters Parameters
+
+	// The minimum BPM version required to run this plugin, empty if any version works
+	MinBPMVersion string
+
+	// The free disk space in bytes the node data needs, checked before starting. 0 disables the check
+	MinFreeDiskSpace uint64
 
 	// Plugin meta information
 	meta MetaInfo
@@ -22,6 +48,22 @@ func (d DockerPlugin) Name() string {
 	return d.meta.Name
 }
 
+// Upgrade upgrades the node using the Upgrader
+//
+// Upgrades usually recreate the node containers, also when rolling back a failed upgrade. Afterwards the log
+// collection is updated, see DockerLifecycleHandler.UpdateLogCollection.
+func (d DockerPlugin) Upgrade(ctx context.Context, currentNode node.Node) error {
+	upgradeErr := d.Upgrader.Upgrade(ctx, currentNode)
+
+	if lifecycleHandler, ok := d.LifecycleHandler.(DockerLifecycleHandler); ok {
+		if err := lifecycleHandler.UpdateLogCollection(ctx, currentNode); err != nil && upgradeErr == nil {
+			return err
+		}
+	}
+
+	return upgradeErr
+}
+
 // Meta returns the MetaInfo of a plugin
 func (d DockerPlugin) Meta() MetaInfo {
 	// Determine optional functions available on the fly
@@ -39,27 +81,84 @@ func (d DockerPlugin) Meta() MetaInfo {
 		supported = append(supported, SupportsIdentity)
 	}
 
+	if d.LogProvider != nil {
+		supported = append(supported, SupportsLogs)
+	}
+
+	if d.LogRotator != nil {
+		supported = append(supported, SupportsLogRotation)
+	}
+
+	if d.ConfigDiffer != nil {
+		supported = append(supported, SupportsConfigDiff)
+	}
+
+	if d.ImagePuller != nil {
+		supported = append(supported, SupportsPullImages)
+	}
+
+	if d.BackupProvider != nil {
+		supported = append(supported, SupportsBackup)
+	}
+
+	if d.Pauser != nil {
+		supported = append(supported, SupportsPause)
+	}
+
+	if d.DriftDetector != nil {
+		supported = append(supported, SupportsDrift)
+	}
+
+	if d.Restarter != nil {
+		supported = append(supported, SupportsRestart)
+	}
+
+	if d.StatsReporter != nil {
+		supported = append(supported, SupportsStats)
+	}
+
+	if d.EnvironmentValidator != nil {
+		supported = append(supported, SupportsCheck)
+	}
+
+	if d.SBOMGenerator != nil {
+		supported = append(supported, SupportsSBOM)
+	}
+
+	if d.HealthChecker != nil {
+		supported = append(supported, SupportsHealth)
+	}
+
+	if d.CrashReporter != nil {
+		supported = append(supported, SupportsCrashReport)
+	}
+
 	d.meta.Supported = supported
+	d.meta.SupportedParameters = d.SupportedParameters
+	d.meta.MinBPMVersion = d.MinBPMVersion
+	d.meta.MinFreeDiskSpace = d.MinFreeDiskSpace
 
 	return d.meta
 }
 
 // NewDockerPlugin creates a new instance of DockerPlugin
-func NewDockerPlugin(name string, version string, description string, parameters []Parameter, templates map[string]string, containers []docker.Container) DockerPlugin {
+//
+// The options configure the DockerLifecycleHandler, which is shared by all capabilities it implements.
+func NewDockerPlugin(name string, version string, description string, parameters []Parameter, templates map[string]string, containers []docker.Container, options ...DockerLifecycleHandlerOption) DockerPlugin {
 	dockerParameters := []Parameter{
 		{
 			Name:        "docker-network",
 			Type:        ParameterTypeString,
 			Description: "If set, the node will be spun up in this docker network. The network will be created automatically if it doesn't exist",
 			Mandatory:   false,
-			Default:     "bpm",
+			Default:     node.DefaultDockerNetworkName,
 		},
 		{
 			Name:        "data-dir",
 			Type:        ParameterTypeString,
 			Description: "The directory under which the nodes data will be saved. Values that do not start with '/' will be relative to the node directory",
 			Mandatory:   false,
-			Default:     "data",
+			Default:     node.DefaultDataDirectoryName,
 		},
 		{
 			Name:        "monitoring-pack",
@@ -68,6 +167,76 @@ func NewDockerPlugin(name string, version string, description string, parameters
 			Mandatory:   false,
 			Default:     "",
 		},
+		{
+			Name:        "monitoring-project",
+			Type:        ParameterTypeString,
+			Description: "The project name added to all monitoring data",
+			Mandatory:   false,
+			Default:     "development",
+		},
+		{
+			Name:        "monitoring-user-id",
+			Type:        ParameterTypeString,
+			Description: "The user ID added to all monitoring data",
+			Mandatory:   false,
+			Default:     "bpm",
+		},
+		{
+			Name:        "docker-host",
+			Type:        ParameterTypeString,
+			Description: "The docker daemon to connect to, e.g. tcp://10.0.0.1:2376. Defaults to DOCKER_HOST or the local docker daemon",
+			Mandatory:   false,
+			Default:     "",
+		},
+		{
+			Name:        "docker-cert-path",
+			Type:        ParameterTypeString,
+			Description: "Directory containing ca.pem, cert.pem and key.pem to connect to the docker daemon using TLS",
+			Mandatory:   false,
+			Default:     "",
+		},
+		{
+			Name:        "collect-metrics",
+			Type:        ParameterTypeBool,
+			Description: "Runs a prometheus agent that collects the node metrics and forwards them using settings from the monitoring pack",
+			Mandatory:   false,
+			Default:     "false",
+		},
+		{
+			Name:        docker.LogMaxSizeParameter,
+			Type:        ParameterTypeString,
+			Description: "Maximum size of a container log file before docker rotates it, e.g. 100m. Applies to containers that don't set their own log rotation",
+			Mandatory:   false,
+			Default:     "10m",
+		},
+		{
+			Name:        docker.LogMaxFilesParameter,
+			Type:        ParameterTypeString,
+			Description: "Maximum number of log files docker keeps per container. Applies to containers that don't set their own log rotation",
+			Mandatory:   false,
+			Default:     "3",
+		},
+		{
+			Name:        FilebeatDockerMetadataParameter,
+			Type:        ParameterTypeBool,
+			Description: "Mounts the docker socket into filebeat to add container metadata to logs. This gives filebeat root access to the host",
+			Mandatory:   false,
+			Default:     "false",
+		},
+		{
+			Name:        secrets.KeyFileParameter,
+			Type:        ParameterTypeString,
+			Description: "File containing the passphrase for encrypted secrets. Values that do not start with '/' will be relative to the node directory",
+			Mandatory:   false,
+			Default:     "",
+		},
+		{
+			Name:        "vulnerability-scanner",
+			Type:        ParameterTypeString,
+			Description: "If set, container images are checked for vulnerabilities using this Trivy compatible scanner endpoint",
+			Mandatory:   false,
+			Default:     "",
+		},
 	}
 
 	meta := MetaInfo{
@@ -78,14 +247,40 @@ func NewDockerPlugin(name string, version string, description string, parameters
 		Parameters:      append(dockerParameters, parameters...),
 		Supported:       []string{}, // We'll determine the supported functions on the fly in DockerPlugin.Meta()
 	}
+	meta.parametersByName = newParameterIndex(meta.Parameters)
+
+	configurator := NewFileConfigurator(templates)
+	lifecycleHandler := NewDockerLifecycleHandler(containers, options...)
+
+	var upgrader Upgrader = NewDockerUpgrader(containers)
+	if lifecycleHandler.SafeUpgrades {
+		safeUpgrader := NewSafeDockerUpgrader(containers)
+		if lifecycleHandler.SafeUpgradeStablePeriod != 0 {
+			safeUpgrader.ReadyStablePeriod = lifecycleHandler.SafeUpgradeStablePeriod
+		}
+		upgrader = safeUpgrader
+	}
 
 	return DockerPlugin{
-		meta:               meta,
-		ParameterValidator: NewSimpleParameterValidator(meta.Parameters),
-		IdentityCreator:    nil,
-		Configurator:       NewFileConfigurator(templates),
-		LifecycleHandler:   NewDockerLifecycleHandler(containers),
-		Upgrader:           NewDockerUpgrader(containers),
-		Tester:             nil,
+		meta:                 meta,
+		ParameterValidator:   NewSimpleParameterValidator(meta.Parameters),
+		IdentityCreator:      nil,
+		Configurator:         configurator,
+		LifecycleHandler:     lifecycleHandler,
+		Upgrader:             upgrader,
+		Tester:               nil,
+		LogProvider:          lifecycleHandler,
+		LogRotator:           lifecycleHandler,
+		ConfigDiffer:         configurator,
+		ImagePuller:          lifecycleHandler,
+		BackupProvider:       NewDockerBackupProvider(containers),
+		Pauser:               lifecycleHandler,
+		DriftDetector:        lifecycleHandler,
+		Restarter:            lifecycleHandler,
+		StatsReporter:        lifecycleHandler,
+		EnvironmentValidator: NewDockerEnvironmentValidator(containers),
+		SBOMGenerator:        lifecycleHandler,
+		HealthChecker:        NewDockerHealthChecker(containers),
+		CrashReporter:        lifecycleHandler,
 	}
 }
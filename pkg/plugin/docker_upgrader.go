@@ -4,36 +4,141 @@ package plugin
 
 import (
 	"context"
-	"time"
+	"fmt"
 
+	"github.com/coreos/go-semver/semver"
 	"go.blockdaemon.com/bpm/sdk/pkg/docker"
 	"go.blockdaemon.com/bpm/sdk/pkg/node"
 )
 
+// Migration describes a migration step from one plugin version to another
+type Migration struct {
+	// The version a node needs to be on for this migration to be applicable
+	From string
+	// The version a node is on after this migration ran successfully
+	To string
+	// Function that does the actual migration, e.g. rewriting configs or moving data
+	Migrate func(ctx context.Context, currentNode node.Node) error
+}
+
 // DockerUpgrader provides a default strategy for upgrading docker based nodes
 //
 // The default upgrade strategy uses a LifecycleHandler to remove all containers. If they where running they get started again which will pull new container images.
 //
-// This works as long as only the container versions change. If the the upgrade needs changes to the configs or migrations tasks it is
-// recommended to provide a custom Upgrader.
+// This works as long as only the container versions change. If the upgrade needs changes to the configs or data,
+// migrations can be added with WithMigrations. For anything else it is recommended to provide a custom Upgrader.
 type DockerUpgrader struct {
 	containers []docker.Container
+
+	// The plugin version the node gets upgraded to, empty if the upgrader doesn't track versions
+	version    string
+	migrations []Migration
+}
+
+// DockerUpgraderOption is a functional option to configure a DockerUpgrader
+type DockerUpgraderOption func(*DockerUpgrader)
+
+// WithMigrations runs migrations before upgrading the containers and sets the node version to version afterwards
+//
+// The migrations are run in the order they are supplied. A migration is applicable if the version the node is on
+// at that point is at least its From version but less than its To version, and its To version is not greater than
+// the target version.
+// After each successful migration the node version is saved, this way a failed upgrade can just be run again
+// without repeating migrations that already succeeded.
+func WithMigrations(version string, migrations []Migration) DockerUpgraderOption {
+	return func(d *DockerUpgrader) {
+		d.version = version
+		d.migrations = migrations
+	}
 }
 
 // NewDockerUpgrader instantiates DockerUpgrader
-func NewDockerUpgrader(containers []docker.Container) DockerUpgrader {
-	return DockerUpgrader{containers: containers}
+func NewDockerUpgrader(containers []docker.Container, options ...DockerUpgraderOption) DockerUpgrader {
+	upgrader := DockerUpgrader{containers: containers}
+
+	for _, option := range options {
+		option(&upgrader)
+	}
+
+	return upgrader
 }
 
-// Upgrade upgrades all containers by removing and starting them again
-func (d DockerUpgrader) Upgrade(currentNode node.Node) error {
-	client, err := docker.NewBasicManager(currentNode)
-	if err != nil {
+// Upgrade runs all applicable migrations and then upgrades all containers by removing and starting them again
+func (d DockerUpgrader) Upgrade(ctx context.Context, currentNode node.Node) error {
+	if d.version != "" {
+		targetVersion, err := semver.NewVersion(d.version)
+		if err != nil {
+			return fmt.Errorf("invalid target version %q: %s", d.version, err)
+		}
+
+		if currentNode.Version == "" {
+			fmt.Println("Node has no installed version, skipping migrations")
+		} else {
+			if err := d.migrate(ctx, &currentNode, targetVersion); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := d.upgradeContainers(ctx, currentNode); err != nil {
 		return err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
-	defer cancel()
+	if d.version == "" {
+		return nil
+	}
+
+	currentNode.Version = d.version
+
+	return currentNode.Save()
+}
+
+func (d DockerUpgrader) migrate(ctx context.Context, currentNode *node.Node, targetVersion *semver.Version) error {
+	for _, migration := range d.migrations {
+		toVersion, err := semver.NewVersion(migration.To)
+		if err != nil {
+			return fmt.Errorf("invalid migration version %q: %s", migration.To, err)
+		}
+
+		if targetVersion.LessThan(*toVersion) {
+			continue
+		}
+
+		atLeastFrom, err := currentNode.VersionAtLeast(migration.From)
+		if err != nil {
+			return err
+		}
+
+		lessThanTo, err := currentNode.VersionLessThan(migration.To)
+		if err != nil {
+			return err
+		}
+
+		if !atLeastFrom || !lessThanTo {
+			continue
+		}
+
+		fmt.Printf("Migrating node from version %s to %s\n", currentNode.Version, migration.To)
+
+		if err := migration.Migrate(ctx, *currentNode); err != nil {
+			return fmt.Errorf("migration from version %s to %s failed: %s", migration.From, migration.To, err)
+		}
+
+		// Save after each migration so it doesn't run again if a later step fails
+		currentNode.Version = migration.To
+		if err := currentNode.Save(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d DockerUpgrader) upgradeContainers(ctx context.Context, currentNode node.Node) error {
+	client, err := docker.NewBasicManagerWithContext(ctx, currentNode)
+	if err != nil {
+		return err
+	}
 
 	// Which containers are currently running?
 	runningContainers := []docker.Container{}
@@ -0,0 +1,237 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	dockercontainer "github.com/docker/docker/api/types/container"
+	"go.blockdaemon.com/bpm/sdk/pkg/node"
+)
+
+// fakeContainer is a container of the fake docker daemon
+type fakeContainer struct {
+	image    string // image ID
+	running  bool
+	exitCode int
+}
+
+// fakeDocker is a docker daemon that keeps containers and images in memory
+//
+// It supports just enough of the API to create, start, stop, remove and inspect containers and to pull and inspect
+// images. Containers start healthy unless their image is in crashingImages, then they exit right away.
+type fakeDocker struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mutex sync.Mutex
+	// Image IDs by reference. IDs reference themselves.
+	images map[string]string
+	// Image IDs a reference points to after pulling it
+	pulls map[string]string
+	// Image IDs whose containers exit right after starting
+	crashingImages map[string]bool
+	// Containers by full name, including the node prefix
+	containers map[string]*fakeContainer
+	// Called with the full name and image ID whenever a container was started, e.g. to interrupt an upgrade
+	started func(name, image string)
+}
+
+var fakeDockerAPIVersion = regexp.MustCompile(`^/v[0-9.]+`)
+
+func newFakeDocker(t *testing.T) *fakeDocker {
+	f := &fakeDocker{
+		t:              t,
+		images:         map[string]string{},
+		pulls:          map[string]string{},
+		crashingImages: map[string]bool{},
+		containers:     map[string]*fakeContainer{},
+	}
+	f.server = httptest.NewServer(http.HandlerFunc(f.handle))
+
+	return f
+}
+
+// use points the node to the fake docker daemon
+func (f *fakeDocker) use(currentNode node.Node) {
+	currentNode.StrParameters["docker-host"] = "tcp://" + f.server.Listener.Addr().String()
+}
+
+func (f *fakeDocker) close() {
+	f.server.Close()
+}
+
+// addImage makes an image available locally under its ID and the references
+func (f *fakeDocker) addImage(id string, refs ...string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.images[id] = id
+	for _, ref := range refs {
+		f.images[ref] = id
+	}
+}
+
+// addContainer creates a container of the node running or stopped with the image ID
+func (f *fakeDocker) addContainer(currentNode node.Node, name, image string, running bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.containers[currentNode.NamePrefix()+name] = &fakeContainer{image: image, running: running}
+}
+
+// container returns a copy of a node container and whether it exists
+func (f *fakeDocker) container(currentNode node.Node, name string) (fakeContainer, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	container, ok := f.containers[currentNode.NamePrefix()+name]
+	if !ok {
+		return fakeContainer{}, false
+	}
+
+	return *container, true
+}
+
+func (f *fakeDocker) handle(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	path := fakeDockerAPIVersion.ReplaceAllString(r.URL.Path, "")
+
+	switch {
+	case path == "/_ping":
+		w.Write([]byte("OK"))
+
+	case r.Method == http.MethodPost && path == "/containers/create":
+		var config dockercontainer.Config
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		image, ok := f.images[config.Image]
+		if !ok {
+			f.notFound(w, "No such image: "+config.Image)
+			return
+		}
+
+		name := r.URL.Query().Get("name")
+		f.containers[name] = &fakeContainer{image: image}
+		w.WriteHeader(http.StatusCreated)
+		f.writeJSON(w, dockercontainer.ContainerCreateCreatedBody{ID: name})
+
+	case strings.HasPrefix(path, "/containers/"):
+		f.handleContainer(w, r, strings.TrimPrefix(path, "/containers/"))
+
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/images/") && strings.HasSuffix(path, "/json"):
+		ref := strings.TrimSuffix(strings.TrimPrefix(path, "/images/"), "/json")
+
+		id, ok := f.images[ref]
+		if !ok {
+			f.notFound(w, "No such image: "+ref)
+			return
+		}
+
+		f.writeJSON(w, types.ImageInspect{ID: id})
+
+	case r.Method == http.MethodPost && path == "/images/create":
+		ref := r.URL.Query().Get("fromImage") + ":" + r.URL.Query().Get("tag")
+
+		id, ok := f.pulls[ref]
+		if !ok {
+			f.writeJSON(w, map[string]string{"error": "manifest unknown: " + ref})
+			return
+		}
+
+		f.images[ref] = id
+		f.images[id] = id
+		f.writeJSON(w, map[string]string{"status": "Downloaded newer image for " + ref})
+
+	default:
+		f.unexpected(w, r)
+	}
+}
+
+func (f *fakeDocker) handleContainer(w http.ResponseWriter, r *http.Request, path string) {
+	parts := strings.SplitN(path, "/", 2)
+	name := parts[0]
+	action := ""
+	if len(parts) == 2 {
+		action = parts[1]
+	}
+
+	container, ok := f.containers[name]
+	if !ok {
+		f.notFound(w, "No such container: "+name)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && action == "json":
+		state := &types.ContainerState{
+			Running:  container.running,
+			ExitCode: container.exitCode,
+			Status:   "exited",
+		}
+		if container.running {
+			state.Status = "running"
+			state.Health = &types.Health{Status: types.Healthy}
+		}
+
+		f.writeJSON(w, types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{
+				ID:    name,
+				Name:  "/" + name,
+				Image: container.image,
+				State: state,
+			},
+			Config: &dockercontainer.Config{},
+		})
+
+	case r.Method == http.MethodPost && action == "start":
+		if f.crashingImages[container.image] {
+			container.running = false
+			container.exitCode = 1
+		} else {
+			container.running = true
+			container.exitCode = 0
+		}
+		if f.started != nil {
+			f.started(name, container.image)
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodPost && action == "stop":
+		container.running = false
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodDelete && action == "":
+		delete(f.containers, name)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		f.unexpected(w, r)
+	}
+}
+
+func (f *fakeDocker) writeJSON(w http.ResponseWriter, value interface{}) {
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		f.t.Errorf("cannot encode response: %s", err)
+	}
+}
+
+func (f *fakeDocker) notFound(w http.ResponseWriter, message string) {
+	w.WriteHeader(http.StatusNotFound)
+	f.writeJSON(w, map[string]string{"message": message})
+}
+
+func (f *fakeDocker) unexpected(w http.ResponseWriter, r *http.Request) {
+	f.t.Errorf("unexpected docker API request: %s %s", r.Method, r.URL.Path)
+	http.Error(w, "not implemented by the fake docker daemon", http.StatusNotImplemented)
+}
@@ -3,41 +3,135 @@
 package plugin
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 
 	"go.blockdaemon.com/bpm/sdk/pkg/fileutil"
 	"go.blockdaemon.com/bpm/sdk/pkg/node"
+	"go.blockdaemon.com/bpm/sdk/pkg/progress"
 	"go.blockdaemon.com/bpm/sdk/pkg/template"
 )
 
 const (
 	// ConfigsDirectory is the subdirectory under the node directory where configs are saved
-	ConfigsDirectory = "configs"
+	ConfigsDirectory = node.ConfigsDirectoryName
 )
 
 // FileConfigurator creates configuration files from templates
 type FileConfigurator struct {
 	configFilesAndTemplates map[string]string
+
+	// EnsureDurable flushes the configuration files to disk after rendering them so a crash doesn't leave incomplete files
+	EnsureDurable bool
 }
 
 // Configure creates configuration files for the blockchain client
-func (d FileConfigurator) Configure(currentNode node.Node) error {
+func (d FileConfigurator) Configure(ctx context.Context, currentNode node.Node) error {
 	// Create config directory if it doesn't exist yet
-	_, err := fileutil.MakeDirectory(currentNode.NodeDirectory(), ConfigsDirectory)
+	_, err := fileutil.MakeDirectory(currentNode.ConfigsDirectory())
 	if err != nil {
 		return err
 	}
 
-	return template.ConfigFilesRendered(d.configFilesAndTemplates, template.TemplateData{
+	// Existing files are not rendered again, only report the new ones
+	newFiles := []string{}
+	for filename := range d.configFilesAndTemplates {
+		filePath := filepath.Join(currentNode.NodeDirectory(), filename)
+
+		exists, err := fileutil.FileExists(filePath)
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			newFiles = append(newFiles, filePath)
+		}
+	}
+
+	err = template.ConfigFilesRendered(d.configFilesAndTemplates, template.TemplateData{
 		Node: currentNode,
 	})
+	if err != nil {
+		return err
+	}
+
+	for _, filePath := range newFiles {
+		progress.Emit(ctx, nil, progress.StageRender, filePath, "rendered config", 100)
+	}
+
+	if !d.EnsureDurable {
+		return nil
+	}
+
+	return d.sync(currentNode)
+}
+
+// sync flushes all directories containing configuration files to disk
+func (d FileConfigurator) sync(currentNode node.Node) error {
+	dirs := map[string]bool{}
+	for filename := range d.configFilesAndTemplates {
+		dirs[filepath.Dir(filepath.Join(currentNode.NodeDirectory(), filename))] = true
+	}
+
+	for dir := range dirs {
+		if err := fileutil.SyncDirectory(dir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ConfigDiff renders all templates and returns the differences to the files on disk in unified diff format
+//
+// Missing and binary files are reported but not diffed. The result is empty if nothing changed.
+func (d FileConfigurator) ConfigDiff(ctx context.Context, currentNode node.Node) (string, error) {
+	filenames := make([]string, 0, len(d.configFilesAndTemplates))
+	for filename := range d.configFilesAndTemplates {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+
+	output := ""
+	for _, filename := range filenames {
+		rendered, err := template.RenderString(d.configFilesAndTemplates[filename], template.TemplateData{
+			Node: currentNode,
+		})
+		if err != nil {
+			return "", fmt.Errorf("cannot render %q: %s", filename, err)
+		}
+
+		existing, err := ioutil.ReadFile(filepath.Join(currentNode.NodeDirectory(), filename))
+		if err != nil {
+			if os.IsNotExist(err) {
+				output += fmt.Sprintf("Only in templates: %s\n", filename)
+				continue
+			}
+
+			return "", err
+		}
+
+		if bytes.IndexByte(existing, 0) != -1 {
+			if string(existing) != rendered {
+				output += fmt.Sprintf("Binary file %s differs\n", filename)
+			}
+			continue
+		}
+
+		output += unifiedDiff(filename+" (on disk)", filename+" (rendered)", string(existing), rendered)
+	}
+
+	return output, nil
 }
 
 // RemoveConfig removes configuration files related to the node
-func (d FileConfigurator) RemoveConfig(currentNode node.Node) error {
-	identityPath := filepath.Join(currentNode.NodeDirectory(), ConfigsDirectory)
+func (d FileConfigurator) RemoveConfig(ctx context.Context, currentNode node.Node) error {
+	identityPath := currentNode.ConfigsDirectory()
 	fmt.Printf("Removing directory %q\n", identityPath)
 	return os.RemoveAll(identityPath)
 }
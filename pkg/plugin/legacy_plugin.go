@@ -0,0 +1,105 @@
+package plugin
+
+import (
+	"context"
+
+	"go.blockdaemon.com/bpm/sdk/pkg/node"
+)
+
+// LegacyPlugin is the plugin interface from before the plugin methods got a context
+//
+// Use FromLegacy to run such a plugin with Initialize.
+type LegacyPlugin interface {
+	Name() string
+	Meta() MetaInfo
+
+	ValidateParameters(currentNode node.Node) error
+	CreateIdentity(currentNode node.Node) error
+	RemoveIdentity(currentNode node.Node) error
+	Configure(currentNode node.Node) error
+	RemoveConfig(currentNode node.Node) error
+	SetUpEnvironment(currentNode node.Node) error
+	TearDownEnvironment(currentNode node.Node) error
+	Start(currentNode node.Node) error
+	Stop(currentNode node.Node) error
+	Status(currentNode node.Node) (string, error)
+	RemoveData(currentNode node.Node) error
+	RemoveRuntime(currentNode node.Node) error
+	Upgrade(currentNode node.Node) error
+	Test(currentNode node.Node) (bool, error)
+}
+
+// FromLegacy adapts a LegacyPlugin to the Plugin interface
+//
+// The context is ignored, legacy plugins therefore can't be interrupted cleanly.
+func FromLegacy(legacy LegacyPlugin) Plugin {
+	return legacyPlugin{legacy: legacy}
+}
+
+type legacyPlugin struct {
+	legacy LegacyPlugin
+}
+
+func (p legacyPlugin) Name() string {
+	return p.legacy.Name()
+}
+
+func (p legacyPlugin) Meta() MetaInfo {
+	return p.legacy.Meta()
+}
+
+func (p legacyPlugin) ValidateParameters(ctx context.Context, currentNode node.Node) error {
+	return p.legacy.ValidateParameters(currentNode)
+}
+
+func (p legacyPlugin) CreateIdentity(ctx context.Context, currentNode node.Node) error {
+	return p.legacy.CreateIdentity(currentNode)
+}
+
+func (p legacyPlugin) RemoveIdentity(ctx context.Context, currentNode node.Node) error {
+	return p.legacy.RemoveIdentity(currentNode)
+}
+
+func (p legacyPlugin) Configure(ctx context.Context, currentNode node.Node) error {
+	return p.legacy.Configure(currentNode)
+}
+
+func (p legacyPlugin) RemoveConfig(ctx context.Context, currentNode node.Node) error {
+	return p.legacy.RemoveConfig(currentNode)
+}
+
+func (p legacyPlugin) SetUpEnvironment(ctx context.Context, currentNode node.Node) error {
+	return p.legacy.SetUpEnvironment(currentNode)
+}
+
+func (p legacyPlugin) TearDownEnvironment(ctx context.Context, currentNode node.Node) error {
+	return p.legacy.TearDownEnvironment(currentNode)
+}
+
+func (p legacyPlugin) Start(ctx context.Context, currentNode node.Node) error {
+	return p.legacy.Start(currentNode)
+}
+
+func (p legacyPlugin) Stop(ctx context.Context, currentNode node.Node) error {
+	return p.legacy.Stop(currentNode)
+}
+
+func (p legacyPlugin) Status(ctx context.Context, currentNode node.Node) (string, error) {
+	return p.legacy.Status(currentNode)
+}
+
+func (p legacyPlugin) RemoveData(ctx context.Context, currentNode node.Node) error {
+	return p.legacy.RemoveData(currentNode)
+}
+
+func (p legacyPlugin) RemoveRuntime(ctx context.Context, currentNode node.Node) error {
+	return p.legacy.RemoveRuntime(currentNode)
+}
+
+func (p legacyPlugin) Upgrade(ctx context.Context, currentNode node.Node) error {
+	return p.legacy.Upgrade(currentNode)
+}
+
+func (p legacyPlugin) Test(ctx context.Context, currentNode node.Node) (bool, error) {
+	return p.legacy.Test(currentNode)
+}
@@ -1,35 +1,78 @@
 package plugin
 
 import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
 	"github.com/coreos/go-semver/semver"
 	"github.com/thoas/go-funk"
+	"go.blockdaemon.com/bpm/sdk/pkg/node"
 	"gopkg.in/yaml.v2"
 )
 
 const (
-	ParameterTypeBool   = "bool"
-	ParameterTypeString = "string"
+	ParameterTypeBool     = "bool"
+	ParameterTypeString   = "string"
+	ParameterTypeDuration = "duration"
 
-	SupportsTest     = "test"
-	SupportsUpgrade  = "upgrade"
-	SupportsIdentity = "identity"
+	SupportsTest        = "test"
+	SupportsUpgrade     = "upgrade"
+	SupportsIdentity    = "identity"
+	SupportsLogs        = "logs"
+	SupportsLogRotation = "rotate-logs"
+	SupportsConfigDiff  = "config-diff"
+	SupportsPullImages  = "pull-images"
+	SupportsBackup      = "backup"
+	SupportsPause       = "pause"
+	SupportsDrift       = "drift"
+	SupportsRestart     = "restart"
+	SupportsStats       = "stats"
+	SupportsCheck       = "check"
+	SupportsSBOM        = "sbom"
+	SupportsHealth      = "health"
+	SupportsCrashReport = "crash-report"
 )
 
 type Parameter struct {
-	Type        string
-	Name        string
-	Description string
-	Mandatory   bool
-	Default     string
+	Type        string `yaml:"type" json:"type"`
+	Name        string `yaml:"name" json:"name"`
+	Description string `yaml:"description" json:"description"`
+	Mandatory   bool   `yaml:"mandatory" json:"mandatory"`
+	Default     string `yaml:"default" json:"default"`
 }
 
 type MetaInfo struct {
-	Name            string
-	Version         string
-	Description     string
-	ProtocolVersion string `yaml:"protocol_version"`
-	Parameters      []Parameter
-	Supported       []string
+	Name            string      `yaml:"name" json:"name"`
+	Version         string      `yaml:"version" json:"version"`
+	Description     string      `yaml:"description" json:"description"`
+	ProtocolVersion string      `yaml:"protocol_version" json:"protocol_version"`
+	Parameters      []Parameter `yaml:"parameters" json:"parameters"`
+	Supported       []string    `yaml:"supported" json:"supported"`
+
+	SupportedParameters Parameters `yaml:"supported_parameters" json:"supported_parameters"`
+
+	// The minimum BPM version required to run this plugin, empty if any version works
+	MinBPMVersion string `yaml:"min_bpm_version" json:"min_bpm_version"`
+
+	// The free disk space in bytes the node data needs when starting, checked by the `check` command. 0 disables the check
+	MinFreeDiskSpace uint64 `yaml:"min_free_disk_space" json:"min_free_disk_space"`
+
+	// Parameters by name, built once by NewDockerPlugin
+	parametersByName parameterIndex
+}
+
+// parameterIndex looks up parameter definitions by name
+type parameterIndex map[string]Parameter
+
+func newParameterIndex(parameters []Parameter) parameterIndex {
+	index := make(parameterIndex, len(parameters))
+	for _, parameter := range parameters {
+		index[parameter.Name] = parameter
+	}
+
+	return index
 }
 
 func (p MetaInfo) String() string {
@@ -41,15 +84,139 @@ func (p MetaInfo) String() string {
 	return string(d)
 }
 
+// JSON returns the meta information in JSON format
+func (p MetaInfo) JSON() (string, error) {
+	d, err := json.MarshalIndent(&p, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	return string(d), nil
+}
+
 // Supports returns bool if a particular method is supported
 func (p MetaInfo) Supports(supported string) bool {
 	return funk.ContainsString(p.Supported, supported)
 }
 
+// ParameterByName returns the parameter with the given name
+//
+// The meta information of plugins created with NewDockerPlugin has an index of the parameters, the lookup takes
+// constant time. Otherwise the parameters are searched one by one.
+func (p MetaInfo) ParameterByName(name string) (Parameter, bool) {
+	if p.parametersByName != nil {
+		parameter, ok := p.parametersByName[name]
+		return parameter, ok
+	}
+
+	for _, parameter := range p.Parameters {
+		if parameter.Name == name {
+			return parameter, true
+		}
+	}
+
+	return Parameter{}, false
+}
+
+// Validate checks that the meta information is complete and consistent
+func (p MetaInfo) Validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("the plugin name is empty")
+	}
+
+	if p.Version == "" {
+		return fmt.Errorf("the version of plugin %q is empty", p.Name)
+	}
+
+	if _, err := semver.NewVersion(p.ProtocolVersion); err != nil {
+		return fmt.Errorf("the protocol version %q of plugin %q is not a valid semantic version: %s", p.ProtocolVersion, p.Name, err)
+	}
+
+	if p.MinBPMVersion != "" {
+		if _, err := semver.NewVersion(p.MinBPMVersion); err != nil {
+			return fmt.Errorf("the minimum BPM version %q of plugin %q is not a valid semantic version: %s", p.MinBPMVersion, p.Name, err)
+		}
+	}
+
+	names := map[string]bool{}
+	for _, parameter := range p.Parameters {
+		if names[parameter.Name] {
+			return fmt.Errorf("the parameter %q is defined more than once", parameter.Name)
+		}
+		names[parameter.Name] = true
+
+		if parameter.Mandatory && parameter.Default != "" {
+			return fmt.Errorf("the parameter %q is mandatory but has a default, a mandatory parameter must not have a default", parameter.Name)
+		}
+
+		if parameter.Type == ParameterTypeDuration && parameter.Default != "" {
+			if _, err := time.ParseDuration(parameter.Default); err != nil {
+				return fmt.Errorf("the default %q of parameter %q is not a valid duration", parameter.Default, parameter.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// IsCompatibleWithBPM returns true if the plugin can be run by the provided BPM version
+func (p MetaInfo) IsCompatibleWithBPM(bpmVersion string) (bool, error) {
+	if p.MinBPMVersion == "" {
+		return true, nil
+	}
+
+	minVersion, err := semver.NewVersion(p.MinBPMVersion)
+	if err != nil {
+		return false, fmt.Errorf("invalid minimum BPM version %q: %s", p.MinBPMVersion, err)
+	}
+
+	version, err := semver.NewVersion(bpmVersion)
+	if err != nil {
+		return false, fmt.Errorf("invalid BPM version %q: %s", bpmVersion, err)
+	}
+
+	return !version.LessThan(*minVersion), nil
+}
+
 // ProtocolVersionGreaterEqualThan return true if the protocol version is greater or equal to the provided version
-func (p MetaInfo) ProtocolVersionGreaterEqualThan(version string) bool {
-	v1 := semver.New(p.ProtocolVersion)
-	v2 := semver.New(version)
+//
+// An error is returned if either version is not a valid semantic version (e.g. "1.2" instead of "1.2.0").
+func (p MetaInfo) ProtocolVersionGreaterEqualThan(version string) (bool, error) {
+	v1, err := semver.NewVersion(p.ProtocolVersion)
+	if err != nil {
+		return false, fmt.Errorf("invalid protocol version %q: %s", p.ProtocolVersion, err)
+	}
+
+	v2, err := semver.NewVersion(version)
+	if err != nil {
+		return false, fmt.Errorf("invalid protocol version %q: %s", version, err)
+	}
+
+	return v2.LessThan(*v1) || v2.Equal(*v1), nil
+}
+
+// ApplyParameterDefaults sets all parameters with a default that are missing or empty in the node
+//
+// Bool parameters are only set if they are missing because false is a valid value. An error is returned if the
+// default of a bool parameter is not a valid bool.
+func ApplyParameterDefaults(n *node.Node, params []Parameter) error {
+	for _, parameter := range params {
+		if parameter.Default == "" {
+			continue
+		}
+
+		if parameter.Type == ParameterTypeBool {
+			value, err := strconv.ParseBool(parameter.Default)
+			if err != nil {
+				return fmt.Errorf("the default %q of parameter %q is not a valid bool", parameter.Default, parameter.Name)
+			}
+
+			n.SetBoolDefault(parameter.Name, value)
+			continue
+		}
+
+		n.SetStringDefault(parameter.Name, parameter.Default)
+	}
 
-	return v2.LessThan(*v1) || v2.Equal(*v1)
+	return nil
 }
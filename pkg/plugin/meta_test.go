@@ -0,0 +1,107 @@
+package plugin
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestProtocolVersionGreaterEqualThan(t *testing.T) {
+	meta := MetaInfo{ProtocolVersion: "1.2.0"}
+
+	testCases := map[string]bool{
+		"1.1.0": true,
+		"1.2.0": true,
+		"1.3.0": false,
+		"2.0.0": false,
+	}
+
+	for version, expected := range testCases {
+		t.Run(version, func(t *testing.T) {
+			greaterEqual, err := meta.ProtocolVersionGreaterEqualThan(version)
+			require.NoError(t, err)
+			assert.Equal(t, expected, greaterEqual)
+		})
+	}
+}
+
+func TestProtocolVersionGreaterEqualThanInvalidVersions(t *testing.T) {
+	testCases := map[string]struct {
+		protocolVersion string
+		version         string
+	}{
+		"empty argument":           {protocolVersion: "1.2.0", version: ""},
+		"partial argument":         {protocolVersion: "1.2.0", version: "1.2"},
+		"garbage argument":         {protocolVersion: "1.2.0", version: "not-a-version"},
+		"empty protocol version":   {protocolVersion: "", version: "1.2.0"},
+		"partial protocol version": {protocolVersion: "1", version: "1.2.0"},
+		"garbage protocol version": {protocolVersion: "1.x.0", version: "1.2.0"},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			meta := MetaInfo{ProtocolVersion: testCase.protocolVersion}
+
+			assert.NotPanics(t, func() {
+				_, err := meta.ProtocolVersionGreaterEqualThan(testCase.version)
+				assert.Error(t, err)
+			})
+		})
+	}
+}
+
+func testMetaInfo() MetaInfo {
+	return MetaInfo{
+		Name:            "test",
+		Version:         "1.0.0",
+		Description:     "A test plugin",
+		ProtocolVersion: "1.2.0",
+		Parameters: []Parameter{
+			{Name: "network", Type: ParameterTypeString, Description: "The network", Mandatory: true},
+			{Name: "archive", Type: ParameterTypeBool, Description: "Run an archive node", Default: "false"},
+		},
+		Supported:           []string{SupportsTest, SupportsUpgrade},
+		SupportedParameters: Parameters{Network: []string{"mainnet", "testnet"}},
+		MinBPMVersion:       "0.13.0",
+		MinFreeDiskSpace:    1024,
+	}
+}
+
+func TestMetaInfoJSONRoundTrip(t *testing.T) {
+	data, err := testMetaInfo().JSON()
+	require.NoError(t, err)
+
+	var meta MetaInfo
+	require.NoError(t, json.Unmarshal([]byte(data), &meta))
+	assert.Equal(t, testMetaInfo(), meta)
+
+	roundTripped, err := meta.JSON()
+	require.NoError(t, err)
+	assert.Equal(t, data, roundTripped)
+}
+
+func TestMetaInfoJSONAndYAMLFieldNames(t *testing.T) {
+	data, err := testMetaInfo().JSON()
+	require.NoError(t, err)
+
+	var fromJSON map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(data), &fromJSON))
+
+	var fromYAML map[string]interface{}
+	require.NoError(t, yaml.Unmarshal([]byte(testMetaInfo().String()), &fromYAML))
+
+	jsonKeys := []string{}
+	for key := range fromJSON {
+		jsonKeys = append(jsonKeys, key)
+	}
+
+	yamlKeys := []string{}
+	for key := range fromYAML {
+		yamlKeys = append(yamlKeys, key)
+	}
+
+	assert.ElementsMatch(t, yamlKeys, jsonKeys)
+}
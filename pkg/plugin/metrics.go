@@ -0,0 +1,149 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"text/template"
+
+	"go.blockdaemon.com/bpm/sdk/pkg/docker"
+	"go.blockdaemon.com/bpm/sdk/pkg/fileutil"
+	"go.blockdaemon.com/bpm/sdk/pkg/node"
+	"go.blockdaemon.com/bpm/sdk/pkg/progress"
+	sdktemplate "go.blockdaemon.com/bpm/sdk/pkg/template"
+)
+
+const (
+	metricsTargetsFile         = "prometheus-targets.json"
+	metricsAgentContainerImage = "prom/prometheus:v2.37.0"
+	metricsAgentContainerName  = "prometheus-agent"
+	metricsAgentConfigFile     = "prometheus.yml"
+	monitoringPackMetricsFile  = "prometheus.tpl"
+	metricsAgentBaseConfigTpl  = `global:
+  scrape_interval: 15s
+scrape_configs:
+- job_name: bpm
+  file_sd_configs:
+  - files:
+    - /monitoring/` + metricsTargetsFile + `
+`
+)
+
+// metricsTargetGroup is a target group in the prometheus file_sd_configs format
+type metricsTargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// writeMetricsTargets writes the metrics endpoints of all containers into a file that can be used by prometheus file_sd_configs
+//
+// The targets use the container names so they can be reached by everything running in the same docker network.
+func (d DockerLifecycleHandler) writeMetricsTargets(monitoringPath string, currentNode node.Node) error {
+	targetGroups := []metricsTargetGroup{}
+
+	for _, container := range d.containers {
+		if container.Metrics == nil {
+			continue
+		}
+
+		metricsPath := container.Metrics.Path
+		if metricsPath == "" {
+			metricsPath = "/metrics"
+		}
+
+		scheme := container.Metrics.Scheme
+		if scheme == "" {
+			scheme = "http"
+		}
+
+		targetGroups = append(targetGroups, metricsTargetGroup{
+			Targets: []string{fmt.Sprintf("%s%s:%s", currentNode.NamePrefix(), container.Name, container.Metrics.Port)},
+			Labels: map[string]string{
+				"__metrics_path__": metricsPath,
+				"__scheme__":       scheme,
+				"node_id":          currentNode.ID,
+				"plugin":           currentNode.PluginName,
+				"container":        container.Name,
+			},
+		})
+	}
+
+	if _, err := fileutil.MakeDirectory(monitoringPath); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(targetGroups, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(monitoringPath, metricsTargetsFile), data, 0644)
+}
+
+// renderMetricsConfig renders the configuration for the prometheus agent
+//
+// The agent scrapes all targets from the targets file. Where the metrics are sent to is defined in `prometheus.tpl`
+// in the monitoring pack, typically a `remote_write` section. Without a monitoring pack the metrics are only scraped.
+func (d DockerLifecycleHandler) renderMetricsConfig(ctx context.Context, monitoringPath string, currentNode node.Node) error {
+	metricsConfigTpl := metricsAgentBaseConfigTpl
+
+	if currentNode.StrParameters["monitoring-pack"] == "" {
+		fmt.Println("Forwarding of metrics is disabled. Specify `--monitoring-pack` to enable it.")
+	} else {
+		monitoringPackConfig, err := ioutil.ReadFile(filepath.Join(monitoringPath, monitoringPackMetricsFile))
+		if err != nil {
+			return fmt.Errorf("the monitoring pack needs to contain %q to collect metrics: %s", monitoringPackMetricsFile, err)
+		}
+		metricsConfigTpl = metricsConfigTpl + "\n" + string(monitoringPackConfig)
+	}
+
+	outputFilename := filepath.Join(monitoringPath, metricsAgentConfigFile)
+	tmpl, err := template.New(outputFilename).Parse(metricsConfigTpl)
+	if err != nil {
+		return err
+	}
+	output := bytes.NewBufferString("")
+	if err := tmpl.Execute(output, sdktemplate.TemplateData{Node: currentNode}); err != nil {
+		return err
+	}
+
+	if err := validateYAML(output.Bytes()); err != nil {
+		return fmt.Errorf("the rendered prometheus config is invalid: %s", err)
+	}
+
+	if err := ioutil.WriteFile(outputFilename, output.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	progress.Emit(ctx, d.ProgressEmitter, progress.StageRender, outputFilename, "rendered prometheus config", 100)
+
+	return nil
+}
+
+// metricsAgentContainer returns the container definition of the prometheus agent
+func metricsAgentContainer(client *docker.BasicManager) docker.Container {
+	return docker.Container{
+		Name:  metricsAgentContainerName,
+		Image: metricsAgentContainerImage,
+		Cmd: []string{
+			"--config.file=/etc/prometheus/prometheus.yml",
+			"--enable-feature=agent",
+		},
+		Mounts: []docker.Mount{
+			{
+				Type: "bind",
+				From: client.AddBasePath(path.Join("monitoring", metricsAgentConfigFile)),
+				To:   "/etc/prometheus/prometheus.yml",
+			},
+			{
+				Type: "bind",
+				From: client.AddBasePath("monitoring"),
+				To:   "/monitoring",
+			},
+		},
+	}
+}
@@ -0,0 +1,119 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.blockdaemon.com/bpm/sdk/pkg/docker"
+	"go.blockdaemon.com/bpm/sdk/pkg/node"
+)
+
+var (
+	containerRunningDesc = prometheus.NewDesc(
+		"bpm_container_running",
+		"Whether a node container is running (1) or not (0)",
+		[]string{"name"}, nil,
+	)
+	containerRestartsDesc = prometheus.NewDesc(
+		"bpm_container_restarts_total",
+		"Number of times docker restarted a node container",
+		[]string{"name"}, nil,
+	)
+	nodeStatusDesc = prometheus.NewDesc(
+		"bpm_node_status",
+		"The current node status has the value 1, all other statuses 0",
+		[]string{"status"}, nil,
+	)
+	nodeStatuses = []string{"running", "stopped", "incomplete", "paused", "unhealthy"}
+)
+
+// containerCollector collects the container and node status from docker every time the metrics are scraped
+type containerCollector struct {
+	handler     DockerLifecycleHandler
+	currentNode node.Node
+}
+
+// Describe implements prometheus.Collector
+func (c containerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- containerRunningDesc
+	ch <- containerRestartsDesc
+	ch <- nodeStatusDesc
+}
+
+// Collect implements prometheus.Collector
+func (c containerCollector) Collect(ch chan<- prometheus.Metric) {
+	client, err := docker.NewBasicManager(c.currentNode)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(containerRunningDesc, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, container := range c.handler.containers {
+		running, err := client.IsContainerRunning(ctx, container.Name)
+		if err != nil {
+			ch <- prometheus.NewInvalidMetric(containerRunningDesc, err)
+			continue
+		}
+
+		runningValue := 0.0
+		if running {
+			runningValue = 1.0
+		}
+		ch <- prometheus.MustNewConstMetric(containerRunningDesc, prometheus.GaugeValue, runningValue, container.Name)
+
+		restarts, err := client.ContainerRestartCount(ctx, container.Name)
+		if err != nil {
+			ch <- prometheus.NewInvalidMetric(containerRestartsDesc, err)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(containerRestartsDesc, prometheus.CounterValue, float64(restarts), container.Name)
+	}
+
+	status, err := c.handler.Status(ctx, c.currentNode)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(nodeStatusDesc, err)
+		return
+	}
+
+	for _, s := range nodeStatuses {
+		value := 0.0
+		if s == status {
+			value = 1.0
+		}
+		ch <- prometheus.MustNewConstMetric(nodeStatusDesc, prometheus.GaugeValue, value, s)
+	}
+}
+
+// ServeMetrics serves the container and node status as prometheus metrics on MetricsAddr under `/metrics`
+//
+// It blocks until the server fails or ctx is cancelled.
+func (d DockerLifecycleHandler) ServeMetrics(ctx context.Context, currentNode node.Node) error {
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(containerCollector{handler: d, currentNode: currentNode}); err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	server := &http.Server{Addr: d.MetricsAddr, Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	fmt.Printf("Serving metrics on '%s'\n", d.MetricsAddr)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
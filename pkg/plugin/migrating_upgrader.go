@@ -0,0 +1,21 @@
+package plugin
+
+import (
+	"go.blockdaemon.com/bpm/sdk/pkg/docker"
+)
+
+// MigratingUpgrader runs migrations between versions before upgrading the containers
+//
+// It is a DockerUpgrader configured with WithMigrations, see there for how the applicable migrations are chosen.
+type MigratingUpgrader struct {
+	DockerUpgrader
+}
+
+// NewMigratingUpgrader instantiates MigratingUpgrader
+//
+// The version is the plugin version the node should be upgraded to.
+func NewMigratingUpgrader(version string, containers []docker.Container, migrations []Migration) MigratingUpgrader {
+	return MigratingUpgrader{
+		DockerUpgrader: NewDockerUpgrader(containers, WithMigrations(version, migrations)),
+	}
+}
@@ -0,0 +1,116 @@
+package plugin
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go.blockdaemon.com/bpm/sdk/pkg/fileutil"
+	"go.blockdaemon.com/bpm/sdk/pkg/node"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	monitoringPackManifestFile = "manifest.yml"
+	monitoringPackConfigFile   = "config.tpl"
+
+	// The range of monitoring pack format versions supported by this SDK
+	minMonitoringPackVersion = 1
+	maxMonitoringPackVersion = 1
+)
+
+var yamlErrorLineRegexp = regexp.MustCompile(`line (\d+)`)
+
+// monitoringPackManifest describes the content of a monitoring pack
+//
+// Example manifest.yml:
+//
+//	version: 1
+//	files:
+//	- config.tpl
+//	- ca.crt
+type monitoringPackManifest struct {
+	Version int      `yaml:"version"`
+	Files   []string `yaml:"files"`
+}
+
+// extractMonitoringPack extracts and validates the monitoring pack if one is configured
+func extractMonitoringPack(monitoringPath string, currentNode node.Node) error {
+	monitoringPack := currentNode.StrParameters["monitoring-pack"]
+	if monitoringPack == "" {
+		return nil
+	}
+
+	if err := fileutil.ExtractTarGz(currentNode.ExpandPath(monitoringPack), monitoringPath); err != nil {
+		return err
+	}
+
+	return validateMonitoringPack(monitoringPath)
+}
+
+// validateMonitoringPack checks that an extracted monitoring pack has a supported format version and contains all files
+// listed in its manifest
+func validateMonitoringPack(monitoringPath string) error {
+	manifestPath := filepath.Join(monitoringPath, monitoringPackManifestFile)
+
+	exists, err := fileutil.FileExists(manifestPath)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("the monitoring pack does not contain a %q, please use a monitoring pack with format version %d to %d", monitoringPackManifestFile, minMonitoringPackVersion, maxMonitoringPackVersion)
+	}
+
+	manifestContent, err := ioutil.ReadFile(manifestPath)
+	if err != nil {
+		return err
+	}
+
+	var manifest monitoringPackManifest
+	if err := yaml.UnmarshalStrict(manifestContent, &manifest); err != nil {
+		return fmt.Errorf("cannot parse monitoring pack manifest %q: %s", manifestPath, err)
+	}
+
+	if manifest.Version < minMonitoringPackVersion || manifest.Version > maxMonitoringPackVersion {
+		return fmt.Errorf("unsupported monitoring pack format version %d, supported versions are %d to %d", manifest.Version, minMonitoringPackVersion, maxMonitoringPackVersion)
+	}
+
+	requiredFiles := append([]string{monitoringPackConfigFile}, manifest.Files...)
+	for _, requiredFile := range requiredFiles {
+		exists, err := fileutil.FileExists(filepath.Join(monitoringPath, requiredFile))
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("the monitoring pack is missing the file %q", requiredFile)
+		}
+	}
+
+	return nil
+}
+
+// validateYAML checks if content is valid YAML. If not, the returned error includes the offending line.
+func validateYAML(content []byte) error {
+	var parsed map[string]interface{}
+
+	err := yaml.Unmarshal(content, &parsed)
+	if err == nil {
+		return nil
+	}
+
+	matches := yamlErrorLineRegexp.FindStringSubmatch(err.Error())
+	if matches == nil {
+		return err
+	}
+
+	lineNumber, convErr := strconv.Atoi(matches[1])
+	lines := strings.Split(string(content), "\n")
+	if convErr != nil || lineNumber < 1 || lineNumber > len(lines) {
+		return err
+	}
+
+	return fmt.Errorf("%s, offending line %d: %q", err, lineNumber, lines[lineNumber-1])
+}
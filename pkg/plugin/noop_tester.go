@@ -0,0 +1,59 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"go.blockdaemon.com/bpm/sdk/pkg/node"
+)
+
+// NoopTester doesn't test anything and always passes
+//
+// This Tester can be used if the plugin doesn't have tests yet but should still support the test command
+type NoopTester struct{}
+
+// Test always returns true
+func (t NoopTester) Test(ctx context.Context, currentNode node.Node) (bool, error) {
+	fmt.Println("This package has no tests, skipping")
+	return true, nil
+}
+
+// NewNoopTester creates an instance of NoopTester
+func NewNoopTester() NoopTester {
+	return NoopTester{}
+}
+
+// DummyTester is the previous name of NoopTester
+//
+// Deprecated: Use NoopTester instead. Unlike before it passes instead of panicking.
+type DummyTester = NoopTester
+
+// NewDummyTester creates an instance of NoopTester
+//
+// Deprecated: Use NewNoopTester instead.
+func NewDummyTester() DummyTester {
+	return NewNoopTester()
+}
+
+// MustImplementTester returns tester and panics if it is nil
+//
+// This catches a missing Tester when the plugin is created instead of when the test command runs. A nil pointer
+// (or map, func, ...) wrapped in the Tester interface counts as nil as well.
+func MustImplementTester(tester Tester) Tester {
+	if tester == nil || isNilValue(reflect.ValueOf(tester)) {
+		panic("the plugin needs a Tester but none was provided")
+	}
+
+	return tester
+}
+
+// isNilValue returns true if value is of a kind that can be nil and is nil
+func isNilValue(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan, reflect.Interface:
+		return value.IsNil()
+	default:
+		return false
+	}
+}
@@ -0,0 +1,55 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.blockdaemon.com/bpm/sdk/pkg/node"
+)
+
+// ValidatorFunc allows using an ordinary function as ParameterValidator
+type ValidatorFunc func(ctx context.Context, currentNode node.Node) error
+
+// ValidateParameters calls f(ctx, currentNode)
+func (f ValidatorFunc) ValidateParameters(ctx context.Context, currentNode node.Node) error {
+	return f(ctx, currentNode)
+}
+
+// AllOf returns a validator that passes only if all validators pass
+//
+// The validators are run in order, the first error is returned.
+func AllOf(validators ...ParameterValidator) ParameterValidator {
+	return ValidatorFunc(func(ctx context.Context, currentNode node.Node) error {
+		for _, validator := range validators {
+			if err := validator.ValidateParameters(ctx, currentNode); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// AnyOf returns a validator that passes if at least one of the validators passes
+//
+// If all validators fail, the returned error contains all individual errors.
+func AnyOf(validators ...ParameterValidator) ParameterValidator {
+	return ValidatorFunc(func(ctx context.Context, currentNode node.Node) error {
+		if len(validators) == 0 {
+			return nil
+		}
+
+		errs := []string{}
+		for _, validator := range validators {
+			err := validator.ValidateParameters(ctx, currentNode)
+			if err == nil {
+				return nil
+			}
+
+			errs = append(errs, err.Error())
+		}
+
+		return fmt.Errorf("none of the validations passed: %s", strings.Join(errs, "; "))
+	})
+}
@@ -0,0 +1,46 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/thoas/go-funk"
+	"go.blockdaemon.com/bpm/sdk/pkg/node"
+)
+
+// Parameters enumerates the networks, protocols, etc. supported by a plugin
+//
+// Each field lists the valid values of the node parameter with the same name (e.g. `network-type` for NetworkType).
+// An empty list means that any value is accepted.
+type Parameters struct {
+	Network     []string `yaml:"network,omitempty" json:"network,omitempty"`
+	Protocol    []string `yaml:"protocol,omitempty" json:"protocol,omitempty"`
+	Subtype     []string `yaml:"subtype,omitempty" json:"subtype,omitempty"`
+	NetworkType []string `yaml:"network_type,omitempty" json:"network_type,omitempty"`
+}
+
+// Validate returns an error if a node uses a value that isn't supported
+func (p Parameters) Validate(currentNode node.Node) error {
+	supported := []struct {
+		name   string
+		values []string
+	}{
+		{"network", p.Network},
+		{"protocol", p.Protocol},
+		{"subtype", p.Subtype},
+		{"network-type", p.NetworkType},
+	}
+
+	for _, s := range supported {
+		value, ok := currentNode.StrParameters[s.name]
+		if !ok || len(s.values) == 0 {
+			continue
+		}
+
+		if !funk.ContainsString(s.values, value) {
+			return fmt.Errorf("the %s %q is not supported, supported values are: %s", s.name, value, strings.Join(s.values, ", "))
+		}
+	}
+
+	return nil
+}
@@ -5,66 +5,174 @@
 package plugin
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
 
+	"github.com/coreos/go-semver/semver"
+	"github.com/docker/go-units"
 	"github.com/spf13/cobra"
 	"github.com/thoas/go-funk"
+	"go.blockdaemon.com/bpm/sdk/pkg/docker"
+	"go.blockdaemon.com/bpm/sdk/pkg/docker/compose"
+	"go.blockdaemon.com/bpm/sdk/pkg/docker/image"
+	"go.blockdaemon.com/bpm/sdk/pkg/fileutil"
 	"go.blockdaemon.com/bpm/sdk/pkg/node"
+	"go.blockdaemon.com/bpm/sdk/pkg/plugin/command_middleware"
+	"go.blockdaemon.com/bpm/sdk/pkg/progress"
 )
 
 // ParameterValidator provides a function to validate the node parameters
 type ParameterValidator interface {
 	// ValidateParameters validates the ndoe parameters
-	ValidateParameters(currentNode node.Node) error
+	ValidateParameters(ctx context.Context, currentNode node.Node) error
 }
 
 // IdentityCreator provides functions to create and remove the identity (e.g. private keys) of a node
 type IdentityCreator interface {
 	// Function that creates the identity of a node
-	CreateIdentity(currentNode node.Node) error
+	CreateIdentity(ctx context.Context, currentNode node.Node) error
 
 	// Removes identity related to the node
-	RemoveIdentity(currentNode node.Node) error
+	RemoveIdentity(ctx context.Context, currentNode node.Node) error
 }
 
 // Configurator is the interface that wraps the Configure method
 type Configurator interface {
 	// Function that creates the configuration for the node
-	Configure(currentNode node.Node) error
+	Configure(ctx context.Context, currentNode node.Node) error
 
 	// Removes configuration related to the node
-	RemoveConfig(currentNode node.Node) error
+	RemoveConfig(ctx context.Context, currentNode node.Node) error
+}
+
+// EnvironmentManager provides functions to prepare and clean up the runtime environment of a node
+type EnvironmentManager interface {
+	// SetUpEnvironment prepares the runtime environment
+	SetUpEnvironment(ctx context.Context, currentNode node.Node) error
+	// TearDownEnvironment removes everything related to the node from the runtime environment
+	TearDownEnvironment(ctx context.Context, currentNode node.Node) error
 }
 
 // LifecycleHandler provides functions to manage a node
 type LifecycleHandler interface {
-	// SetUpEnvironment prepares the runtime environment
-	SetUpEnvironment(currentNode node.Node) error
+	EnvironmentManager
+
 	// Function to start a node
-	Start(currentNode node.Node) error
+	Start(ctx context.Context, currentNode node.Node) error
 	// Function to stop a running node
-	Stop(currentNode node.Node) error
-	// Function to return the status (running, incomplete, stopped) of a node
-	Status(currentNode node.Node) (string, error)
+	Stop(ctx context.Context, currentNode node.Node) error
+	// Function to return the status (running, incomplete, stopped, paused, unhealthy) of a node
+	Status(ctx context.Context, currentNode node.Node) (string, error)
 	// Removes any data (typically the blockchain itself) related to the node
-	RemoveData(currentNode node.Node) error
+	RemoveData(ctx context.Context, currentNode node.Node) error
 	// Removes everything other than data and configuration related to the node
-	RemoveRuntime(currentNode node.Node) error
-	// TearDownEnvironment removes everything related to the node from the runtime environment
-	TearDownEnvironment(currentNode node.Node) error
+	RemoveRuntime(ctx context.Context, currentNode node.Node) error
 }
 
 // Upgrader is the interface that wraps the Upgrade method
 type Upgrader interface {
 	// Function to upgrade a node with a new plugin version
-	Upgrade(currentNode node.Node) error
+	Upgrade(ctx context.Context, currentNode node.Node) error
 }
 
 // Tester is the interface that wraps the Test method
 type Tester interface {
 	// Function to test a node
-	Test(currentNode node.Node) (bool, error)
+	Test(ctx context.Context, currentNode node.Node) (bool, error)
+}
+
+// LogProvider is the interface that wraps the Logs method
+type LogProvider interface {
+	// Function that returns the last `tail` log lines of a container or of all containers if containerName is empty
+	Logs(ctx context.Context, currentNode node.Node, containerName string, tail int) (string, error)
+}
+
+// LogRotator is the interface that wraps the RotateLogs method
+type LogRotator interface {
+	// Function that makes the node rotate its log files
+	RotateLogs(ctx context.Context, currentNode node.Node) error
+}
+
+// ConfigDiffer is the interface that wraps the ConfigDiff method
+type ConfigDiffer interface {
+	// Function that returns the differences between the configuration on disk and freshly rendered configuration
+	ConfigDiff(ctx context.Context, currentNode node.Node) (string, error)
+}
+
+// ImagePuller is the interface that wraps the PullImages method
+type ImagePuller interface {
+	// Function that downloads all container images used by the node without changing the running node
+	PullImages(ctx context.Context, currentNode node.Node) error
+}
+
+// BackupProvider provides functions to back up and restore a node
+type BackupProvider interface {
+	// Function that copies everything needed to restore the node into dstDir
+	Backup(ctx context.Context, currentNode node.Node, dstDir string) error
+	// Function that restores the node from a backup in srcDir
+	Restore(ctx context.Context, currentNode node.Node, srcDir string) error
+}
+
+// Pauser provides functions to temporarily pause a node, e.g. during host maintenance
+type Pauser interface {
+	// Function that pauses the node without stopping it
+	Pause(ctx context.Context, currentNode node.Node) error
+	// Function that resumes a paused node
+	Resume(ctx context.Context, currentNode node.Node) error
+}
+
+// DriftDetector is the interface that wraps the Drift method
+type DriftDetector interface {
+	// Function that compares the deployed containers with the containers the plugin would create
+	Drift(ctx context.Context, currentNode node.Node) (*compose.ComposeDiff, error)
+}
+
+// StatsReporter is the interface that wraps the Stats method
+type StatsReporter interface {
+	// Function that returns the resource usage of the running containers of the node
+	Stats(ctx context.Context, currentNode node.Node) ([]docker.Stats, error)
+}
+
+// SBOMGenerator is the interface that wraps the SBOMs method
+type SBOMGenerator interface {
+	// Function that returns the software bill of materials of every image used by the node
+	SBOMs(ctx context.Context, currentNode node.Node) ([]*image.SBOM, error)
+}
+
+// HealthStatus describes the health of a node
+type HealthStatus struct {
+	Healthy bool                   `json:"healthy"`
+	Message string                 `json:"message"`
+	Details map[string]interface{} `json:"details"`
+}
+
+// HealthChecker is the interface that wraps the HealthCheck method
+type HealthChecker interface {
+	// Function that checks if the node works, e.g. whether it is in sync
+	HealthCheck(ctx context.Context, currentNode node.Node) (HealthStatus, error)
+}
+
+// CrashReporter is the interface that wraps the CrashingContainers method
+type CrashReporter interface {
+	// Function that returns the names of containers that are stuck in a restart loop
+	CrashingContainers(ctx context.Context, currentNode node.Node) ([]string, error)
+}
+
+// EnvironmentValidator is the interface that wraps the ValidateEnvironment method
+type EnvironmentValidator interface {
+	// Function that checks if the host can run the node, e.g. if required services are reachable
+	ValidateEnvironment(ctx context.Context, currentNode node.Node) error
+}
+
+// Restarter is the interface that wraps the Restart method
+type Restarter interface {
+	// Function that stops and starts the node again in one step
+	Restart(ctx context.Context, currentNode node.Node) error
 }
 
 // Plugin describes and provides the functionality for a plugin
@@ -82,169 +190,540 @@ type Plugin interface {
 	Tester
 }
 
+// defaultBPMHome is where BPM stores the nodes if BPM_HOME is not set
+const defaultBPMHome = "~/.bpm"
+
+// statusExitCodes maps the node status to the exit code of `status --exit-code`
+var statusExitCodes = map[string]int{
+	"running":    0,
+	"incomplete": 3,
+	"stopped":    4,
+	"paused":     5,
+	"unhealthy":  6,
+}
+
+// exitError is returned by a command to make the plugin exit with a specific code, e.g. by `status --exit-code`
+type exitError struct {
+	code int
+}
+
+func (e exitError) Error() string {
+	return fmt.Sprintf("exit code %d", e.code)
+}
+
+// saveVersion records the plugin version in the node file
+//
+// The node gets re-loaded because the plugin may have changed the node file in the meantime. A newer version
+// already recorded in the node file is never overwritten with an older one.
+func saveVersion(nodeFile string, version string) error {
+	currentNode, err := node.Load(nodeFile)
+	if err != nil {
+		return err
+	}
+
+	if currentNode.Version == version {
+		return nil
+	}
+
+	if currentNode.Version != "" {
+		installedVersion, err := semver.NewVersion(currentNode.Version)
+		if err == nil {
+			newVersion, err := semver.NewVersion(version)
+			if err != nil {
+				return fmt.Errorf("invalid plugin version %q: %s", version, err)
+			}
+
+			if newVersion.LessThan(*installedVersion) {
+				fmt.Printf("Node is already on version %s, not downgrading it to %s\n", currentNode.Version, version)
+				return nil
+			}
+		}
+	}
+
+	currentNode.Version = version
+
+	return currentNode.Save()
+}
+
+// checkProtocolVersion returns an error if the plugin doesn't support the required protocol version
+func checkProtocolVersion(meta MetaInfo, requiredProtocolVersion string) error {
+	if requiredProtocolVersion == "" {
+		return nil
+	}
+
+	supported, err := meta.ProtocolVersionGreaterEqualThan(requiredProtocolVersion)
+	if err != nil {
+		return err
+	}
+
+	if !supported {
+		return fmt.Errorf("the package implements protocol version %s but at least %s is required, please upgrade the package", meta.ProtocolVersion, requiredProtocolVersion)
+	}
+
+	return nil
+}
+
+// checkEnvironment checks the free disk space for the node data and runs the EnvironmentValidator of the plugin
+// if it has one
+func checkEnvironment(ctx context.Context, plugin Plugin, currentNode node.Node) error {
+	meta := plugin.Meta()
+
+	if meta.MinFreeDiskSpace > 0 {
+		free, err := fileutil.FreeDiskSpace(currentNode.DataDirectory())
+		if err != nil {
+			return fmt.Errorf("cannot determine the free disk space: %s", err)
+		}
+
+		if free < meta.MinFreeDiskSpace {
+			return fmt.Errorf("only %s of free disk space under %q but at least %s are required", units.BytesSize(float64(free)), currentNode.DataDirectory(), units.BytesSize(float64(meta.MinFreeDiskSpace)))
+		}
+	}
+
+	if environmentValidator, ok := plugin.(EnvironmentValidator); ok && funk.Contains(meta.Supported, SupportsCheck) {
+		return environmentValidator.ValidateEnvironment(ctx, currentNode)
+	}
+
+	return nil
+}
+
+// crashLogLines is the number of log lines `status --verbose` prints per crashing container
+const crashLogLines = 20
+
+// printCrashingContainers prints the crashing containers of a node and their last log lines to stderr
+func printCrashingContainers(ctx context.Context, plugin Plugin, currentNode node.Node) error {
+	crashReporter, ok := plugin.(CrashReporter)
+	if !ok || !funk.Contains(plugin.Meta().Supported, SupportsCrashReport) {
+		return nil
+	}
+
+	crashing, err := crashReporter.CrashingContainers(ctx, currentNode)
+	if err != nil {
+		return err
+	}
+
+	logProvider, hasLogs := plugin.(LogProvider)
+	hasLogs = hasLogs && funk.Contains(plugin.Meta().Supported, SupportsLogs)
+
+	for _, containerName := range crashing {
+		fmt.Fprintf(os.Stderr, "Container %q is crashing\n", containerName)
+
+		if !hasLogs {
+			continue
+		}
+
+		logs, err := logProvider.Logs(ctx, currentNode, containerName, crashLogLines)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "==> %s <==\n%s\n", containerName, logs)
+	}
+
+	return nil
+}
+
+// contextWithSignalHandling returns a context that gets cancelled on SIGINT or SIGTERM
+//
+// This gives the plugin methods a chance to stop cleanly instead of leaving e.g. half created containers behind.
+// A second signal exits immediately.
+func contextWithSignalHandling() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stop := command_middleware.HandleSignals(cancel)
+
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// defaultTimeout is the default for --timeout, long enough for slow operations like backups of large volumes
+const defaultTimeout = 1 * time.Hour
+
 // Initialize creates the CLI for a plugin
+//
+// All plugin methods get a context that is cancelled when the process receives SIGINT or SIGTERM or when the
+// duration set with --timeout is exceeded.
 func Initialize(plugin Plugin) {
+	if err := plugin.Meta().Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid plugin meta information: %s\n", err)
+		os.Exit(1)
+	}
+
+	ctx, cancel := contextWithSignalHandling()
+	defer cancel()
+
+	// Replaced once the --timeout flag has been parsed
+	timeoutCancel := context.CancelFunc(func() {})
+	defer func() { timeoutCancel() }()
+
 	// Initialize root command
+	var requiredProtocolVersion string
+	var timeout time.Duration
+	var progressFormat string
 	var rootCmd = &cobra.Command{
 		Use:          plugin.Name(),
 		Short:        plugin.Meta().Description,
 		SilenceUsage: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if timeout > 0 {
+				// The commands read ctx when they run, i.e. after this
+				ctx, timeoutCancel = context.WithTimeout(ctx, timeout)
+			}
+
+			switch progressFormat {
+			case "":
+			case "json":
+				// Human readable output stays on stdout
+				ctx = progress.ContextWithEmitter(ctx, progress.NewJSONEmitter(os.Stderr))
+			default:
+				return fmt.Errorf("unknown progress format %q, supported formats are: json", progressFormat)
+			}
+
+			return checkProtocolVersion(plugin.Meta(), requiredProtocolVersion)
+		},
+	}
+	rootCmd.PersistentFlags().StringVar(&requiredProtocolVersion, "required-protocol-version", os.Getenv("BPM_REQUIRED_PROTOCOL_VERSION"), "Fail if the plugin doesn't support at least this protocol version (env: BPM_REQUIRED_PROTOCOL_VERSION)")
+	rootCmd.PersistentFlags().DurationVar(&timeout, "timeout", defaultTimeout, "Abort the command if it takes longer than this, e.g. \"5m\", 0 disables the timeout")
+	rootCmd.PersistentFlags().StringVar(&progressFormat, "progress", "", "Write progress events in this format to stderr, e.g. for progress bars (supported: json)")
+
+	// Nodes can be passed either as <node-file> or using --node-id
+	var nodeID string
+	rootCmd.PersistentFlags().StringVar(&nodeID, "node-id", "", "Find the node file by node ID in $BPM_HOME (default "+defaultBPMHome+") instead of passing <node-file>")
+
+	errNodeFileAndNodeID := fmt.Errorf("pass either <node-file> or --node-id, not both")
+
+	nodeFileArgs := func(cmd *cobra.Command, args []string) error {
+		if nodeID != "" {
+			if len(args) > 0 {
+				return errNodeFileAndNodeID
+			}
+
+			return nil
+		}
+
+		return cobra.MinimumNArgs(1)(cmd, args)
+	}
+
+	findNodeFile := func(args []string) (string, error) {
+		if nodeID == "" {
+			return args[0], nil
+		}
+
+		bpmHome := os.Getenv("BPM_HOME")
+		if bpmHome == "" {
+			bpmHome = defaultBPMHome
+		}
+
+		return node.FindNodeFile(bpmHome, nodeID)
+	}
+
+	loadNode := func(args []string) (node.Node, error) {
+		nodeFile, err := findNodeFile(args)
+		if err != nil {
+			return node.Node{}, err
+		}
+
+		return node.Load(nodeFile)
 	}
 
+	// Commands that change the node are locked so they can't run concurrently for the same node
+	locked := command_middleware.Chain(func(next command_middleware.RunE) command_middleware.RunE {
+		return func(cmd *cobra.Command, args []string) error {
+			nodeFile, err := findNodeFile(args)
+			if err != nil {
+				return err
+			}
+
+			return command_middleware.WithLock(nodeFile)(next)(cmd, args)
+		}
+	})
+
 	// Create the commands
 	var validateParametersCmd = &cobra.Command{
 		Use:   "validate-parameters <node-file>",
 		Short: "Validates the parameters in the node file",
-		Args:  cobra.MinimumNArgs(1),
+		Args:  nodeFileArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			currentNode, err := node.Load(args[0])
+			currentNode, err := loadNode(args)
 			if err != nil {
 				return err
 			}
 
-			return plugin.ValidateParameters(currentNode)
+			if err := plugin.Meta().SupportedParameters.Validate(currentNode); err != nil {
+				return err
+			}
+
+			return plugin.ValidateParameters(ctx, currentNode)
 		},
 	}
 
-	var createConfigurationsCmd = &cobra.Command{
+	var createConfigurationsCmd = locked(&cobra.Command{
 		Use:   "create-configurations <node-file>",
 		Short: "Creates the configurations for a node",
-		Args:  cobra.MinimumNArgs(1),
+		Args:  nodeFileArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			currentNode, err := node.Load(args[0])
+			currentNode, err := loadNode(args)
 			if err != nil {
 				return err
 			}
 
-			return plugin.Configure(currentNode)
+			return plugin.Configure(ctx, currentNode)
 		},
-	}
+	})
 
-	var setUpEnvironmentCmd = &cobra.Command{
-		Use:   "set-up-environment <node-file>",
-		Short: "Sets up the runtime environment in which the node runs",
-		Args:  cobra.MinimumNArgs(1),
+	var setUpEnvironmentCmd = locked(&cobra.Command{
+		Use:     "set-up-environment <node-file>",
+		Aliases: []string{"setup-environment"},
+		Short:   "Sets up the runtime environment in which the node runs",
+		Args:    nodeFileArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			currentNode, err := node.Load(args[0])
+			currentNode, err := loadNode(args)
 			if err != nil {
 				return err
 			}
 
-			return plugin.SetUpEnvironment(currentNode)
+			return plugin.SetUpEnvironment(ctx, currentNode)
 		},
-	}
+	})
 
-	var tearDownEnvironmentCmd = &cobra.Command{
-		Use:   "tear-down-environment <node-file>",
-		Short: "Tears down the runtime environment in which the node runs",
-		Args:  cobra.MinimumNArgs(1),
+	var tearDownEnvironmentCmd = locked(&cobra.Command{
+		Use:     "tear-down-environment <node-file>",
+		Aliases: []string{"teardown-environment"},
+		Short:   "Tears down the runtime environment in which the node runs",
+		Args:    nodeFileArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			currentNode, err := node.Load(args[0])
+			currentNode, err := loadNode(args)
 			if err != nil {
 				return err
 			}
 
-			return plugin.TearDownEnvironment(currentNode)
+			return plugin.TearDownEnvironment(ctx, currentNode)
 		},
-	}
+	})
 
-	var startCmd = &cobra.Command{
+	var skipCheck bool
+	var startCmd = locked(&cobra.Command{
 		Use:   "start <node-file>",
 		Short: "Starts the node",
-		Args:  cobra.MinimumNArgs(1),
+		Args:  nodeFileArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			currentNode, err := loadNode(args)
+			if err != nil {
+				return err
+			}
+
+			if !skipCheck {
+				if err := checkEnvironment(ctx, plugin, currentNode); err != nil {
+					return fmt.Errorf("%s (use --skip-check to start anyway)", err)
+				}
+			}
+
+			if err := plugin.Start(ctx, currentNode); err != nil {
+				return err
+			}
+
+			// Only record the version for fresh installs, existing nodes get a new version through upgrades
+			if currentNode.Version != "" {
+				return nil
+			}
+
+			return saveVersion(currentNode.NodeFile(), plugin.Meta().Version)
+		},
+	})
+
+	startCmd.Flags().BoolVar(&skipCheck, "skip-check", false, "Don't check the host prerequisites before starting (see the check command)")
+
+	var checkCmd = &cobra.Command{
+		Use:   "check <node-file>",
+		Short: "Checks if the host can run the node, e.g. free disk space and whether docker is reachable",
+		Args:  nodeFileArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			currentNode, err := node.Load(args[0])
+			currentNode, err := loadNode(args)
 			if err != nil {
 				return err
 			}
 
-			return plugin.Start(currentNode)
+			if err := checkEnvironment(ctx, plugin, currentNode); err != nil {
+				return err
+			}
+
+			fmt.Println("All checks passed")
+			return nil
 		},
 	}
 
-	var stopCmd = &cobra.Command{
+	var stopCmd = locked(&cobra.Command{
 		Use:   "stop <node-file>",
 		Short: "Stops the node",
-		Args:  cobra.MinimumNArgs(1),
+		Args:  nodeFileArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			currentNode, err := node.Load(args[0])
+			currentNode, err := loadNode(args)
 			if err != nil {
 				return err
 			}
 
-			return plugin.Stop(currentNode)
+			return plugin.Stop(ctx, currentNode)
 		},
-	}
+	})
 
+	var statusExitCode bool
+	var statusVerbose bool
 	var statusCmd = &cobra.Command{
 		Use:   "status <node-file>",
 		Short: "Gives information about the current node status",
-		Args:  cobra.MinimumNArgs(1),
+		Long: `Gives information about the current node status (running, incomplete, stopped, paused, unhealthy).
+
+A node is unhealthy if a container is stuck in a restart loop. With --verbose the crashing containers and their
+last log lines are printed to stderr.
+
+With --exit-code the status is also reflected in the exit code:
+
+	0  running
+	1  error while getting the status
+	3  incomplete
+	4  stopped
+	5  paused
+	6  unhealthy
+`,
+		Args: nodeFileArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			currentNode, err := node.Load(args[0])
+			currentNode, err := loadNode(args)
 			if err != nil {
 				return err
 			}
 
-			output, err := plugin.Status(currentNode)
+			output, err := plugin.Status(ctx, currentNode)
 			if err != nil {
 				return err
 			}
 
 			fmt.Println(output)
+
+			if statusVerbose && output == "unhealthy" {
+				if err := printCrashingContainers(ctx, plugin, currentNode); err != nil {
+					return err
+				}
+			}
+
+			if !statusExitCode {
+				return nil
+			}
+
+			exitCode, ok := statusExitCodes[output]
+			if !ok {
+				return fmt.Errorf("unknown status %q", output)
+			}
+			if exitCode != 0 {
+				// The status was printed already, only the exit code is left to report
+				cmd.SilenceErrors = true
+				return exitError{code: exitCode}
+			}
+
 			return nil
 		},
 	}
+	statusCmd.Flags().BoolVar(&statusExitCode, "exit-code", false, "Exit with a status specific code (see help)")
+	statusCmd.Flags().BoolVar(&statusVerbose, "verbose", false, "Print the last log lines of crashing containers")
 
+	var metaOutput string
 	var metaInfoCmd = &cobra.Command{
 		Use:   "meta",
 		Short: "Shows meta information for this package",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch metaOutput {
+			case "yaml":
+				fmt.Println(plugin.Meta())
+			case "json":
+				data, err := plugin.Meta().JSON()
+				if err != nil {
+					return err
+				}
+				fmt.Println(data)
+			default:
+				return fmt.Errorf("unknown output format %q, supported formats are: yaml, json", metaOutput)
+			}
+
+			return nil
+		},
+	}
+	metaInfoCmd.Flags().StringVarP(&metaOutput, "output", "o", "yaml", "Output format (yaml, json)")
+
+	var versionOutput string
+	var versionCmd = &cobra.Command{
+		Use:   "version",
+		Short: "Shows the version of this package",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			meta := plugin.Meta()
+
+			switch versionOutput {
+			case "text":
+				fmt.Println(meta.Version)
+			case "json":
+				data, err := json.Marshal(map[string]string{
+					"version":          meta.Version,
+					"protocol_version": meta.ProtocolVersion,
+				})
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(data))
+			default:
+				return fmt.Errorf("unknown output format %q, supported formats are: text, json", versionOutput)
+			}
+
+			return nil
+		},
+	}
+	versionCmd.Flags().StringVarP(&versionOutput, "output", "o", "text", "Output format (text, json)")
+
+	var protocolVersionCmd = &cobra.Command{
+		Use:   "protocol-version",
+		Short: "Shows the plugin protocol version implemented by this package",
 		Run: func(cmd *cobra.Command, args []string) {
-			fmt.Println(plugin.Meta())
+			fmt.Println(plugin.Meta().ProtocolVersion)
 		},
 	}
 
-	var removeConfigCmd = &cobra.Command{
+	var removeConfigCmd = locked(&cobra.Command{
 		Use:   "remove-config <node-file>",
 		Short: "Removes the node configuration",
-		Args:  cobra.MinimumNArgs(1),
+		Args:  nodeFileArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			currentNode, err := node.Load(args[0])
+			currentNode, err := loadNode(args)
 			if err != nil {
 				return err
 			}
 
-			return plugin.RemoveConfig(currentNode)
+			return plugin.RemoveConfig(ctx, currentNode)
 		},
-	}
+	})
 
-	var removeDataCmd = &cobra.Command{
+	var removeDataCmd = locked(&cobra.Command{
 		Use:   "remove-data <node-file>",
 		Short: "Removes the node data (i.e. already synced blockchain)",
-		Args:  cobra.MinimumNArgs(1),
+		Args:  nodeFileArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			currentNode, err := node.Load(args[0])
+			currentNode, err := loadNode(args)
 			if err != nil {
 				return err
 			}
 
-			return plugin.RemoveData(currentNode)
+			return plugin.RemoveData(ctx, currentNode)
 		},
-	}
+	})
 
-	var removeRuntimeCmd = &cobra.Command{
+	var removeRuntimeCmd = locked(&cobra.Command{
 		Use:   "remove-runtime <node-file>",
 		Short: "Removes everything related to the node itself but no data, identity or configs",
-		Args:  cobra.MinimumNArgs(1),
+		Args:  nodeFileArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			currentNode, err := node.Load(args[0])
+			currentNode, err := loadNode(args)
 			if err != nil {
 				return err
 			}
 
-			return plugin.RemoveRuntime(currentNode)
+			return plugin.RemoveRuntime(ctx, currentNode)
 		},
-	}
+	})
 
 	rootCmd.AddCommand(
 		validateParametersCmd,
@@ -252,9 +731,12 @@ func Initialize(plugin Plugin) {
 		setUpEnvironmentCmd,
 		tearDownEnvironmentCmd,
 		startCmd,
+		checkCmd,
 		statusCmd,
 		stopCmd,
 		metaInfoCmd,
+		versionCmd,
+		protocolVersionCmd,
 		removeConfigCmd,
 		removeDataCmd,
 		removeRuntimeCmd,
@@ -264,14 +746,14 @@ func Initialize(plugin Plugin) {
 		var testCmd = &cobra.Command{
 			Use:   "test <node-file>",
 			Short: "Runs a test suite against the running node",
-			Args:  cobra.MinimumNArgs(1),
+			Args:  nodeFileArgs,
 			RunE: func(cmd *cobra.Command, args []string) error {
-				currentNode, err := node.Load(args[0])
+				currentNode, err := loadNode(args)
 				if err != nil {
 					return err
 				}
 
-				success, err := plugin.Test(currentNode)
+				success, err := plugin.Test(ctx, currentNode)
 
 				if err != nil {
 					return err
@@ -289,51 +771,55 @@ func Initialize(plugin Plugin) {
 	}
 
 	if funk.Contains(plugin.Meta().Supported, SupportsUpgrade) {
-		var upgradeCmd = &cobra.Command{
+		var upgradeCmd = locked(&cobra.Command{
 			Use:   "upgrade <node-file>",
 			Short: "Upgrades the node to a newer version of a package",
-			Args:  cobra.MinimumNArgs(1),
+			Args:  nodeFileArgs,
 			RunE: func(cmd *cobra.Command, args []string) error {
-				currentNode, err := node.Load(args[0])
+				currentNode, err := loadNode(args)
 				if err != nil {
 					return err
 				}
 
-				return plugin.Upgrade(currentNode)
+				if err := plugin.Upgrade(ctx, currentNode); err != nil {
+					return err
+				}
+
+				return saveVersion(currentNode.NodeFile(), plugin.Meta().Version)
 			},
-		}
+		})
 
 		rootCmd.AddCommand(upgradeCmd)
 	}
 
 	if funk.Contains(plugin.Meta().Supported, SupportsIdentity) {
-		var createIdentityCmd = &cobra.Command{
+		var createIdentityCmd = locked(&cobra.Command{
 			Use:   "create-identity <node-file>",
 			Short: "Creates the nodes identity (e.g. private keys, certificates, etc.)",
-			Args:  cobra.MinimumNArgs(1),
+			Args:  nodeFileArgs,
 			RunE: func(cmd *cobra.Command, args []string) error {
-				currentNode, err := node.Load(args[0])
+				currentNode, err := loadNode(args)
 				if err != nil {
 					return err
 				}
 
-				return plugin.CreateIdentity(currentNode)
+				return plugin.CreateIdentity(ctx, currentNode)
 			},
-		}
+		})
 
-		var removeIdentityCmd = &cobra.Command{
+		var removeIdentityCmd = locked(&cobra.Command{
 			Use:   "remove-identity <node-file>",
 			Short: "Removes the node identity",
-			Args:  cobra.MinimumNArgs(1),
+			Args:  nodeFileArgs,
 			RunE: func(cmd *cobra.Command, args []string) error {
-				currentNode, err := node.Load(args[0])
+				currentNode, err := loadNode(args)
 				if err != nil {
 					return err
 				}
 
-				return plugin.RemoveIdentity(currentNode)
+				return plugin.RemoveIdentity(ctx, currentNode)
 			},
-		}
+		})
 
 		rootCmd.AddCommand(
 			createIdentityCmd,
@@ -341,8 +827,375 @@ func Initialize(plugin Plugin) {
 		)
 	}
 
+	if logProvider, ok := plugin.(LogProvider); ok && funk.Contains(plugin.Meta().Supported, SupportsLogs) {
+		var containerName string
+		var tail int
+		var logsCmd = &cobra.Command{
+			Use:   "logs <node-file>",
+			Short: "Shows the latest logs of the node",
+			Args:  nodeFileArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				currentNode, err := loadNode(args)
+				if err != nil {
+					return err
+				}
+
+				output, err := logProvider.Logs(ctx, currentNode, containerName, tail)
+				if err != nil {
+					return err
+				}
+
+				fmt.Print(output)
+				return nil
+			},
+		}
+		logsCmd.Flags().StringVar(&containerName, "container", "", "Only show the logs of this container")
+		logsCmd.Flags().IntVar(&tail, "tail", 100, "Number of lines to show from the end of the logs")
+
+		rootCmd.AddCommand(logsCmd)
+	}
+
+	if logRotator, ok := plugin.(LogRotator); ok && funk.Contains(plugin.Meta().Supported, SupportsLogRotation) {
+		var rotateLogsCmd = locked(&cobra.Command{
+			Use:   "rotate-logs <node-file>",
+			Short: "Rotates the log files of the node",
+			Args:  nodeFileArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				currentNode, err := loadNode(args)
+				if err != nil {
+					return err
+				}
+
+				return logRotator.RotateLogs(ctx, currentNode)
+			},
+		})
+
+		rootCmd.AddCommand(rotateLogsCmd)
+	}
+
+	if configDiffer, ok := plugin.(ConfigDiffer); ok && funk.Contains(plugin.Meta().Supported, SupportsConfigDiff) {
+		var configDiffCmd = &cobra.Command{
+			Use:   "config-diff <node-file>",
+			Short: "Shows differences between the configuration on disk and the configuration templates",
+			Args:  nodeFileArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				currentNode, err := loadNode(args)
+				if err != nil {
+					return err
+				}
+
+				diff, err := configDiffer.ConfigDiff(ctx, currentNode)
+				if err != nil {
+					return err
+				}
+
+				if diff != "" {
+					fmt.Print(diff)
+					return fmt.Errorf("configuration differs") // this causes a non-zero exit code
+				}
+
+				return nil
+			},
+		}
+
+		rootCmd.AddCommand(configDiffCmd)
+	}
+
+	if driftDetector, ok := plugin.(DriftDetector); ok && funk.Contains(plugin.Meta().Supported, SupportsDrift) {
+		var driftCmd = &cobra.Command{
+			Use:   "drift <node-file>",
+			Short: "Shows differences between the deployed containers and the current plugin configuration",
+			Args:  nodeFileArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				currentNode, err := loadNode(args)
+				if err != nil {
+					return err
+				}
+
+				diff, err := driftDetector.Drift(ctx, currentNode)
+				if err != nil {
+					return err
+				}
+
+				if !diff.Empty() {
+					fmt.Print(diff)
+					return fmt.Errorf("deployed containers differ") // this causes a non-zero exit code
+				}
+
+				return nil
+			},
+		}
+
+		rootCmd.AddCommand(driftCmd)
+	}
+
+	if statsReporter, ok := plugin.(StatsReporter); ok && funk.Contains(plugin.Meta().Supported, SupportsStats) {
+		var statsCmd = &cobra.Command{
+			Use:   "stats <node-file>",
+			Short: "Shows the CPU, memory and network usage of the running containers",
+			Args:  nodeFileArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				currentNode, err := loadNode(args)
+				if err != nil {
+					return err
+				}
+
+				stats, err := statsReporter.Stats(ctx, currentNode)
+				if err != nil {
+					return err
+				}
+
+				w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+				fmt.Fprintln(w, "CONTAINER\tCPU %\tMEM USAGE / LIMIT\tNET RX / TX")
+				for _, s := range stats {
+					fmt.Fprintf(w, "%s\t%.2f%%\t%s / %s\t%s / %s\n",
+						s.Name,
+						s.CPUPercent,
+						units.BytesSize(float64(s.MemoryUsage)),
+						units.BytesSize(float64(s.MemoryLimit)),
+						units.HumanSize(float64(s.NetworkRx)),
+						units.HumanSize(float64(s.NetworkTx)),
+					)
+				}
+
+				return w.Flush()
+			},
+		}
+
+		rootCmd.AddCommand(statsCmd)
+	}
+
+	if sbomGenerator, ok := plugin.(SBOMGenerator); ok && funk.Contains(plugin.Meta().Supported, SupportsSBOM) {
+		var sbomCmd = &cobra.Command{
+			Use:   "sbom <node-file>",
+			Short: "Shows the software bill of materials (packages and licenses) of the images used by the node",
+			Args:  nodeFileArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				currentNode, err := loadNode(args)
+				if err != nil {
+					return err
+				}
+
+				sboms, err := sbomGenerator.SBOMs(ctx, currentNode)
+				if err != nil {
+					return err
+				}
+
+				w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+				fmt.Fprintln(w, "IMAGE\tNAME\tVERSION\tLICENSE\tPURL")
+				for _, sbom := range sboms {
+					for _, component := range sbom.Components {
+						fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", sbom.Image, component.Name, component.Version, component.License, component.PURL)
+					}
+				}
+
+				return w.Flush()
+			},
+		}
+
+		rootCmd.AddCommand(sbomCmd)
+	}
+
+	if healthChecker, ok := plugin.(HealthChecker); ok && funk.Contains(plugin.Meta().Supported, SupportsHealth) {
+		var healthOutput string
+		var healthCmd = &cobra.Command{
+			Use:   "health <node-file>",
+			Short: "Checks if the node is healthy, exits with a non-zero code if not",
+			Args:  nodeFileArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				currentNode, err := loadNode(args)
+				if err != nil {
+					return err
+				}
+
+				health, err := healthChecker.HealthCheck(ctx, currentNode)
+				if err != nil {
+					return err
+				}
+
+				switch healthOutput {
+				case "text":
+					fmt.Println(health.Message)
+				case "json":
+					data, err := json.MarshalIndent(health, "", "  ")
+					if err != nil {
+						return err
+					}
+					fmt.Println(string(data))
+				default:
+					return fmt.Errorf("unknown output format %q", healthOutput)
+				}
+
+				if !health.Healthy {
+					return fmt.Errorf("the node is not healthy") // this causes a non-zero exit code
+				}
+
+				return nil
+			},
+		}
+		healthCmd.Flags().StringVarP(&healthOutput, "output", "o", "text", "Output format (text, json)")
+
+		rootCmd.AddCommand(healthCmd)
+	}
+
+	if imagePuller, ok := plugin.(ImagePuller); ok && funk.Contains(plugin.Meta().Supported, SupportsPullImages) {
+		var pullImagesCmd = &cobra.Command{
+			Use:   "pull-images <node-file>",
+			Short: "Downloads the container images used by the node, e.g. to prepare an upgrade",
+			Args:  nodeFileArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				currentNode, err := loadNode(args)
+				if err != nil {
+					return err
+				}
+
+				return imagePuller.PullImages(ctx, currentNode)
+			},
+		}
+
+		rootCmd.AddCommand(pullImagesCmd)
+	}
+
+	if backupProvider, ok := plugin.(BackupProvider); ok && funk.Contains(plugin.Meta().Supported, SupportsBackup) {
+		// The directory is the last argument, with --node-id it's the only one
+		dirArgs := func(cmd *cobra.Command, args []string) error {
+			if nodeID != "" {
+				if len(args) == 2 {
+					return errNodeFileAndNodeID
+				}
+
+				return cobra.ExactArgs(1)(cmd, args)
+			}
+
+			return cobra.ExactArgs(2)(cmd, args)
+		}
+
+		var backupCmd = locked(&cobra.Command{
+			Use:   "backup <node-file> <dst-dir>",
+			Short: "Backs up the node including its configuration and data",
+			Args:  dirArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				currentNode, err := loadNode(args)
+				if err != nil {
+					return err
+				}
+
+				return backupProvider.Backup(ctx, currentNode, args[len(args)-1])
+			},
+		})
+
+		var restoreCmd = locked(&cobra.Command{
+			Use:   "restore <node-file> <src-dir>",
+			Short: "Restores the node from a backup",
+			Args:  dirArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				currentNode, err := loadNode(args)
+				if err != nil {
+					return err
+				}
+
+				return backupProvider.Restore(ctx, currentNode, args[len(args)-1])
+			},
+		})
+
+		rootCmd.AddCommand(backupCmd, restoreCmd)
+	}
+
+	if pauser, ok := plugin.(Pauser); ok && funk.Contains(plugin.Meta().Supported, SupportsPause) {
+		var pauseCmd = locked(&cobra.Command{
+			Use:   "pause <node-file>",
+			Short: "Pauses the node without stopping it",
+			Args:  nodeFileArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				currentNode, err := loadNode(args)
+				if err != nil {
+					return err
+				}
+
+				return pauser.Pause(ctx, currentNode)
+			},
+		})
+
+		var resumeCmd = locked(&cobra.Command{
+			Use:     "resume <node-file>",
+			Aliases: []string{"unpause"},
+			Short:   "Resumes a paused node",
+			Args:    nodeFileArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				currentNode, err := loadNode(args)
+				if err != nil {
+					return err
+				}
+
+				return pauser.Resume(ctx, currentNode)
+			},
+		})
+
+		rootCmd.AddCommand(pauseCmd, resumeCmd)
+	}
+
+	if restarter, ok := plugin.(Restarter); ok && funk.Contains(plugin.Meta().Supported, SupportsRestart) {
+		var restartCmd = locked(&cobra.Command{
+			Use:   "restart <node-file>",
+			Short: "Stops and starts the node again",
+			Args:  nodeFileArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				currentNode, err := loadNode(args)
+				if err != nil {
+					return err
+				}
+
+				return restarter.Restart(ctx, currentNode)
+			},
+		})
+
+		rootCmd.AddCommand(restartCmd)
+	}
+
+	var completionCmd = &cobra.Command{
+		Use:   "completion <bash|zsh|powershell>",
+		Short: "Generates a shell completion script",
+		Long: `Generates a shell completion script and prints it to stdout.
+
+To load the completions in the current bash session run:
+
+	source <(` + plugin.Name() + ` completion bash)
+`,
+		Args:      cobra.ExactArgs(1),
+		ValidArgs: []string{"bash", "zsh", "powershell"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch args[0] {
+			case "bash":
+				return rootCmd.GenBashCompletion(os.Stdout)
+			case "zsh":
+				return rootCmd.GenZshCompletion(os.Stdout)
+			case "powershell":
+				return rootCmd.GenPowerShellCompletion(os.Stdout)
+			default:
+				return fmt.Errorf("unsupported shell %q, supported shells are: bash, zsh, powershell", args[0])
+			}
+		},
+	}
+
+	rootCmd.AddCommand(completionCmd)
+
+	// Complete node files with file names. Bash already falls back to file names by default.
+	for _, cmd := range rootCmd.Commands() {
+		if strings.Contains(cmd.Use, "<node-file>") {
+			if err := cmd.MarkZshCompPositionalArgumentFile(1); err != nil {
+				panic(err) // Should never happen
+			}
+		}
+	}
+
 	// Start it all
 	if err := rootCmd.Execute(); err != nil {
+		timeoutCancel()
+		cancel()
+
+		if exitErr, ok := err.(exitError); ok {
+			os.Exit(exitErr.code)
+		}
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,200 @@
+// Package remote_configurator provides a configurator that fetches the configuration templates from a configuration server.
+package remote_configurator
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.blockdaemon.com/bpm/sdk/pkg/fileutil"
+	"go.blockdaemon.com/bpm/sdk/pkg/node"
+	"go.blockdaemon.com/bpm/sdk/pkg/plugin"
+	"go.blockdaemon.com/bpm/sdk/pkg/template"
+)
+
+const (
+	// CacheDirectory is the subdirectory under the node directory where fetched templates are cached
+	CacheDirectory = "template-cache"
+
+	etagSuffix = ".etag"
+)
+
+// HTTPAuth contains the credentials used to authenticate against the configuration server
+//
+// If Token is set it is sent as bearer token, otherwise Username and Password are used for basic auth
+// if Username is set. An empty HTTPAuth doesn't send any credentials.
+type HTTPAuth struct {
+	Username string
+	Password string
+	Token    string
+}
+
+func (a HTTPAuth) apply(req *http.Request) {
+	if a.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.Token)
+	} else if a.Username != "" {
+		req.SetBasicAuth(a.Username, a.Password)
+	}
+}
+
+// HTTPConfigurator creates configuration files from templates that are fetched from a configuration server
+//
+// Each template is fetched from `<baseURL>/<filename>` and cached in the node directory together with its ETag.
+// Subsequent runs (e.g. during `reconfigure`) send the ETag in an `If-None-Match` header and only download
+// templates that changed on the server. Configuration files are rewritten whenever their rendered content changed.
+type HTTPConfigurator struct {
+	baseURL   string
+	auth      HTTPAuth
+	filenames []string
+	client    *http.Client
+}
+
+// Configure fetches all templates and creates the configuration files for the blockchain client
+func (c HTTPConfigurator) Configure(ctx context.Context, currentNode node.Node) error {
+	// Create config directory if it doesn't exist yet
+	if _, err := fileutil.MakeDirectory(currentNode.ConfigsDirectory()); err != nil {
+		return err
+	}
+
+	cachePath, err := fileutil.MakeDirectory(currentNode.NodeDirectory(), CacheDirectory)
+	if err != nil {
+		return err
+	}
+
+	for _, filename := range c.filenames {
+		templateContent, err := c.fetch(ctx, filename, cachePath)
+		if err != nil {
+			return err
+		}
+
+		if err := configFileUpToDate(filename, templateContent, currentNode); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// configFileUpToDate renders a template and writes the result unless the file already has that content
+//
+// Unlike template.ConfigFileRendered existing files are overwritten, otherwise templates that changed on the
+// server would never be applied.
+func configFileUpToDate(filename, templateContent string, currentNode node.Node) error {
+	outputFilename := filepath.Join(currentNode.NodeDirectory(), filename)
+
+	output, err := template.RenderString(templateContent, template.TemplateData{Node: currentNode})
+	if err != nil {
+		return fmt.Errorf("cannot render '%s': %s", outputFilename, err)
+	}
+
+	existing, err := ioutil.ReadFile(outputFilename)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil && string(existing) == output {
+		fmt.Printf("File '%s' is up to date, skipping\n", outputFilename)
+		return nil
+	}
+
+	fmt.Printf("Writing file '%s'\n", outputFilename)
+
+	if err := os.MkdirAll(filepath.Dir(outputFilename), os.ModePerm); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(outputFilename, []byte(output), 0644)
+}
+
+// fetch returns the template content, either freshly downloaded or from the cache if it didn't change on the server
+func (c HTTPConfigurator) fetch(ctx context.Context, filename, cachePath string) (string, error) {
+	templateURL := strings.TrimSuffix(c.baseURL, "/") + "/" + filename
+
+	// Templates can be in subdirectories (e.g. configs/config.toml), flatten them for the cache
+	cacheFile := filepath.Join(cachePath, strings.Replace(filename, string(filepath.Separator), "_", -1))
+	etagFile := cacheFile + etagSuffix
+
+	req, err := http.NewRequest(http.MethodGet, templateURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	c.auth.apply(req)
+
+	cached, err := ioutil.ReadFile(cacheFile)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	if err == nil {
+		etag, err := ioutil.ReadFile(etagFile)
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		if len(etag) > 0 {
+			req.Header.Set("If-None-Match", string(etag))
+		}
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("cannot fetch template %q: %s", templateURL, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		fmt.Printf("Template '%s' did not change, using cached version\n", filename)
+		return string(cached), nil
+	case http.StatusOK:
+		// handled below
+	default:
+		return "", fmt.Errorf("cannot fetch template %q: server responded with %s", templateURL, resp.Status)
+	}
+
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("cannot fetch template %q: %s", templateURL, err)
+	}
+
+	fmt.Printf("Fetched template '%s'\n", templateURL)
+
+	if err := ioutil.WriteFile(cacheFile, content, 0644); err != nil {
+		return "", err
+	}
+
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		if err := ioutil.WriteFile(etagFile, []byte(etag), 0644); err != nil {
+			return "", err
+		}
+	} else if err := os.Remove(etagFile); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	return string(content), nil
+}
+
+// RemoveConfig removes configuration files related to the node
+//
+// The template cache is kept so a later Configure only downloads templates that changed.
+func (c HTTPConfigurator) RemoveConfig(ctx context.Context, currentNode node.Node) error {
+	configPath := currentNode.ConfigsDirectory()
+	fmt.Printf("Removing directory %q\n", configPath)
+	return os.RemoveAll(configPath)
+}
+
+// NewHTTPConfigurator creates an instance of HTTPConfigurator
+//
+// The filenames are the paths of the configuration files relative to the node directory, they are also
+// used as path of the templates on the server.
+func NewHTTPConfigurator(baseURL string, auth HTTPAuth, filenames ...string) plugin.Configurator {
+	return HTTPConfigurator{
+		baseURL:   baseURL,
+		auth:      auth,
+		filenames: filenames,
+		client:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
@@ -0,0 +1,122 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.blockdaemon.com/bpm/sdk/pkg/docker"
+	"go.blockdaemon.com/bpm/sdk/pkg/node"
+)
+
+const (
+	// How long a container without health check has to run without restarting to be considered ready
+	defaultReadyStablePeriod = 30 * time.Second
+)
+
+// SafeDockerUpgrader is an upgrade strategy for docker based nodes that keeps the downtime short
+//
+// Unlike DockerUpgrader it pulls all new images before touching the node and then recreates the containers one at
+// a time, waiting for each to become ready (see BasicManager.ContainerReady) before continuing with the next. If a
+// container doesn't become ready, all containers recreated so far are rolled back to the images they ran before.
+//
+// To use it, pass WithSafeUpgrades to NewDockerPlugin:
+//
+//	dockerPlugin := plugin.NewDockerPlugin(..., containers, plugin.WithSafeUpgrades(time.Minute))
+type SafeDockerUpgrader struct {
+	containers []docker.Container
+
+	// ReadyStablePeriod is how long a container without health check has to run without restarting to be
+	// considered ready. Defaults to 30 seconds.
+	ReadyStablePeriod time.Duration
+}
+
+// NewSafeDockerUpgrader instantiates SafeDockerUpgrader
+func NewSafeDockerUpgrader(containers []docker.Container) SafeDockerUpgrader {
+	return SafeDockerUpgrader{
+		containers:        containers,
+		ReadyStablePeriod: defaultReadyStablePeriod,
+	}
+}
+
+// Upgrade pre-pulls all images and recreates the containers one by one
+func (d SafeDockerUpgrader) Upgrade(ctx context.Context, currentNode node.Node) error {
+	client, err := docker.NewBasicManagerWithContext(ctx, currentNode)
+	if err != nil {
+		return err
+	}
+
+	// Pull all images while the node still runs
+	for _, container := range d.containers {
+		if err := client.ImagePulled(ctx, container); err != nil {
+			return err
+		}
+	}
+
+	snapshot, err := TakeContainerSnapshot(ctx, client, d.containers)
+	if err != nil {
+		return fmt.Errorf("cannot record the containers before upgrading: %s", err)
+	}
+
+	for _, container := range d.containers {
+		running, err := client.IsContainerRunning(ctx, container.Name)
+		if err != nil {
+			return err
+		}
+
+		if !running {
+			// Nothing to keep up, the container gets created with the new image on the next start
+			if err := client.ContainerAbsent(ctx, container); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := d.recreate(ctx, client, container); err != nil {
+			return d.rollback(client, snapshot, err)
+		}
+	}
+
+	return nil
+}
+
+// recreate removes a container, starts it again with the already pulled image and waits until it is ready
+func (d SafeDockerUpgrader) recreate(ctx context.Context, client *docker.BasicManager, container docker.Container) error {
+	if err := client.ContainerAbsent(ctx, container); err != nil {
+		return err
+	}
+
+	// The image was pulled already, don't pull it again
+	container.PullPolicy = docker.PullPolicyIfNotPresent
+
+	if err := client.ContainerRuns(ctx, container); err != nil {
+		return err
+	}
+
+	return client.ContainerReady(ctx, container.Name, d.stablePeriod())
+}
+
+// rollback recreates the containers that changed during the upgrade with their previous images
+//
+// The upgrade's context may be cancelled already, the rollback uses its own (see NewRollbackContext).
+func (d SafeDockerUpgrader) rollback(client *docker.BasicManager, snapshot ContainerSnapshot, upgradeErr error) error {
+	fmt.Printf("Upgrade failed, rolling back: %s\n", upgradeErr)
+
+	ctx, cancel := NewRollbackContext()
+	defer cancel()
+
+	if err := snapshot.Restore(ctx, client, d.stablePeriod()); err != nil {
+		return fmt.Errorf("upgrade failed: %s; rollback failed as well: %s", upgradeErr, err)
+	}
+
+	return fmt.Errorf("upgrade failed, rolled back to the previous images: %s", upgradeErr)
+}
+
+// stablePeriod returns ReadyStablePeriod or the default if it isn't set
+func (d SafeDockerUpgrader) stablePeriod() time.Duration {
+	if d.ReadyStablePeriod == 0 {
+		return defaultReadyStablePeriod
+	}
+
+	return d.ReadyStablePeriod
+}
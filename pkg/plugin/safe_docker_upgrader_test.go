@@ -0,0 +1,124 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.blockdaemon.com/bpm/sdk/pkg/docker"
+)
+
+func TestSafeDockerUpgraderUpgrades(t *testing.T) {
+	currentNode, cleanup := testNode(t)
+	defer cleanup()
+
+	fake := newFakeDocker(t)
+	defer fake.close()
+	fake.use(currentNode)
+
+	fake.addImage("sha256:client-1", "example.com/client:latest")
+	fake.pulls["example.com/client:latest"] = "sha256:client-2"
+	fake.addContainer(currentNode, "client", "sha256:client-1", true)
+
+	upgrader := NewSafeDockerUpgrader([]docker.Container{{Name: "client", Image: "example.com/client:latest"}})
+	require.NoError(t, upgrader.Upgrade(context.Background(), currentNode))
+
+	client, ok := fake.container(currentNode, "client")
+	require.True(t, ok)
+	assert.Equal(t, "sha256:client-2", client.image)
+	assert.True(t, client.running)
+}
+
+func TestSafeDockerUpgraderRollsBack(t *testing.T) {
+	currentNode, cleanup := testNode(t)
+	defer cleanup()
+
+	fake := newFakeDocker(t)
+	defer fake.close()
+	fake.use(currentNode)
+
+	// The tags are mutable, the rollback has to use the image IDs
+	fake.addImage("sha256:client-1", "example.com/client:latest")
+	fake.addImage("sha256:validator-1", "example.com/validator:latest")
+	fake.pulls["example.com/client:latest"] = "sha256:client-2"
+	fake.pulls["example.com/validator:latest"] = "sha256:validator-2"
+	fake.crashingImages["sha256:validator-2"] = true
+
+	fake.addContainer(currentNode, "client", "sha256:client-1", true)
+	fake.addContainer(currentNode, "validator", "sha256:validator-1", true)
+	fake.addContainer(currentNode, "stopped", "sha256:client-1", false)
+
+	upgrader := NewSafeDockerUpgrader([]docker.Container{
+		{Name: "client", Image: "example.com/client:latest"},
+		{Name: "stopped", Image: "example.com/client:latest"},
+		{Name: "validator", Image: "example.com/validator:latest"},
+	})
+	err := upgrader.Upgrade(context.Background(), currentNode)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "rolled back to the previous images")
+
+	for name, image := range map[string]string{"client": "sha256:client-1", "validator": "sha256:validator-1"} {
+		container, ok := fake.container(currentNode, name)
+		require.True(t, ok, name)
+		assert.Equal(t, image, container.image, name)
+		assert.True(t, container.running, name)
+	}
+
+	// Stopped containers are removed by the upgrade and get created with the new image on the next start
+	_, ok := fake.container(currentNode, "stopped")
+	assert.False(t, ok)
+}
+
+func TestSafeDockerUpgraderRollsBackWhenInterrupted(t *testing.T) {
+	currentNode, cleanup := testNode(t)
+	defer cleanup()
+
+	fake := newFakeDocker(t)
+	defer fake.close()
+	fake.use(currentNode)
+
+	fake.addImage("sha256:client-1", "example.com/client:latest")
+	fake.addImage("sha256:validator-1", "example.com/validator:latest")
+	fake.pulls["example.com/client:latest"] = "sha256:client-2"
+	fake.pulls["example.com/validator:latest"] = "sha256:validator-2"
+
+	fake.addContainer(currentNode, "client", "sha256:client-1", true)
+	fake.addContainer(currentNode, "validator", "sha256:validator-1", true)
+
+	// E.g. --timeout expires or Ctrl-C is pressed while the validator gets upgraded
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fake.started = func(name, image string) {
+		if image == "sha256:validator-2" {
+			cancel()
+		}
+	}
+
+	upgrader := NewSafeDockerUpgrader([]docker.Container{
+		{Name: "client", Image: "example.com/client:latest"},
+		{Name: "validator", Image: "example.com/validator:latest"},
+	})
+	err := upgrader.Upgrade(ctx, currentNode)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "rolled back to the previous images")
+
+	for name, image := range map[string]string{"client": "sha256:client-1", "validator": "sha256:validator-1"} {
+		container, ok := fake.container(currentNode, name)
+		require.True(t, ok, name)
+		assert.Equal(t, image, container.image, name)
+		assert.True(t, container.running, name)
+	}
+}
+
+func TestNewDockerPluginWithSafeUpgrades(t *testing.T) {
+	containers := []docker.Container{{Name: "client", Image: "example.com/client:latest"}}
+
+	dockerPlugin := NewDockerPlugin("test", "1.0.0", "A test plugin", nil, nil, containers)
+	assert.IsType(t, DockerUpgrader{}, dockerPlugin.Upgrader)
+
+	dockerPlugin = NewDockerPlugin("test", "1.0.0", "A test plugin", nil, nil, containers, WithSafeUpgrades(time.Minute))
+	require.IsType(t, SafeDockerUpgrader{}, dockerPlugin.Upgrader)
+	assert.Equal(t, time.Minute, dockerPlugin.Upgrader.(SafeDockerUpgrader).ReadyStablePeriod)
+}
@@ -1,32 +1,72 @@
 package plugin
 
 import (
+	"context"
 	"fmt"
+	"sort"
+	"strings"
 
 	"go.blockdaemon.com/bpm/sdk/pkg/node"
+	"go.blockdaemon.com/bpm/sdk/pkg/node/parameters"
 )
 
 // SimpleParameterValidator is a simple validator
 //
-// It checks if all parameters exist and if mandatory parameters have a value
+// It checks if all parameters exist with the right type, if mandatory parameters have a value and if durations are
+// valid
 type SimpleParameterValidator struct {
 	pluginParameters []Parameter
+	parametersByName parameterIndex
 }
 
 // ValidateParameters checks if mandatory parameters are passed in
-func (m SimpleParameterValidator) ValidateParameters(currentNode node.Node) error {
+func (m SimpleParameterValidator) ValidateParameters(ctx context.Context, currentNode node.Node) error {
+	validators := []ParameterValidator{ValidatorFunc(m.validateTypes)}
+
 	for _, parameter := range m.pluginParameters {
-		ok := false
+		validators = append(validators, parameterValidator(parameter))
+	}
 
-		if parameter.Type == ParameterTypeBool {
-			_, ok = currentNode.BoolParameters[parameter.Name]
+	return AllOf(validators...).ValidateParameters(ctx, currentNode)
+}
 
-			if !ok {
+// validateTypes checks that the node parameters defined by the plugin are passed with the right type
+//
+// Otherwise e.g. a bool parameter passed as string would only be reported as missing.
+func (m SimpleParameterValidator) validateTypes(ctx context.Context, currentNode node.Node) error {
+	mismatches := []string{}
+
+	for name := range currentNode.StrParameters {
+		if parameter, ok := m.parametersByName[name]; ok && parameter.Type == ParameterTypeBool {
+			mismatches = append(mismatches, fmt.Sprintf(`the parameter %q is a bool but was passed as string`, name))
+		}
+	}
+
+	for name := range currentNode.BoolParameters {
+		if parameter, ok := m.parametersByName[name]; ok && parameter.Type != ParameterTypeBool {
+			mismatches = append(mismatches, fmt.Sprintf(`the parameter %q is a %s but was passed as bool`, name, parameter.Type))
+		}
+	}
+
+	if len(mismatches) > 0 {
+		sort.Strings(mismatches)
+		return fmt.Errorf("%s", strings.Join(mismatches, "; "))
+	}
+
+	return nil
+}
+
+// parameterValidator returns the validator for a single plugin parameter
+func parameterValidator(parameter Parameter) ParameterValidator {
+	return ValidatorFunc(func(ctx context.Context, currentNode node.Node) error {
+		if parameter.Type == ParameterTypeBool {
+			if _, ok := currentNode.BoolParameters[parameter.Name]; !ok {
 				return fmt.Errorf(`the parameter %q is missing`, parameter.Name)
 			}
 		}
 
-		if parameter.Type == ParameterTypeString {
+		// Durations are stored as strings
+		if parameter.Type == ParameterTypeString || parameter.Type == ParameterTypeDuration {
 			value, ok := currentNode.StrParameters[parameter.Name]
 
 			if !ok {
@@ -41,17 +81,21 @@ func (m SimpleParameterValidator) ValidateParameters(currentNode node.Node) erro
 				if parameter.Default != "" {
 					return fmt.Errorf(`the parameter %q is empty but it should have a default`, parameter.Name)
 				}
+			} else if parameter.Type == ParameterTypeDuration {
+				if _, err := parameters.ParseDuration(currentNode, parameter.Name); err != nil {
+					return err
+				}
 			}
 		}
 
-	}
-
-	return nil
+		return nil
+	})
 }
 
 // NewSimpleParameterValidator creates an instance of SimpleParameterValidator
 func NewSimpleParameterValidator(pluginParameters []Parameter) SimpleParameterValidator {
 	return SimpleParameterValidator{
 		pluginParameters: pluginParameters,
+		parametersByName: newParameterIndex(pluginParameters),
 	}
 }
@@ -0,0 +1,51 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParameterByName(t *testing.T) {
+	parameters := []Parameter{
+		{Name: "network", Type: ParameterTypeString},
+		{Name: "archive", Type: ParameterTypeBool},
+	}
+
+	indexed := NewDockerPlugin("test", "1.0.0", "A test plugin", parameters, nil, nil).Meta()
+	require.NotNil(t, indexed.parametersByName)
+
+	for name, meta := range map[string]MetaInfo{"indexed": indexed, "not indexed": {Parameters: parameters}} {
+		t.Run(name, func(t *testing.T) {
+			parameter, ok := meta.ParameterByName("archive")
+			assert.True(t, ok)
+			assert.Equal(t, parameters[1], parameter)
+
+			_, ok = meta.ParameterByName("unknown")
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestSimpleParameterValidatorTypes(t *testing.T) {
+	validator := NewSimpleParameterValidator([]Parameter{
+		{Name: "network", Type: ParameterTypeString, Default: "mainnet"},
+		{Name: "archive", Type: ParameterTypeBool, Default: "false"},
+	})
+
+	currentNode, cleanup := testNode(t)
+	defer cleanup()
+
+	currentNode.StrParameters["network"] = "mainnet"
+	currentNode.BoolParameters["archive"] = true
+	assert.NoError(t, validator.ValidateParameters(context.Background(), currentNode))
+
+	delete(currentNode.StrParameters, "network")
+	delete(currentNode.BoolParameters, "archive")
+	currentNode.StrParameters["archive"] = "true"
+	currentNode.BoolParameters["network"] = true
+	assert.EqualError(t, validator.ValidateParameters(context.Background(), currentNode),
+		`the parameter "archive" is a bool but was passed as string; the parameter "network" is a string but was passed as bool`)
+}
@@ -0,0 +1,144 @@
+// Package test_runner runs the test cases of a plugin in parallel and reports the results.
+package test_runner
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// TestCase is a single named test
+type TestCase struct {
+	Name string
+	Fn   func(ctx context.Context) error
+}
+
+// TestResult is the outcome of a TestCase
+type TestResult struct {
+	Name     string
+	Passed   bool
+	Duration time.Duration
+	Error    error
+}
+
+// RunParallel runs the test cases with at most maxParallel cases at the same time
+//
+// A maxParallel of 0 or less runs all cases at once. The results are in the same order as the cases.
+// It returns true if all cases passed. An error is returned if ctx got cancelled before all cases ran.
+func RunParallel(ctx context.Context, cases []TestCase, maxParallel int) (bool, []TestResult, error) {
+	if maxParallel <= 0 || maxParallel > len(cases) {
+		maxParallel = len(cases)
+	}
+
+	results := make([]TestResult, len(cases))
+	slots := make(chan struct{}, maxParallel)
+	var wg sync.WaitGroup
+
+	for i, testCase := range cases {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return false, results[:i], fmt.Errorf("stopped running tests: %s", ctx.Err())
+		}
+
+		wg.Add(1)
+		go func(i int, testCase TestCase) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			results[i] = run(ctx, testCase)
+		}(i, testCase)
+	}
+
+	wg.Wait()
+
+	passed := true
+	for _, result := range results {
+		if !result.Passed {
+			passed = false
+			fmt.Printf("FAIL %s (%s): %s\n", result.Name, result.Duration, result.Error)
+		} else {
+			fmt.Printf("PASS %s (%s)\n", result.Name, result.Duration)
+		}
+	}
+
+	return passed, results, nil
+}
+
+// run runs a single test case, a panic counts as failure
+func run(ctx context.Context, testCase TestCase) (result TestResult) {
+	result.Name = testCase.Name
+	start := time.Now()
+
+	defer func() {
+		result.Duration = time.Since(start)
+
+		if r := recover(); r != nil {
+			result.Passed = false
+			result.Error = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	result.Error = testCase.Fn(ctx)
+	result.Passed = result.Error == nil
+
+	return result
+}
+
+type junitTestSuite struct {
+	XMLName  xml.Name        `xml:"testsuite"`
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name    string        `xml:"name,attr"`
+	Time    string        `xml:"time,attr"`
+	Failure *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+}
+
+// JUnitReport returns the results as JUnit XML report which is understood by most CI systems
+func JUnitReport(suiteName string, results []TestResult) ([]byte, error) {
+	suite := junitTestSuite{
+		Name:  suiteName,
+		Tests: len(results),
+	}
+
+	var total time.Duration
+	for _, result := range results {
+		testCase := junitTestCase{
+			Name: result.Name,
+			Time: fmt.Sprintf("%.3f", result.Duration.Seconds()),
+		}
+
+		if !result.Passed {
+			suite.Failures++
+			message := "failed"
+			if result.Error != nil {
+				message = result.Error.Error()
+			}
+			testCase.Failure = &junitFailure{Message: message}
+		}
+
+		total += result.Duration
+		suite.Cases = append(suite.Cases, testCase)
+	}
+	suite.Time = fmt.Sprintf("%.3f", total.Seconds())
+
+	output, err := xml.MarshalIndent(suite, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), output...), nil
+}
@@ -0,0 +1,82 @@
+// Package upgrade_rollback provides an Upgrader wrapper that restores the previous containers if an upgrade fails.
+package upgrade_rollback
+
+import (
+	"context"
+	"fmt"
+
+	"go.blockdaemon.com/bpm/sdk/pkg/docker"
+	"go.blockdaemon.com/bpm/sdk/pkg/node"
+	"go.blockdaemon.com/bpm/sdk/pkg/plugin"
+)
+
+// RollbackError is returned if the wrapped upgrade failed
+type RollbackError struct {
+	// The error returned by the wrapped Upgrader
+	UpgradeErr error
+	// The error that occurred during the rollback, nil if the rollback succeeded
+	RollbackErr error
+}
+
+// RolledBack returns true if the containers were restored successfully
+func (e RollbackError) RolledBack() bool {
+	return e.RollbackErr == nil
+}
+
+func (e RollbackError) Error() string {
+	if e.RolledBack() {
+		return fmt.Sprintf("upgrade failed, rolled back to the previous images: %s", e.UpgradeErr)
+	}
+
+	return fmt.Sprintf("upgrade failed: %s; rollback failed as well: %s", e.UpgradeErr, e.RollbackErr)
+}
+
+// rollbackUpgrader wraps an Upgrader and restores the previous container images if it fails
+type rollbackUpgrader struct {
+	upgrader   plugin.Upgrader
+	containers []docker.Container
+}
+
+// WithRollback wraps an Upgrader so that the containers are restored if the upgrade fails
+//
+// Before upgrading, the image IDs of the existing containers are recorded (see plugin.ContainerSnapshot). If the
+// wrapped upgrade fails, the changed containers get recreated with the recorded images and the ones that were running
+// before are started again. The container definitions are needed to recreate the containers with the same settings.
+// The rollback also runs if the upgrade was interrupted, see plugin.NewRollbackContext.
+func WithRollback(u plugin.Upgrader, containers []docker.Container) plugin.Upgrader {
+	return rollbackUpgrader{
+		upgrader:   u,
+		containers: containers,
+	}
+}
+
+// Upgrade runs the wrapped upgrade and rolls back if it fails
+//
+// A failed upgrade always returns a RollbackError.
+func (r rollbackUpgrader) Upgrade(ctx context.Context, currentNode node.Node) error {
+	client, err := docker.NewBasicManagerWithContext(ctx, currentNode)
+	if err != nil {
+		return err
+	}
+
+	snapshot, err := plugin.TakeContainerSnapshot(ctx, client, r.containers)
+	if err != nil {
+		return fmt.Errorf("cannot record the containers before upgrading: %s", err)
+	}
+
+	upgradeErr := r.upgrader.Upgrade(ctx, currentNode)
+	if upgradeErr == nil {
+		return nil
+	}
+
+	fmt.Printf("Upgrade failed, rolling back: %s\n", upgradeErr)
+
+	// The upgrade may have failed because ctx got cancelled, the rollback has to run anyway
+	rollbackCtx, cancel := plugin.NewRollbackContext()
+	defer cancel()
+
+	return RollbackError{
+		UpgradeErr:  upgradeErr,
+		RollbackErr: snapshot.Restore(rollbackCtx, client, 0),
+	}
+}
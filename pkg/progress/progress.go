@@ -0,0 +1,104 @@
+// Package progress publishes machine readable events about long running operations, e.g. to show progress bars.
+//
+// Events are sent to the emitter passed in explicitly (e.g. with docker.WithProgressEmitter) or, if there is none, to
+// the emitter attached to the context with ContextWithEmitter. By default they are discarded so only the human
+// readable output is shown.
+package progress
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// The stages of the events published by the SDK
+const (
+	StagePull   = "pull"
+	StageCreate = "create"
+	StageStart  = "start"
+	StageRender = "render"
+)
+
+// Event describes the progress of an operation on a resource
+type Event struct {
+	Timestamp time.Time `json:"timestamp"`
+	// What is happening, e.g. StagePull
+	Stage string `json:"stage"`
+	// What it is happening to, e.g. the image name
+	Resource string `json:"resource"`
+	Message  string `json:"message"`
+	// Progress of the stage from 0 to 100, -1 if unknown
+	Percent int `json:"percent"`
+}
+
+// Emitter receives progress events
+type Emitter interface {
+	Emit(event Event)
+}
+
+// NoopEmitter discards all events
+type NoopEmitter struct{}
+
+// Emit does nothing
+func (NoopEmitter) Emit(event Event) {}
+
+// JSONEmitter writes events as JSON lines
+type JSONEmitter struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+// NewJSONEmitter creates a JSONEmitter writing to w
+func NewJSONEmitter(w io.Writer) *JSONEmitter {
+	return &JSONEmitter{w: w}
+}
+
+// Emit writes the event as a single line of JSON
+//
+// Write errors are ignored, progress events must never make an operation fail.
+func (e *JSONEmitter) Emit(event Event) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.w.Write(append(data, '\n'))
+}
+
+type emitterKey struct{}
+
+// ContextWithEmitter returns a copy of ctx that carries the emitter
+func ContextWithEmitter(ctx context.Context, e Emitter) context.Context {
+	return context.WithValue(ctx, emitterKey{}, e)
+}
+
+// EmitterFromContext returns the emitter attached with ContextWithEmitter or a NoopEmitter
+func EmitterFromContext(ctx context.Context) Emitter {
+	if e, ok := ctx.Value(emitterKey{}).(Emitter); ok {
+		return e
+	}
+
+	return NoopEmitter{}
+}
+
+// Emit publishes an event with the current time to e
+//
+// If e is nil, the emitter attached to ctx is used.
+func Emit(ctx context.Context, e Emitter, stage, resource, message string, percent int) {
+	if e == nil {
+		e = EmitterFromContext(ctx)
+	}
+
+	e.Emit(Event{
+		Timestamp: time.Now(),
+		Stage:     stage,
+		Resource:  resource,
+		Message:   message,
+		Percent:   percent,
+	})
+}
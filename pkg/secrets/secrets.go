@@ -0,0 +1,259 @@
+// Package secrets encrypts files at rest, e.g. certificates or identity files in the node directory.
+//
+// Files are encrypted with AES-256-GCM using a key derived from a passphrase. The passphrase is read from the
+// file set in the `secrets-key-file` node parameter. Secrets that a container needs in plaintext can be decrypted
+// into memory backed storage (/dev/shm if available) and shredded again when the container stops. Because they are
+// decrypted on the host running the plugin, they can only be mounted if the docker daemon runs on the same host.
+package secrets
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.blockdaemon.com/bpm/sdk/pkg/node"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	// KeyFileParameter is the node parameter that contains the path to the passphrase file. Relative paths are
+	// relative to the node directory, `~` and environment variables are expanded.
+	KeyFileParameter = "secrets-key-file"
+
+	magic           = "BPMSEC1\n"
+	saltSize        = 16
+	keyCheckSize    = 16
+	kdfIterations   = 100000
+	keySize         = 32
+	keyCheckContext = "bpm-secrets-key-check"
+)
+
+var (
+	// ErrWrongPassphrase is returned if a secret was encrypted with a different passphrase
+	ErrWrongPassphrase = errors.New("the passphrase doesn't match the one the secret was encrypted with")
+	// ErrCorruptSecret is returned if a secret is not an encrypted secret or was modified after encrypting it
+	ErrCorruptSecret = errors.New("the secret is corrupt or not encrypted")
+)
+
+// Passphrase reads the passphrase from the file set in the `secrets-key-file` parameter
+//
+// A trailing newline in the file is not part of the passphrase.
+func Passphrase(currentNode node.Node) ([]byte, error) {
+	keyFile := currentNode.StrParameters[KeyFileParameter]
+	if keyFile == "" {
+		return nil, fmt.Errorf("the parameter %q is not set, it is required to use encrypted secrets", KeyFileParameter)
+	}
+
+	keyFile = currentNode.ExpandPath(keyFile)
+
+	passphrase, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the secrets key file: %s", err)
+	}
+
+	passphrase = bytes.TrimRight(passphrase, "\r\n")
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("the secrets key file %q is empty", keyFile)
+	}
+
+	return passphrase, nil
+}
+
+// WriteEncryptedSecret encrypts plaintext and writes it to path
+func WriteEncryptedSecret(path string, plaintext, passphrase []byte) error {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+
+	key := deriveKey(passphrase, salt)
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	// Layout: magic | salt | key check | nonce | ciphertext (including the authentication tag)
+	data := []byte(magic)
+	data = append(data, salt...)
+	data = append(data, keyCheck(key)...)
+	data = append(data, nonce...)
+	data = gcm.Seal(data, nonce, plaintext, []byte(magic))
+
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// ReadEncryptedSecret reads and decrypts a secret written by WriteEncryptedSecret
+//
+// It returns ErrWrongPassphrase if the passphrase is wrong and ErrCorruptSecret if the file is damaged.
+func ReadEncryptedSecret(path string, passphrase []byte) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.HasPrefix(data, []byte(magic)) || len(data) < len(magic)+saltSize+keyCheckSize {
+		return nil, ErrCorruptSecret
+	}
+	data = data[len(magic):]
+
+	salt := data[:saltSize]
+	check := data[saltSize : saltSize+keyCheckSize]
+	data = data[saltSize+keyCheckSize:]
+
+	key := deriveKey(passphrase, salt)
+
+	// The key check is stored separately so a wrong passphrase can be told apart from a damaged file
+	if !hmac.Equal(check, keyCheck(key)) {
+		return nil, ErrWrongPassphrase
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < gcm.NonceSize() {
+		return nil, ErrCorruptSecret
+	}
+
+	plaintext, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], []byte(magic))
+	if err != nil {
+		return nil, ErrCorruptSecret
+	}
+
+	return plaintext, nil
+}
+
+// PlaintextDirectory returns the directory into which the secrets of a node are decrypted
+//
+// It is in /dev/shm if available so the plaintext is never written to disk.
+func PlaintextDirectory(currentNode node.Node) string {
+	baseDir := "/dev/shm"
+	if info, err := os.Stat(baseDir); err != nil || !info.IsDir() {
+		baseDir = os.TempDir()
+	}
+
+	return filepath.Join(baseDir, "bpm-secrets-"+currentNode.ID)
+}
+
+// PlaintextPath returns the path to which the secret at secretPath is decrypted
+func PlaintextPath(currentNode node.Node, secretPath string) string {
+	name := strings.Trim(strings.Replace(filepath.Clean(secretPath), string(filepath.Separator), "_", -1), "_")
+	return filepath.Join(PlaintextDirectory(currentNode), name)
+}
+
+// PlaintextWritten decrypts the secret at secretPath to PlaintextPath and returns the path
+//
+// An existing plaintext file is overwritten in place so running containers that mount it keep seeing it.
+func PlaintextWritten(currentNode node.Node, secretPath string) (string, error) {
+	passphrase, err := Passphrase(currentNode)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := ReadEncryptedSecret(secretPath, passphrase)
+	if err != nil {
+		return "", fmt.Errorf("cannot decrypt %q: %s", secretPath, err)
+	}
+
+	if err := os.MkdirAll(PlaintextDirectory(currentNode), 0700); err != nil {
+		return "", err
+	}
+
+	plaintextPath := PlaintextPath(currentNode, secretPath)
+	if err := ioutil.WriteFile(plaintextPath, plaintext, 0600); err != nil {
+		return "", err
+	}
+
+	return plaintextPath, nil
+}
+
+// PlaintextsAbsent shreds all decrypted secrets of a node
+func PlaintextsAbsent(currentNode node.Node) error {
+	dir := PlaintextDirectory(currentNode)
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Nothing was decrypted, e.g. because the node doesn't use secrets
+			return nil
+		}
+
+		return err
+	}
+
+	fmt.Printf("Removing decrypted secrets in %q\n", dir)
+
+	for _, file := range files {
+		if err := shred(filepath.Join(dir, file.Name())); err != nil {
+			return err
+		}
+	}
+
+	return os.Remove(dir)
+}
+
+// shred overwrites a file with zeros before removing it
+func shred(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(make([]byte, info.Size())); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// keyCheck returns a value that identifies a key without revealing it
+func keyCheck(key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(keyCheckContext))
+	return mac.Sum(nil)[:keyCheckSize]
+}
+
+// deriveKey derives the encryption key from a passphrase using PBKDF2 with HMAC-SHA256 (RFC 8018)
+func deriveKey(passphrase, salt []byte) []byte {
+	return pbkdf2.Key(passphrase, salt, kdfIterations, keySize, sha256.New)
+}
@@ -11,6 +11,7 @@ import (
 
 	"go.blockdaemon.com/bpm/sdk/pkg/fileutil"
 	"go.blockdaemon.com/bpm/sdk/pkg/node"
+	"go.blockdaemon.com/bpm/sdk/pkg/node/parameters"
 )
 
 // TemplateData wraps the data send to the rendering engine
@@ -28,6 +29,17 @@ type TemplateData struct {
 //		"${{ $id }}"{{if notLast $index $.Config.core.quorum_set_ids}},{{end}}
 //		{{end -}}
 //
+// The template function `yamlParams` renders node parameters as YAML (see parameters.ToYAML).
+// It takes the node followed by pairs of parameter name and YAML key, without pairs all parameters are rendered:
+//
+//		{{ yamlParams .Node "network" "network" "data-dir" "datadir" }}
+//
+// Optional config sections can be rendered with `conditional` (see ConditionalBlock) or `ifParam`, which checks a
+// node parameter directly (see ifParam):
+//
+//		{{ conditional (index .Node.BoolParameters "enable-feature") "feature: true\n" "" }}
+//		{{ ifParam .Node "enable-feature" "feature: true\n" "" }}
+//
 func ConfigFileRendered(filepath, templateContent string, templateData TemplateData) error {
 	outputFilename := path.Join(templateData.Node.NodeDirectory(), filepath)
 
@@ -43,29 +55,81 @@ func ConfigFileRendered(filepath, templateContent string, templateData TemplateD
 
 	fmt.Printf("Writing file '%s'\n", outputFilename)
 
+	output, err := RenderString(templateContent, templateData)
+	if err != nil {
+		return fmt.Errorf("cannot render '%s': %s", outputFilename, err)
+	}
+
+	if err := ioutil.WriteFile(outputFilename, []byte(output), 0644); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RenderString renders a template with node configuration and returns the result
+//
+// It supports the same template functions as ConfigFileRendered.
+func RenderString(templateContent string, templateData TemplateData) (string, error) {
 	var templateFunctions = template.FuncMap{
 		"notLast": func(x int, a []interface{}) bool {
 			return x != len(a)-1
 		},
+		"yamlParams":  yamlParams,
+		"conditional": ConditionalBlock,
+		"ifParam":     ifParam,
 	}
 
-	tmpl, err := template.New(outputFilename).Funcs(templateFunctions).Parse(templateContent)
+	tmpl, err := template.New("").Funcs(templateFunctions).Parse(templateContent)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	output := bytes.NewBufferString("")
 
 	err = tmpl.Execute(output, templateData)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	if err := ioutil.WriteFile(outputFilename, output.Bytes(), 0644); err != nil {
-		return err
+	return output.String(), nil
+}
+
+// ConditionalBlock returns trueVal if condition is true and falseVal otherwise
+//
+// It is available as template function `conditional` to avoid many small if/end blocks for optional config sections.
+func ConditionalBlock(condition bool, trueVal, falseVal string) string {
+	if condition {
+		return trueVal
 	}
 
-	return nil
+	return falseVal
+}
+
+// ifParam returns trueVal if the node parameter key is enabled and falseVal otherwise
+//
+// A bool parameter is enabled if it is true, a string parameter if it is not empty.
+func ifParam(currentNode node.Node, key, trueVal, falseVal string) string {
+	return ConditionalBlock(currentNode.BoolParameters[key] || currentNode.StrParameters[key] != "", trueVal, falseVal)
+}
+
+// yamlParams is the template function wrapping parameters.ToYAML
+func yamlParams(currentNode node.Node, nameKeyPairs ...string) (string, error) {
+	if len(nameKeyPairs)%2 != 0 {
+		return "", fmt.Errorf("yamlParams expects pairs of parameter name and key, got %d arguments", len(nameKeyPairs))
+	}
+
+	keyMapping := map[string]string{}
+	for i := 0; i < len(nameKeyPairs); i += 2 {
+		keyMapping[nameKeyPairs[i]] = nameKeyPairs[i+1]
+	}
+
+	d, err := parameters.ToYAML(currentNode, keyMapping)
+	if err != nil {
+		return "", err
+	}
+
+	return string(d), nil
 }
 
 // ConfigFilesRendered renderes multiple templates to files
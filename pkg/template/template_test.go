@@ -0,0 +1,60 @@
+package template
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.blockdaemon.com/bpm/sdk/pkg/node"
+	"gopkg.in/yaml.v2"
+)
+
+func TestYAMLParamsRoundTrip(t *testing.T) {
+	currentNode := node.NewWithID("/tmp/node.json", "bmvd5i3e2bp5bhubhmpg")
+	currentNode.StrParameters = map[string]string{
+		"network":  "mainnet",
+		"data-dir": "/data # not a comment",
+	}
+	currentNode.BoolParameters = map[string]bool{
+		"archive": false,
+	}
+
+	testCases := map[string]struct {
+		template string
+		expected map[string]interface{}
+	}{
+		"all parameters": {
+			template: `{{ yamlParams .Node }}`,
+			expected: map[string]interface{}{
+				"network":  "mainnet",
+				"data-dir": "/data # not a comment",
+				"archive":  false,
+			},
+		},
+		"key mapping": {
+			template: `{{ yamlParams .Node "data-dir" "datadir" "archive" "archive" }}`,
+			expected: map[string]interface{}{
+				"datadir": "/data # not a comment",
+				"archive": false,
+			},
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			rendered, err := RenderString(testCase.template, TemplateData{Node: currentNode})
+			require.NoError(t, err)
+
+			var values map[string]interface{}
+			require.NoError(t, yaml.Unmarshal([]byte(rendered), &values))
+			assert.Equal(t, testCase.expected, values)
+		})
+	}
+}
+
+func TestYAMLParamsOddArguments(t *testing.T) {
+	currentNode := node.NewWithID("/tmp/node.json", "bmvd5i3e2bp5bhubhmpg")
+
+	_, err := RenderString(`{{ yamlParams .Node "network" }}`, TemplateData{Node: currentNode})
+	assert.Error(t, err)
+}